@@ -0,0 +1,119 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidToken = errors.New("invalid token")
+
+// Authenticator issues and verifies HMAC-SHA256 signed JSON Web Tokens.
+type Authenticator struct {
+	secret []byte
+	ttl    time.Duration
+	now    func() time.Time
+}
+
+// NewAuthenticator creates an Authenticator signing tokens with secret that expire after ttl.
+func NewAuthenticator(secret []byte, ttl time.Duration) *Authenticator {
+	return &Authenticator{secret: secret, ttl: ttl, now: time.Now}
+}
+
+// tokenHeader is the only JOSE header the Authenticator issues and accepts.
+const tokenHeader = `{"alg":"HS256","typ":"JWT"}`
+
+type tokenClaims struct {
+	Subject   string `json:"sub"`
+	Role      Role   `json:"role"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// AuthUser is the authenticated user of a request.
+// Role is the role the user had when the token was issued.
+type AuthUser struct {
+	ID   int
+	Role Role
+}
+
+// IssueToken returns a signed token for user and the time it expires at.
+func (a *Authenticator) IssueToken(user User) (string, time.Time, error) {
+	now := a.now()
+	expiresAt := now.Add(a.ttl)
+	claims, err := json.Marshal(tokenClaims{
+		Subject:   strconv.Itoa(user.ID),
+		Role:      user.Role,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expiresAt.Unix(),
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString([]byte(tokenHeader)) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	return signingInput + "." + a.sign(signingInput), expiresAt, nil
+}
+
+// ParseToken verifies the signature and expiry of token and returns the user it was issued for.
+func (a *Authenticator) ParseToken(token string) (AuthUser, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return AuthUser{}, errInvalidToken
+	}
+
+	header, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil || string(header) != tokenHeader {
+		return AuthUser{}, errInvalidToken
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(a.sign(parts[0]+"."+parts[1]))) {
+		return AuthUser{}, errInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return AuthUser{}, errInvalidToken
+	}
+	var claims tokenClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return AuthUser{}, errInvalidToken
+	}
+	if a.now().Unix() >= claims.ExpiresAt {
+		return AuthUser{}, errInvalidToken
+	}
+	id, err := strconv.Atoi(claims.Subject)
+	if err != nil || !claims.Role.Valid() {
+		return AuthUser{}, errInvalidToken
+	}
+
+	return AuthUser{ID: id, Role: claims.Role}, nil
+}
+
+func (a *Authenticator) sign(signingInput string) string {
+	mac := hmac.New(sha256.New, a.secret)
+	mac.Write([]byte(signingInput))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+type authUserKey struct{}
+
+// withAuthUser returns a copy of ctx carrying the authenticated user.
+func withAuthUser(ctx context.Context, user AuthUser) context.Context {
+	return context.WithValue(ctx, authUserKey{}, user)
+}
+
+// authUserFromContext returns the authenticated user of the request,
+// or an unauthorized error when the request carries no valid token.
+func authUserFromContext(ctx context.Context) (AuthUser, error) {
+	user, ok := ctx.Value(authUserKey{}).(AuthUser)
+	if !ok {
+		return AuthUser{}, newError(ErrUnauthorized, "authentication required")
+	}
+	return user, nil
+}
@@ -0,0 +1,160 @@
+package app
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAuthenticator(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2025, 3, 1, 12, 0, 0, 0, time.UTC)
+	auth := NewAuthenticator([]byte("secret"), time.Hour)
+	auth.now = func() time.Time { return now }
+
+	token, expiresAt, err := auth.IssueToken(User{ID: 42, Role: RoleSeller})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+	if want := now.Add(time.Hour); !expiresAt.Equal(want) {
+		t.Errorf("expected expiry %v, got %v", want, expiresAt)
+	}
+
+	other := NewAuthenticator([]byte("another secret"), time.Hour)
+	other.now = auth.now
+
+	expired := NewAuthenticator([]byte("secret"), time.Hour)
+	expired.now = func() time.Time { return now.Add(2 * time.Hour) }
+
+	parts := strings.Split(token, ".")
+	forgedClaims := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1","iat":0,"exp":9999999999}`)) + "." + parts[2]
+	algNone := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","typ":"JWT"}`)) + "." + parts[1] + "."
+
+	cases := map[string]struct {
+		auth  *Authenticator
+		token string
+		want  AuthUser
+		err   bool
+	}{
+		"ok: valid token": {
+			auth:  auth,
+			token: token,
+			want:  AuthUser{ID: 42, Role: RoleSeller},
+		},
+		"ng: signed with another secret": {
+			auth:  other,
+			token: token,
+			err:   true,
+		},
+		"ng: expired": {
+			auth:  expired,
+			token: token,
+			err:   true,
+		},
+		"ng: tampered claims": {
+			auth:  auth,
+			token: forgedClaims,
+			err:   true,
+		},
+		"ng: unsigned token": {
+			auth:  auth,
+			token: algNone,
+			err:   true,
+		},
+		"ng: garbage": {
+			auth:  auth,
+			token: "garbage",
+			err:   true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := tt.auth.ParseToken(tt.token)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.err {
+				t.Fatalf("expected an error, got user %+v", got)
+			}
+			if got != tt.want {
+				t.Errorf("expected user %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestAuthMiddleware(t *testing.T) {
+	t.Parallel()
+
+	auth := NewAuthenticator([]byte("secret"), time.Hour)
+	token, _, err := auth.IssueToken(User{ID: 7, Role: RoleBuyer})
+	if err != nil {
+		t.Fatalf("failed to issue token: %v", err)
+	}
+
+	type wants struct {
+		code int
+		body string
+	}
+	cases := map[string]struct {
+		header string
+		wants
+	}{
+		"ok: authenticated": {
+			header: "Bearer " + token,
+			wants:  wants{code: http.StatusOK, body: "user 7"},
+		},
+		"ok: anonymous": {
+			header: "",
+			wants:  wants{code: http.StatusOK, body: "anonymous"},
+		},
+		"ng: invalid token": {
+			header: "Bearer " + token + "x",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+		"ng: not a bearer token": {
+			header: "Basic dXNlcjpwYXNz",
+			wants:  wants{code: http.StatusUnauthorized},
+		},
+	}
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := authUserFromContext(r.Context())
+		if err != nil {
+			w.Write([]byte("anonymous"))
+			return
+		}
+		w.Write([]byte("user " + strconv.Itoa(user.ID)))
+	})
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			rr := httptest.NewRecorder()
+			authMiddleware(next, auth).ServeHTTP(rr, req)
+
+			if rr.Code != tt.wants.code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.body != "" && rr.Body.String() != tt.wants.body {
+				t.Errorf("expected body %q, got %q", tt.wants.body, rr.Body.String())
+			}
+		})
+	}
+}
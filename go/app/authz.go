@@ -0,0 +1,83 @@
+package app
+
+// Role is what a user is allowed to do on the marketplace.
+type Role string
+
+const (
+	// RoleBuyer can browse and buy items.
+	RoleBuyer Role = "buyer"
+	// RoleSeller can also list items and manage their own listings.
+	RoleSeller Role = "seller"
+	// RoleAdmin can manage every listing.
+	RoleAdmin Role = "admin"
+)
+
+// Valid reports whether r is a known role.
+func (r Role) Valid() bool {
+	switch r {
+	case RoleBuyer, RoleSeller, RoleAdmin:
+		return true
+	}
+	return false
+}
+
+// Handlers consult the functions below before mutating an ItemRepository.
+// They return nil when the user is allowed to perform the action, and a forbidden error otherwise.
+
+// authorizeCreateItem checks that user may list a new item.
+func authorizeCreateItem(user AuthUser) error {
+	if user.Role == RoleSeller || user.Role == RoleAdmin {
+		return nil
+	}
+	return newError(ErrForbidden, "only sellers can list items")
+}
+
+// authorizeModifyItem checks that user may edit or delete item.
+// Only the seller of the item or an admin may do so.
+func authorizeModifyItem(user AuthUser, item Item) error {
+	if user.Role == RoleAdmin {
+		return nil
+	}
+	if item.SellerID != 0 && item.SellerID == user.ID {
+		return nil
+	}
+	return newError(ErrForbidden, "only the seller of the item can modify it")
+}
+
+// authorizePurchaseItem checks that user may purchase item.
+// Any signed-in user may buy, except the seller of the item.
+func authorizePurchaseItem(user AuthUser, item Item) error {
+	if item.SellerID != 0 && item.SellerID == user.ID {
+		return newError(ErrForbidden, "sellers cannot purchase their own items")
+	}
+	return nil
+}
+
+// authorizeCompleteTrade checks that user may complete the trade of order.
+// The buyer completes a trade once they have received the item, and an admin may do so for them.
+func authorizeCompleteTrade(user AuthUser, order Order) error {
+	if user.Role == RoleAdmin || order.BuyerID == user.ID {
+		return nil
+	}
+	return newError(ErrForbidden, "only the buyer can complete the trade")
+}
+
+// authorizeCancelTrade checks that user may cancel the trade of order for item.
+// Either side of the trade or an admin may do so.
+func authorizeCancelTrade(user AuthUser, item Item, order Order) error {
+	if user.Role == RoleAdmin || order.BuyerID == user.ID {
+		return nil
+	}
+	if item.SellerID != 0 && item.SellerID == user.ID {
+		return nil
+	}
+	return newError(ErrForbidden, "only the seller or the buyer can cancel the trade")
+}
+
+// authorizeManageCategories checks that user may create, edit, merge or delete categories.
+func authorizeManageCategories(user AuthUser) error {
+	if user.Role == RoleAdmin {
+		return nil
+	}
+	return newError(ErrForbidden, "only admins can manage categories")
+}
@@ -0,0 +1,361 @@
+package app
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the configuration of the server.
+//
+// Each setting is resolved from, in increasing order of precedence:
+//
+//  1. its default, from DefaultConfig
+//  2. the YAML file named by the -config flag or the CONFIG_FILE environment variable, if any
+//  3. its environment variable
+//  4. its command-line flag
+//
+// Secrets and the S3 bucket they go with have no flag, so that they do not show up in the process list.
+// See configSettings and configEnvOnly for the names of every setting.
+type Config struct {
+	// Port is the port number to listen on.
+	Port string `yaml:"port"`
+	// DBPath is the path to the SQLite database.
+	DBPath string `yaml:"db_path"`
+	// ImageDir is the directory storing images when they are kept on the local disk.
+	ImageDir string `yaml:"image_dir"`
+
+	Log struct {
+		Level slog.Level `yaml:"level"`
+		// Format is json or text.
+		Format string `yaml:"format"`
+	} `yaml:"log"`
+
+	CORS struct {
+		// Origins are the origins of the front ends allowed to call the API, or "*" for any.
+		Origins []string `yaml:"origins"`
+	} `yaml:"cors"`
+
+	Auth struct {
+		// Secret signs the authentication tokens. A random one is generated when it is empty,
+		// which makes every issued token invalid when the server restarts.
+		Secret   string        `yaml:"secret"`
+		TokenTTL time.Duration `yaml:"token_ttl"`
+	} `yaml:"auth"`
+
+	// StrictCategories rejects items in categories that were not created through POST /categories.
+	StrictCategories bool `yaml:"strict_categories"`
+
+	Images struct {
+		// Store is file, to keep images in ImageDir, or s3, to keep them in the bucket described by S3.
+		Store string   `yaml:"store"`
+		S3    S3Config `yaml:"s3"`
+		// MaxSize is the size limit of an uploaded image in bytes.
+		MaxSize int64 `yaml:"max_size"`
+		// GCInterval is how often orphaned images are deleted, or 0 not to delete them.
+		GCInterval    time.Duration `yaml:"gc_interval"`
+		GCGracePeriod time.Duration `yaml:"gc_grace_period"`
+	} `yaml:"images"`
+
+	// Timeouts are those of the HTTP server; 0 means no timeout.
+	Timeouts struct {
+		ReadHeader time.Duration `yaml:"read_header"`
+		Read       time.Duration `yaml:"read"`
+		Write      time.Duration `yaml:"write"`
+		Idle       time.Duration `yaml:"idle"`
+		// ShutdownDelay is how long the server keeps serving with /readyz failing when it is asked to stop,
+		// so that load balancers stop sending it requests before the connections are drained.
+		ShutdownDelay time.Duration `yaml:"shutdown_delay"`
+		// Shutdown is how long requests in flight are waited for when the server stops.
+		Shutdown time.Duration `yaml:"shutdown"`
+	} `yaml:"timeouts"`
+
+	Tracing struct {
+		// Exporter is where the spans go: none, stdout or otlp.
+		Exporter string `yaml:"exporter"`
+		// OTLPEndpoint is the URL of the collector the otlp exporter sends the spans to, such as
+		// http://localhost:4318. When it is empty, the OTEL_EXPORTER_OTLP_* environment variables apply.
+		OTLPEndpoint string `yaml:"otlp_endpoint"`
+	} `yaml:"tracing"`
+}
+
+// DefaultConfig returns the configuration of the server when nothing is set, fit for local development.
+func DefaultConfig() *Config {
+	c := &Config{
+		Port:     "9000",
+		DBPath:   "db/mercari.sqlite3",
+		ImageDir: "images",
+	}
+	c.Log.Level = slog.LevelInfo
+	c.Log.Format = "json"
+	c.CORS.Origins = []string{"http://localhost:3000"}
+	c.Auth.TokenTTL = 24 * time.Hour
+	c.Images.Store = "file"
+	c.Images.MaxSize = defaultMaxImageSize
+	c.Images.GCGracePeriod = DefaultImageGCGracePeriod
+	c.Timeouts.ReadHeader = 5 * time.Second
+	// uploads of several large images over slow connections take a while
+	c.Timeouts.Read = 60 * time.Second
+	c.Timeouts.Write = 60 * time.Second
+	c.Timeouts.Idle = 120 * time.Second
+	// Docker kills a container 10 seconds after asking it to stop, which leaves time to close the database
+	c.Timeouts.Shutdown = 8 * time.Second
+	c.Tracing.Exporter = "none"
+	return c
+}
+
+// configSetting is a setting that can be set by a flag and an environment variable.
+type configSetting struct {
+	flag, env, usage string
+	// bind defines the flag on fs, storing its value in c.
+	bind func(fs *flag.FlagSet, c *Config, name, usage string)
+}
+
+// configSettings are the settings with a flag, in the order the usage lists them.
+var configSettings = []configSetting{
+	{"port", "PORT", "port to listen on", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(&c.Port, name, c.Port, usage)
+	}},
+	{"db", "DB_PATH", "path to the SQLite database", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(&c.DBPath, name, c.DBPath, usage)
+	}},
+	{"images", "IMAGE_DIR", "directory of the images when they are kept on the local disk", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(&c.ImageDir, name, c.ImageDir, usage)
+	}},
+	{"log-level", "LOG_LEVEL", "minimum level of the logs: debug, info, warn or error", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.TextVar(&c.Log.Level, name, c.Log.Level, usage)
+	}},
+	{"log-format", "LOG_FORMAT", "format of the logs: json or text", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(&c.Log.Format, name, c.Log.Format, usage)
+	}},
+	{"cors-origins", "FRONT_URL", "comma-separated `origins` allowed to call the API, or * for any", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.Var((*stringList)(&c.CORS.Origins), name, usage)
+	}},
+	{"token-ttl", "AUTH_TOKEN_TTL", "how long an authentication token stays valid", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Auth.TokenTTL, name, c.Auth.TokenTTL, usage)
+	}},
+	{"strict-categories", "STRICT_CATEGORIES", "reject items in categories that were not created through POST /categories", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.BoolVar(&c.StrictCategories, name, c.StrictCategories, usage)
+	}},
+	{"image-store", "IMAGE_STORE", "where images are kept: file or s3", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(&c.Images.Store, name, c.Images.Store, usage)
+	}},
+	{"max-image-size", "MAX_IMAGE_SIZE", "size limit of an uploaded image in bytes", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.Int64Var(&c.Images.MaxSize, name, c.Images.MaxSize, usage)
+	}},
+	{"image-gc-interval", "IMAGE_GC_INTERVAL", "how often orphaned images are deleted, 0 not to delete them", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Images.GCInterval, name, c.Images.GCInterval, usage)
+	}},
+	{"image-gc-grace-period", "IMAGE_GC_GRACE_PERIOD", "how old orphaned images must be to be deleted", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Images.GCGracePeriod, name, c.Images.GCGracePeriod, usage)
+	}},
+	{"read-header-timeout", "READ_HEADER_TIMEOUT", "time to read the headers of a request", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Timeouts.ReadHeader, name, c.Timeouts.ReadHeader, usage)
+	}},
+	{"read-timeout", "READ_TIMEOUT", "time to read a whole request", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Timeouts.Read, name, c.Timeouts.Read, usage)
+	}},
+	{"write-timeout", "WRITE_TIMEOUT", "time to write a response", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Timeouts.Write, name, c.Timeouts.Write, usage)
+	}},
+	{"idle-timeout", "IDLE_TIMEOUT", "time to keep an idle connection open", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Timeouts.Idle, name, c.Timeouts.Idle, usage)
+	}},
+	{"shutdown-delay", "SHUTDOWN_DELAY", "time to keep serving with /readyz failing before the server stops", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Timeouts.ShutdownDelay, name, c.Timeouts.ShutdownDelay, usage)
+	}},
+	{"shutdown-timeout", "SHUTDOWN_TIMEOUT", "time to wait for the requests in flight when the server stops", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.DurationVar(&c.Timeouts.Shutdown, name, c.Timeouts.Shutdown, usage)
+	}},
+	{"trace-exporter", "TRACE_EXPORTER", "where the trace spans go: none, stdout or otlp", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(&c.Tracing.Exporter, name, c.Tracing.Exporter, usage)
+	}},
+	{"otlp-endpoint", "OTLP_ENDPOINT", "`url` of the collector the otlp exporter sends the spans to", func(fs *flag.FlagSet, c *Config, name, usage string) {
+		fs.StringVar(&c.Tracing.OTLPEndpoint, name, c.Tracing.OTLPEndpoint, usage)
+	}},
+}
+
+// configEnvOnly are the settings set by an environment variable but no flag.
+var configEnvOnly = []struct {
+	env   string
+	field func(c *Config) *string
+}{
+	{"AUTH_SECRET", func(c *Config) *string { return &c.Auth.Secret }},
+	{"S3_ENDPOINT", func(c *Config) *string { return &c.Images.S3.Endpoint }},
+	{"S3_REGION", func(c *Config) *string { return &c.Images.S3.Region }},
+	{"S3_BUCKET", func(c *Config) *string { return &c.Images.S3.Bucket }},
+	{"S3_ACCESS_KEY_ID", func(c *Config) *string { return &c.Images.S3.AccessKeyID }},
+	{"S3_SECRET_ACCESS_KEY", func(c *Config) *string { return &c.Images.S3.SecretAccessKey }},
+	{"S3_PUBLIC_URL", func(c *Config) *string { return &c.Images.S3.PublicURL }},
+}
+
+// bindConfig defines the flag of every setting on fs, storing the values in c.
+func bindConfig(fs *flag.FlagSet, c *Config) {
+	for _, s := range configSettings {
+		s.bind(fs, c, s.flag, s.usage+" ("+s.env+")")
+	}
+}
+
+// LoadConfig resolves the configuration from the defaults, the configuration file, the environment
+// looked up with lookupEnv and the command-line arguments args, which are parsed with fs.
+// Commands define their own flags on fs before calling LoadConfig. It returns flag.ErrHelp for -help.
+func LoadConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	return loadConfig(fs, args, lookupEnv, false)
+}
+
+// LoadCommandConfig is LoadConfig for commands that take arguments after their flags,
+// which it leaves in fs.Args() instead of rejecting them.
+func LoadCommandConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	return loadConfig(fs, args, lookupEnv, true)
+}
+
+func loadConfig(fs *flag.FlagSet, args []string, lookupEnv func(string) (string, bool), positional bool) (*Config, error) {
+	// the flags are parsed first to find the configuration file, but applied last
+	path := fs.String("config", "", "path to a YAML configuration file (CONFIG_FILE)")
+	bindConfig(fs, DefaultConfig())
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	if fs.NArg() > 0 && !positional {
+		return nil, fmt.Errorf("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+
+	c := DefaultConfig()
+	if *path == "" {
+		*path, _ = lookupEnv("CONFIG_FILE")
+	}
+	if *path != "" {
+		if err := c.readFile(*path); err != nil {
+			return nil, err
+		}
+	}
+
+	settings := flag.NewFlagSet("config", flag.ContinueOnError)
+	bindConfig(settings, c)
+	for _, s := range configSettings {
+		if v, found := lookupEnv(s.env); found {
+			if err := settings.Set(s.flag, v); err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", s.env, v, err)
+			}
+		}
+	}
+	for _, s := range configEnvOnly {
+		if v, found := lookupEnv(s.env); found {
+			*s.field(c) = v
+		}
+	}
+	var err error
+	fs.Visit(func(f *flag.Flag) {
+		if settings.Lookup(f.Name) != nil && err == nil {
+			err = settings.Set(f.Name, f.Value.String())
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// readFile overrides the configuration with the settings of a YAML file. Unknown keys are errors,
+// so that a misspelt setting does not go unnoticed.
+func (c *Config) readFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read configuration file: %w", err)
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	// an empty file decodes to io.EOF and leaves the configuration as it is
+	if err := dec.Decode(c); err != nil && len(bytes.TrimSpace(b)) > 0 {
+		return fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	return nil
+}
+
+// Validate checks the configuration, reporting every invalid setting at once.
+func (c *Config) Validate() error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("port must be a number from 1 to 65535, got %q", c.Port))
+	}
+	if c.DBPath == "" {
+		errs = append(errs, errors.New("db path must not be empty"))
+	}
+	if c.ImageDir == "" && c.Images.Store == "file" {
+		errs = append(errs, errors.New("image dir must not be empty"))
+	}
+	if c.Log.Format != "json" && c.Log.Format != "text" {
+		errs = append(errs, fmt.Errorf("log format must be json or text, got %q", c.Log.Format))
+	}
+	if len(c.CORS.Origins) == 0 {
+		errs = append(errs, errors.New("at least one cors origin is required"))
+	}
+	for _, origin := range c.CORS.Origins {
+		if u, err := url.Parse(origin); origin != "*" && (err != nil || u.Scheme == "" || u.Host == "" || u.Path != "") {
+			errs = append(errs, fmt.Errorf("cors origin must be * or a scheme and a host such as https://example.com, got %q", origin))
+		}
+	}
+	if c.Auth.TokenTTL <= 0 {
+		errs = append(errs, fmt.Errorf("token ttl must be positive, got %s", c.Auth.TokenTTL))
+	}
+	if c.Images.Store != "file" && c.Images.Store != "s3" {
+		errs = append(errs, fmt.Errorf("image store must be file or s3, got %q", c.Images.Store))
+	}
+	if c.Images.MaxSize <= 0 {
+		errs = append(errs, fmt.Errorf("max image size must be positive, got %d", c.Images.MaxSize))
+	}
+	if c.Tracing.Exporter != "none" && c.Tracing.Exporter != "stdout" && c.Tracing.Exporter != "otlp" {
+		errs = append(errs, fmt.Errorf("trace exporter must be none, stdout or otlp, got %q", c.Tracing.Exporter))
+	}
+	if u, err := url.Parse(c.Tracing.OTLPEndpoint); c.Tracing.OTLPEndpoint != "" && (err != nil || u.Scheme == "" || u.Host == "") {
+		errs = append(errs, fmt.Errorf("otlp endpoint must be a url such as http://localhost:4318, got %q", c.Tracing.OTLPEndpoint))
+	}
+	for _, d := range []struct {
+		name  string
+		value time.Duration
+	}{
+		{"image gc interval", c.Images.GCInterval},
+		{"image gc grace period", c.Images.GCGracePeriod},
+		{"read header timeout", c.Timeouts.ReadHeader},
+		{"read timeout", c.Timeouts.Read},
+		{"write timeout", c.Timeouts.Write},
+		{"idle timeout", c.Timeouts.Idle},
+		{"shutdown delay", c.Timeouts.ShutdownDelay},
+		{"shutdown timeout", c.Timeouts.Shutdown},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %s", d.name, d.value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// stringList is a flag.Value of comma-separated strings.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = nil
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
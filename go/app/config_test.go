@@ -0,0 +1,297 @@
+package app
+
+import (
+	"errors"
+	"flag"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadConfig(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		// config edits the default configuration into the expected one.
+		config func(c *Config)
+		// err is a substring of the error, empty when loading succeeds.
+		err string
+	}
+	cases := map[string]struct {
+		args []string
+		env  map[string]string
+		// file is the content of the configuration file passed with -config, none when empty.
+		file string
+		wants
+	}{
+		"ok: defaults": {
+			wants: wants{config: func(c *Config) {}},
+		},
+		"ok: file": {
+			file: `
+port: "8080"
+log:
+  level: debug
+  format: text
+cors:
+  origins: [https://a.example.com, https://b.example.com]
+auth:
+  secret: from-file
+images:
+  store: s3
+  s3:
+    endpoint: http://minio:9000
+    bucket: images
+  max_size: 1048576
+  gc_interval: 1h
+timeouts:
+  write: 2m
+  shutdown_delay: 5s
+  shutdown: 0s
+tracing:
+  exporter: otlp
+  otlp_endpoint: http://collector:4318
+`,
+			wants: wants{config: func(c *Config) {
+				c.Port = "8080"
+				c.Log.Level = slog.LevelDebug
+				c.Log.Format = "text"
+				c.CORS.Origins = []string{"https://a.example.com", "https://b.example.com"}
+				c.Auth.Secret = "from-file"
+				c.Images.Store = "s3"
+				c.Images.S3 = S3Config{Endpoint: "http://minio:9000", Bucket: "images"}
+				c.Images.MaxSize = 1 << 20
+				c.Images.GCInterval = time.Hour
+				c.Timeouts.Write = 2 * time.Minute
+				c.Timeouts.ShutdownDelay = 5 * time.Second
+				c.Timeouts.Shutdown = 0
+				c.Tracing.Exporter = "otlp"
+				c.Tracing.OTLPEndpoint = "http://collector:4318"
+			}},
+		},
+		"ok: environment overrides the file": {
+			file: "port: \"8080\"\ndb_path: file.sqlite3\n",
+			env: map[string]string{
+				"PORT":              "8081",
+				"FRONT_URL":         "https://a.example.com, https://b.example.com",
+				"STRICT_CATEGORIES": "true",
+				"AUTH_SECRET":       "from-env",
+				"S3_BUCKET":         "images",
+			},
+			wants: wants{config: func(c *Config) {
+				c.Port = "8081"
+				c.DBPath = "file.sqlite3"
+				c.CORS.Origins = []string{"https://a.example.com", "https://b.example.com"}
+				c.StrictCategories = true
+				c.Auth.Secret = "from-env"
+				c.Images.S3.Bucket = "images"
+			}},
+		},
+		"ok: flags override the environment": {
+			args: []string{"-port", "8082", "-log-level", "warn", "-cors-origins", "*", "-read-timeout", "0", "-shutdown-timeout", "30s"},
+			env:  map[string]string{"PORT": "8081", "LOG_LEVEL": "debug", "DB_PATH": "env.sqlite3"},
+			wants: wants{config: func(c *Config) {
+				c.Port = "8082"
+				c.Log.Level = slog.LevelWarn
+				c.DBPath = "env.sqlite3"
+				c.CORS.Origins = []string{"*"}
+				c.Timeouts.Read = 0
+				c.Timeouts.Shutdown = 30 * time.Second
+			}},
+		},
+		"ok: empty file": {
+			file:  "\n",
+			wants: wants{config: func(c *Config) {}},
+		},
+		"ng: invalid environment variable": {
+			env:   map[string]string{"MAX_IMAGE_SIZE": "10MB"},
+			wants: wants{err: "invalid MAX_IMAGE_SIZE"},
+		},
+		"ng: unknown key in the file": {
+			file:  "prot: \"8080\"\n",
+			wants: wants{err: "field prot not found"},
+		},
+		"ng: missing file": {
+			args:  []string{"-config", "missing.yaml"},
+			wants: wants{err: "failed to read configuration file"},
+		},
+		"ng: unknown flag": {
+			args:  []string{"-prot", "8080"},
+			wants: wants{err: "flag provided but not defined"},
+		},
+		"ng: unexpected argument": {
+			args:  []string{"serve"},
+			wants: wants{err: "unexpected arguments: serve"},
+		},
+		"ng: every invalid setting is reported": {
+			args:  []string{"-port", "http", "-log-format", "xml", "-image-store", "disk"},
+			wants: wants{err: "port must be a number from 1 to 65535, got \"http\"\nlog format must be json or text, got \"xml\"\nimage store must be file or s3, got \"disk\""},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			args := tt.args
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tt.file), 0o644); err != nil {
+					t.Fatalf("failed to write configuration file: %v", err)
+				}
+				args = append([]string{"-config", path}, args...)
+			}
+			lookupEnv := func(key string) (string, bool) {
+				v, ok := tt.env[key]
+				return v, ok
+			}
+			fs := flag.NewFlagSet("api", flag.ContinueOnError)
+			fs.SetOutput(io.Discard)
+
+			got, err := LoadConfig(fs, args, lookupEnv)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Errorf("expected an error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			want := DefaultConfig()
+			tt.config(want)
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected config (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnvironment(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("db_path: file.sqlite3\n"), 0o644); err != nil {
+		t.Fatalf("failed to write configuration file: %v", err)
+	}
+	lookupEnv := func(key string) (string, bool) {
+		if key == "CONFIG_FILE" {
+			return path, true
+		}
+		return "", false
+	}
+
+	fs := flag.NewFlagSet("imagegc", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "")
+	got, err := LoadConfig(fs, []string{"-dry-run"}, lookupEnv)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.DBPath != "file.sqlite3" {
+		t.Errorf("expected the db path of the file, got %s", got.DBPath)
+	}
+	// the flags of the command are parsed along with those of the configuration
+	if !*dryRun {
+		t.Error("expected -dry-run to be set")
+	}
+}
+
+func TestLoadCommandConfig(t *testing.T) {
+	t.Parallel()
+
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	got, err := LoadCommandConfig(fs, []string{"-db", "file.sqlite3", "down", "2"}, func(string) (string, bool) { return "", false })
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.DBPath != "file.sqlite3" {
+		t.Errorf("expected the db path of the flag, got %s", got.DBPath)
+	}
+	// the arguments after the flags are left to the command
+	if diff := cmp.Diff([]string{"down", "2"}, fs.Args()); diff != "" {
+		t.Errorf("unexpected arguments (-want +got):\n%s", diff)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		edit func(c *Config)
+		// err is a substring of the error, empty when the configuration is valid.
+		err string
+	}{
+		"ok: defaults":                       {edit: func(c *Config) {}},
+		"ok: any origin":                     {edit: func(c *Config) { c.CORS.Origins = []string{"*"} }},
+		"ok: no image dir with s3":           {edit: func(c *Config) { c.Images.Store = "s3"; c.ImageDir = "" }},
+		"ng: port out of range":              {edit: func(c *Config) { c.Port = "65536" }, err: "port must be"},
+		"ng: empty db path":                  {edit: func(c *Config) { c.DBPath = "" }, err: "db path must not be empty"},
+		"ng: no image dir":                   {edit: func(c *Config) { c.ImageDir = "" }, err: "image dir must not be empty"},
+		"ng: no origin":                      {edit: func(c *Config) { c.CORS.Origins = nil }, err: "at least one cors origin"},
+		"ng: origin with a path":             {edit: func(c *Config) { c.CORS.Origins = []string{"http://localhost:3000/"} }, err: "cors origin must be"},
+		"ng: origin without a scheme":        {edit: func(c *Config) { c.CORS.Origins = []string{"localhost:3000"} }, err: "cors origin must be"},
+		"ng: no token ttl":                   {edit: func(c *Config) { c.Auth.TokenTTL = 0 }, err: "token ttl must be positive"},
+		"ng: no image size":                  {edit: func(c *Config) { c.Images.MaxSize = 0 }, err: "max image size must be positive"},
+		"ng: negative timeout":               {edit: func(c *Config) { c.Timeouts.Idle = -time.Second }, err: "idle timeout must not be negative"},
+		"ng: negative gc grace period":       {edit: func(c *Config) { c.Images.GCGracePeriod = -time.Hour }, err: "image gc grace period must not be negative"},
+		"ng: unknown trace exporter":         {edit: func(c *Config) { c.Tracing.Exporter = "jaeger" }, err: "trace exporter must be"},
+		"ng: otlp endpoint without a scheme": {edit: func(c *Config) { c.Tracing.OTLPEndpoint = "localhost:4318" }, err: "otlp endpoint must be"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			c := DefaultConfig()
+			tt.edit(c)
+			err := c.Validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected an error containing %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadConfigHelp(t *testing.T) {
+	t.Parallel()
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	var out strings.Builder
+	fs.SetOutput(&out)
+	if _, err := LoadConfig(fs, []string{"-help"}, func(string) (string, bool) { return "", false }); !errors.Is(err, flag.ErrHelp) {
+		t.Fatalf("expected flag.ErrHelp, got %v", err)
+	}
+	// every setting documents its environment variable
+	for _, s := range configSettings {
+		if !strings.Contains(out.String(), "("+s.env+")") {
+			t.Errorf("expected the usage to mention %s", s.env)
+		}
+	}
+}
+
+// TestConfigExample checks that the example configuration file is valid and shows the defaults.
+func TestConfigExample(t *testing.T) {
+	t.Parallel()
+
+	fs := flag.NewFlagSet("api", flag.ContinueOnError)
+	got, err := LoadConfig(fs, []string{"-config", "../config.example.yaml"}, func(string) (string, bool) { return "", false })
+	if err != nil {
+		t.Fatalf("failed to load the example: %v", err)
+	}
+	if diff := cmp.Diff(DefaultConfig(), got); diff != "" {
+		t.Errorf("expected the example to show the defaults (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,141 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Kinds of errors the application distinguishes.
+// Every error returned to a handler is classified by wrapping one of them, and
+// writeError maps the kind to an HTTP status code.
+var (
+	// ErrInvalidRequest means the request is malformed or misses a required parameter.
+	ErrInvalidRequest = errors.New("invalid request")
+	// ErrUnauthorized means the request lacks valid credentials.
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrForbidden means the authenticated user is not allowed to perform the request.
+	ErrForbidden = errors.New("forbidden")
+	// ErrNotFound means the requested resource does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict means the request conflicts with the current state of a resource.
+	ErrConflict = errors.New("conflict")
+	// ErrValidation means the request is well-formed but carries values that are not acceptable.
+	ErrValidation = errors.New("validation failed")
+	// ErrTooLarge means the request body or an uploaded file is larger than the server accepts.
+	ErrTooLarge = errors.New("payload too large")
+	// ErrUnsupportedMediaType means an uploaded file is not of a type the server accepts.
+	ErrUnsupportedMediaType = errors.New("unsupported media type")
+	// ErrInternal means the server failed for a reason the client cannot fix.
+	ErrInternal = errors.New("internal error")
+)
+
+// Error is an error whose message is safe to show to clients.
+type Error struct {
+	// Kind is one of the Err* sentinels.
+	Kind error
+	// Message is a human-readable description returned to the client.
+	Message string
+	// Details is additional machine-readable information returned to the client, if any.
+	Details any
+	// Err is the underlying cause. It is logged but never returned to the client.
+	Err error
+}
+
+// newError creates an error of the given kind with a client-facing message.
+func newError(kind error, message string) *Error {
+	return &Error{Kind: kind, Message: message}
+}
+
+// fieldError creates an error of the given kind about a single request field.
+func fieldError(kind error, field, message string) *Error {
+	return &Error{Kind: kind, Message: message, Details: map[string]string{"field": field}}
+}
+
+// internalError wraps an unexpected error so that its text does not reach clients.
+func internalError(message string, err error) *Error {
+	return &Error{Kind: ErrInternal, Message: message, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return e.Message + ": " + e.Err.Error()
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() []error {
+	if e.Err != nil {
+		return []error{e.Kind, e.Err}
+	}
+	return []error{e.Kind}
+}
+
+// errorKinds lists each kind with its status code and the code string used in responses.
+var errorKinds = []struct {
+	kind   error
+	status int
+	code   string
+}{
+	{ErrInvalidRequest, http.StatusBadRequest, "invalid_request"},
+	{ErrUnauthorized, http.StatusUnauthorized, "unauthorized"},
+	{ErrForbidden, http.StatusForbidden, "forbidden"},
+	{ErrNotFound, http.StatusNotFound, "not_found"},
+	{ErrConflict, http.StatusConflict, "conflict"},
+	{ErrValidation, http.StatusUnprocessableEntity, "validation_failed"},
+	{ErrTooLarge, http.StatusRequestEntityTooLarge, "payload_too_large"},
+	{ErrUnsupportedMediaType, http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	{ErrInternal, http.StatusInternalServerError, "internal"},
+}
+
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Details any    `json:"details,omitempty"`
+}
+
+// errorResponse maps err to the status code and body returned to the client.
+// Errors that are not an *Error are treated as internal and their text is hidden.
+func errorResponse(err error) (int, ErrorResponse) {
+	var e *Error
+	if !errors.As(err, &e) {
+		e = internalError("internal server error", err)
+	}
+
+	for _, k := range errorKinds {
+		if errors.Is(e.Kind, k.kind) {
+			return k.status, ErrorResponse{Error: ErrorBody{Code: k.code, Message: e.Message, Details: e.Details}}
+		}
+	}
+	return http.StatusInternalServerError, ErrorResponse{Error: ErrorBody{Code: "internal", Message: "internal server error"}}
+}
+
+// writeError writes err as a JSON error envelope.
+// Server errors are logged with their cause; client errors are logged as warnings.
+func writeError(w http.ResponseWriter, r *http.Request, err error) {
+	status, resp := errorResponse(err)
+	if status >= http.StatusInternalServerError {
+		slog.ErrorContext(r.Context(), "request failed", "method", r.Method, "path", r.URL.Path, "error", err)
+		trace.SpanFromContext(r.Context()).RecordError(err)
+	} else {
+		slog.WarnContext(r.Context(), "request rejected", "method", r.Method, "path", r.URL.Path, "error", err)
+	}
+
+	writeJSON(w, r, status, resp)
+}
+
+// writeJSON writes v as the JSON response body to r with the given status code.
+func writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode response", "error", err)
+	}
+}
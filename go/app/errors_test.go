@@ -0,0 +1,106 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestErrorResponse(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		status int
+		body   ErrorResponse
+	}
+	cases := map[string]struct {
+		err error
+		wants
+	}{
+		"invalid request with details": {
+			err: fieldError(ErrInvalidRequest, "name", "name is required"),
+			wants: wants{
+				status: http.StatusBadRequest,
+				body: ErrorResponse{Error: ErrorBody{
+					Code:    "invalid_request",
+					Message: "name is required",
+					Details: map[string]string{"field": "name"},
+				}},
+			},
+		},
+		"not found wrapped by fmt.Errorf": {
+			err: fmt.Errorf("get item 1: %w", errItemNotFound),
+			wants: wants{
+				status: http.StatusNotFound,
+				body:   ErrorResponse{Error: ErrorBody{Code: "not_found", Message: "item not found"}},
+			},
+		},
+		"conflict": {
+			err: newError(ErrConflict, "item is already sold"),
+			wants: wants{
+				status: http.StatusConflict,
+				body:   ErrorResponse{Error: ErrorBody{Code: "conflict", Message: "item is already sold"}},
+			},
+		},
+		"validation": {
+			err: newError(ErrValidation, "category must not be empty"),
+			wants: wants{
+				status: http.StatusUnprocessableEntity,
+				body:   ErrorResponse{Error: ErrorBody{Code: "validation_failed", Message: "category must not be empty"}},
+			},
+		},
+		"too large": {
+			err: imageUploadTooLarge(1024),
+			wants: wants{
+				status: http.StatusRequestEntityTooLarge,
+				body: ErrorResponse{Error: ErrorBody{
+					Code:    "payload_too_large",
+					Message: "image must be at most 1024 bytes",
+					Details: map[string]string{"field": "image"},
+				}},
+			},
+		},
+		"unsupported media type": {
+			err: errUnsupportedImage,
+			wants: wants{
+				status: http.StatusUnsupportedMediaType,
+				body: ErrorResponse{Error: ErrorBody{
+					Code:    "unsupported_media_type",
+					Message: "image must be a JPEG, PNG, GIF or WebP file",
+					Details: map[string]string{"field": "image"},
+				}},
+			},
+		},
+		"internal error keeps its cause private": {
+			err: internalError("failed to store image", errors.New("open /srv/images: permission denied")),
+			wants: wants{
+				status: http.StatusInternalServerError,
+				body:   ErrorResponse{Error: ErrorBody{Code: "internal", Message: "failed to store image"}},
+			},
+		},
+		"unclassified error is hidden": {
+			err: errors.New("sql: database is closed"),
+			wants: wants{
+				status: http.StatusInternalServerError,
+				body:   ErrorResponse{Error: ErrorBody{Code: "internal", Message: "internal server error"}},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			status, body := errorResponse(tt.err)
+			if status != tt.wants.status {
+				t.Errorf("expected status %d, got %d", tt.wants.status, status)
+			}
+			if diff := cmp.Diff(tt.wants.body, body); diff != "" {
+				t.Errorf("unexpected body (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
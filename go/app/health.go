@@ -0,0 +1,142 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds the checks of a single readiness probe, so that a stuck dependency fails the probe
+// instead of hanging it.
+const readinessTimeout = 2 * time.Second
+
+// readinessProbeKey is the key readiness stores and deletes to check that the image store is writable.
+// It is not derived from a hash, so CollectImages never considers it.
+const readinessProbeKey = ".readyz"
+
+// health serves the endpoints an orchestrator probes to decide whether to restart the server and whether to
+// route requests to it.
+type health struct {
+	db       *sql.DB
+	migrator *Migrator
+	images   ImageStore
+	// shuttingDown fails readiness once the server has started shutting down.
+	shuttingDown atomic.Bool
+}
+
+type HealthResponse struct {
+	Status string `json:"status"`
+}
+
+type ReadinessResponse struct {
+	Status string `json:"status"`
+	// Checks maps each dependency to "ok" or "failing". The causes are logged, not returned.
+	Checks map[string]string `json:"checks,omitempty"`
+}
+
+type VersionResponse struct {
+	// Version is the module version, "(devel)" when built from a working tree.
+	Version   string `json:"version"`
+	GoVersion string `json:"go_version"`
+	Revision  string `json:"revision,omitempty"`
+	// CommitTime is the time of Revision. Go does not record when a binary was built;
+	// this is the closest time it stamps.
+	CommitTime string `json:"commit_time,omitempty"`
+	// Modified tells that the working tree had uncommitted changes.
+	Modified bool `json:"modified,omitempty"`
+}
+
+// Healthz is a handler for GET /healthz, the liveness probe. It succeeds as long as the server answers,
+// as restarting the server would not fix a failing dependency.
+func (h *health) Healthz(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, HealthResponse{Status: "ok"})
+}
+
+// Readyz is a handler for GET /readyz, the readiness probe. It fails while the server shuts down, and
+// when the database is unreachable, has migrations pending or the image store is not writable.
+func (h *health) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.shuttingDown.Load() {
+		writeJSON(w, r, http.StatusServiceUnavailable, ReadinessResponse{Status: "shutting down"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+	checks := []struct {
+		name  string
+		check func(ctx context.Context) error
+	}{
+		{"database", h.db.PingContext},
+		{"migrations", h.checkMigrations},
+		{"images", h.checkImages},
+	}
+
+	resp := ReadinessResponse{Status: "ok", Checks: map[string]string{}}
+	status := http.StatusOK
+	for _, c := range checks {
+		if err := c.check(ctx); err != nil {
+			slog.WarnContext(ctx, "readiness check failed", "check", c.name, "error", err)
+			resp.Status = "unavailable"
+			resp.Checks[c.name] = "failing"
+			status = http.StatusServiceUnavailable
+			continue
+		}
+		resp.Checks[c.name] = "ok"
+	}
+	writeJSON(w, r, status, resp)
+}
+
+func (h *health) checkMigrations(ctx context.Context) error {
+	pending, err := h.migrator.Pending(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		var names []string
+		for _, mig := range pending {
+			names = append(names, fmt.Sprintf("%d_%s", mig.Version, mig.Name))
+		}
+		return fmt.Errorf("pending migrations: %s", strings.Join(names, ", "))
+	}
+	return nil
+}
+
+func (h *health) checkImages(ctx context.Context) error {
+	if err := h.images.Put(ctx, readinessProbeKey, strings.NewReader("ok")); err != nil {
+		return err
+	}
+	return h.images.Delete(ctx, readinessProbeKey)
+}
+
+// Version is a handler for GET /version, which returns what the server was built from.
+func (h *health) Version(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, http.StatusOK, buildVersion())
+}
+
+// buildVersion reads the build information stamped into the binary once.
+var buildVersion = sync.OnceValue(func() VersionResponse {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return VersionResponse{Version: "unknown"}
+	}
+
+	v := VersionResponse{Version: info.Main.Version, GoVersion: info.GoVersion}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			v.Revision = s.Value
+		case "vcs.time":
+			v.CommitTime = s.Value
+		case "vcs.modified":
+			v.Modified = s.Value == "true"
+		}
+	}
+	return v
+})
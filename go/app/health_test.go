@@ -0,0 +1,134 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestHealthz(t *testing.T) {
+	t.Parallel()
+
+	h := &health{}
+	res := httptest.NewRecorder()
+	h.Healthz(res, httptest.NewRequest("GET", "/healthz", nil))
+	if res.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	type wants struct {
+		code int
+		resp ReadinessResponse
+	}
+	cases := map[string]struct {
+		// setup breaks the dependencies of h.
+		setup func(t *testing.T, h *health)
+		wants
+	}{
+		"ok: ready": {
+			setup: func(t *testing.T, h *health) {},
+			wants: wants{
+				code: http.StatusOK,
+				resp: ReadinessResponse{Status: "ok", Checks: map[string]string{"database": "ok", "migrations": "ok", "images": "ok"}},
+			},
+		},
+		"ng: shutting down": {
+			setup: func(t *testing.T, h *health) { h.shuttingDown.Store(true) },
+			wants: wants{
+				code: http.StatusServiceUnavailable,
+				resp: ReadinessResponse{Status: "shutting down"},
+			},
+		},
+		"ng: pending migration": {
+			setup: func(t *testing.T, h *health) {
+				if _, err := h.db.Exec("DELETE FROM schema_migrations WHERE version = (SELECT MAX(version) FROM schema_migrations)"); err != nil {
+					t.Fatalf("failed to forget a migration: %v", err)
+				}
+			},
+			wants: wants{
+				code: http.StatusServiceUnavailable,
+				resp: ReadinessResponse{Status: "unavailable", Checks: map[string]string{"database": "ok", "migrations": "failing", "images": "ok"}},
+			},
+		},
+		"ng: image store not writable": {
+			setup: func(t *testing.T, h *health) { h.images = NewFileImageStore(filepath.Join(t.TempDir(), "missing")) },
+			wants: wants{
+				code: http.StatusServiceUnavailable,
+				resp: ReadinessResponse{Status: "unavailable", Checks: map[string]string{"database": "ok", "migrations": "ok", "images": "failing"}},
+			},
+		},
+		"ng: database closed": {
+			setup: func(t *testing.T, h *health) { h.db.Close() },
+			wants: wants{
+				code: http.StatusServiceUnavailable,
+				resp: ReadinessResponse{Status: "unavailable", Checks: map[string]string{"database": "failing", "migrations": "failing", "images": "ok"}},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			db, closers, err := setupDB(t)
+			if err != nil {
+				t.Fatalf("failed to set up database: %v", err)
+			}
+			t.Cleanup(func() {
+				for _, c := range closers {
+					c()
+				}
+			})
+			migrator, err := NewMigrator(db)
+			if err != nil {
+				t.Fatalf("failed to load migrations: %v", err)
+			}
+			h := &health{db: db, migrator: migrator, images: NewFileImageStore(t.TempDir())}
+			tt.setup(t, h)
+
+			res := httptest.NewRecorder()
+			h.Readyz(res, httptest.NewRequest("GET", "/readyz", nil))
+			if res.Code != tt.code {
+				t.Errorf("expected status %d, got %d", tt.code, res.Code)
+			}
+			var got ReadinessResponse
+			if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.resp, got); diff != "" {
+				t.Errorf("unexpected response (-want +got):\n%s", diff)
+			}
+			// the probe leaves nothing behind in the store
+			if exists, err := h.images.Exists(t.Context(), readinessProbeKey); err == nil && exists {
+				t.Errorf("expected %s to be deleted", readinessProbeKey)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	t.Parallel()
+
+	h := &health{}
+	res := httptest.NewRecorder()
+	h.Version(res, httptest.NewRequest("GET", "/version", nil))
+	if res.Code != http.StatusOK {
+		t.Errorf("expected status %d, got %d", http.StatusOK, res.Code)
+	}
+	var got VersionResponse
+	if err := json.NewDecoder(res.Body).Decode(&got); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	// test binaries are stamped with the Go version but not with the module version
+	if got.GoVersion == "" || got.Version == "" {
+		t.Errorf("expected the version and the Go version, got %+v", got)
+	}
+}
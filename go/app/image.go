@@ -0,0 +1,411 @@
+package app
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// imageFormat is a format accepted for item images.
+type imageFormat struct {
+	// ext is the extension images of the format are stored with.
+	ext         string
+	contentType string
+	// thumbnailExt is the extension of the thumbnails of images of the format.
+	// The standard library encodes neither GIF animations nor WebP, so their thumbnails are PNG and JPEG images.
+	thumbnailExt string
+}
+
+// imageFormats maps the name image.DecodeConfig gives each accepted format to the format.
+var imageFormats = map[string]imageFormat{
+	"jpeg": {ext: ".jpg", contentType: "image/jpeg", thumbnailExt: ".jpg"},
+	"png":  {ext: ".png", contentType: "image/png", thumbnailExt: ".png"},
+	"gif":  {ext: ".gif", contentType: "image/gif", thumbnailExt: ".png"},
+	"webp": {ext: ".webp", contentType: "image/webp", thumbnailExt: ".jpg"},
+}
+
+const (
+	// maxImageDimension is the largest width or height of an uploaded image.
+	maxImageDimension = 8192
+	// maxImagePixels bounds the memory needed to decode an uploaded image.
+	maxImagePixels = 40_000_000
+	// imageQuality is the JPEG quality originals and thumbnails are encoded with.
+	imageQuality = 90
+	// maxJPEGHeaderSize is how much of a JPEG image is searched for its EXIF orientation.
+	// EXIF data lives in an APP1 segment of at most 64KiB, right after the few segments opening the image.
+	maxJPEGHeaderSize = 128 << 10
+)
+
+// thumbnailWidths are the widths, in ascending order, of the thumbnails generated for every image.
+var thumbnailWidths = []int{150, 600}
+
+var (
+	// errUnsupportedImage is returned for uploads that are not an image in one of imageFormats.
+	errUnsupportedImage error = fieldError(ErrUnsupportedMediaType, "image", "image must be a JPEG, PNG, GIF or WebP file")
+	errImageTooLarge    error = fieldError(ErrValidation, "image",
+		fmt.Sprintf("image must be at most %d pixels wide and high and %d pixels in total", maxImageDimension, maxImagePixels))
+)
+
+// sniffImage detects the format of an uploaded image from its content, ignoring the file name and content type the client sent.
+// It also rejects images too large to be processed.
+func sniffImage(r io.Reader) (imageFormat, error) {
+	config, name, err := image.DecodeConfig(bufio.NewReader(r))
+	if err != nil {
+		return imageFormat{}, errUnsupportedImage
+	}
+	format, ok := imageFormats[name]
+	if !ok || config.Width == 0 || config.Height == 0 {
+		return imageFormat{}, errUnsupportedImage
+	}
+	if config.Width > maxImageDimension || config.Height > maxImageDimension || config.Width*config.Height > maxImagePixels {
+		return imageFormat{}, errImageTooLarge
+	}
+	return format, nil
+}
+
+// formatByExt returns the format images with the extension of key are stored in.
+func formatByExt(key string) (imageFormat, bool) {
+	ext := strings.ToLower(path.Ext(key))
+	// images were once stored as .jpeg too
+	if ext == ".jpeg" {
+		ext = ".jpg"
+	}
+	for _, format := range imageFormats {
+		if format.ext == ext {
+			return format, true
+		}
+	}
+	return imageFormat{}, false
+}
+
+// imageContentType returns the content type of a stored image or thumbnail from the extension of its key.
+// It reports false for extensions no accepted format is stored with.
+func imageContentType(key string) (string, bool) {
+	format, ok := formatByExt(key)
+	return format.contentType, ok
+}
+
+// thumbnailKey returns the key the thumbnail of the given width of the image stored under key is stored under,
+// e.g. "<sha256>_w150.jpg" for "<sha256>.png" and a width of 150.
+func thumbnailKey(key string, width int) string {
+	ext := path.Ext(key)
+	thumbnailExt := ".jpg"
+	if format, ok := formatByExt(key); ok {
+		thumbnailExt = format.thumbnailExt
+	}
+	return strings.TrimSuffix(key, ext) + "_w" + strconv.Itoa(width) + thumbnailExt
+}
+
+// contentAddressed reports whether key is a key storeImage derived from the content of an image,
+// "<sha256><ext>" or the key of one of its thumbnails. What is stored under such a key never changes.
+func contentAddressed(key string) bool {
+	_, ok := imageHash(key)
+	return ok
+}
+
+// imageHash returns the hex SHA-256 a content-addressed key starts with, which an image shares with its thumbnails.
+// It reports false for keys that are not content-addressed.
+func imageHash(key string) (string, bool) {
+	base := strings.TrimSuffix(key, path.Ext(key))
+	if i := strings.LastIndex(base, "_w"); i >= 0 {
+		if _, err := strconv.Atoi(base[i+2:]); err == nil {
+			base = base[:i]
+		}
+	}
+	if len(base) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(base); err != nil {
+		return "", false
+	}
+	return base, true
+}
+
+// thumbnailWidth returns the width of the smallest thumbnail at least w pixels wide,
+// or false when the original is the only image that large.
+func thumbnailWidth(w int) (int, bool) {
+	for _, width := range thumbnailWidths {
+		if width >= w {
+			return width, true
+		}
+	}
+	return 0, false
+}
+
+// processedImage is an uploaded image ready to be stored.
+type processedImage struct {
+	format imageFormat
+	// original is the uploaded image upright and without metadata, ready to be read from its start.
+	original io.ReadSeeker
+	// thumbnails are encoded images by width, in the format of format.thumbnailExt.
+	thumbnails map[int][]byte
+}
+
+// processImage prepares an uploaded image in the format sniffImage detected for storage.
+//
+// JPEG images are rotated as their EXIF orientation says and re-encoded, which drops EXIF data
+// such as the location a photo was taken at. PNG images are re-encoded for the same reason.
+// WebP images have their EXIF and XMP chunks removed instead, since there is no WebP encoder,
+// and GIF images, which carry no EXIF data, are kept as they are so that animations survive.
+//
+// r is read from its current offset, which is where the image starts. Only decoded images and
+// WebP images are held in memory; GIF originals are read from r again when they are stored.
+func processImage(r io.ReadSeeker, format imageFormat) (*processedImage, error) {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, err
+	}
+	img, _, err := image.Decode(bufio.NewReader(r))
+	if err != nil {
+		return nil, errUnsupportedImage
+	}
+	if _, err := r.Seek(start, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	p := &processedImage{format: format, thumbnails: map[int][]byte{}}
+	var original []byte
+	switch format.ext {
+	case ".jpg":
+		header, err := io.ReadAll(io.LimitReader(r, maxJPEGHeaderSize))
+		if err != nil {
+			return nil, err
+		}
+		img = orientImage(img, jpegOrientation(header))
+		if original, err = encodeImage(img, ".jpg"); err != nil {
+			return nil, err
+		}
+	case ".png":
+		if original, err = encodeImage(img, ".png"); err != nil {
+			return nil, err
+		}
+	case ".webp":
+		// the upload size limit bounds the image, and its chunks have to be walked in full anyway
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		if original, err = stripWebPMetadata(data); err != nil {
+			return nil, errUnsupportedImage
+		}
+	default:
+		p.original = r
+	}
+	if p.original == nil {
+		p.original = bytes.NewReader(original)
+	}
+
+	// each thumbnail is scaled down from the next larger one, which is much faster than from the original
+	src := img
+	for _, width := range slices.Backward(thumbnailWidths) {
+		thumbnail := scaleToWidth(src, width, format.thumbnailExt == ".jpg")
+		if p.thumbnails[width], err = encodeImage(thumbnail, format.thumbnailExt); err != nil {
+			return nil, err
+		}
+		src = thumbnail
+	}
+	return p, nil
+}
+
+// scaleToWidth scales img down to width pixels wide, keeping its aspect ratio. Narrower images keep their size.
+// opaque composites transparent images on a white background for formats without an alpha channel.
+func scaleToWidth(img image.Image, width int, opaque bool) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width {
+		width = b.Dx()
+	}
+	height := max(1, b.Dy()*width/b.Dx())
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	if opaque {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	}
+	draw.BiLinear.Scale(dst, dst.Bounds(), img, b, draw.Over, nil)
+	return dst
+}
+
+// encodeImage encodes img in the format stored with ext, which is either .jpg or .png.
+func encodeImage(img image.Image, ext string) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch ext {
+	case ".jpg":
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: imageQuality})
+	case ".png":
+		err = png.Encode(&buf, img)
+	default:
+		err = fmt.Errorf("cannot encode images as %s", ext)
+	}
+	return buf.Bytes(), err
+}
+
+// jpegOrientation returns the EXIF orientation of a JPEG image, from 1 to 8, or 1 when it has none.
+func jpegOrientation(data []byte) int {
+	if len(data) < 2 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+
+	// walk the segments before the image data, looking for the APP1 segment holding EXIF data
+	for i := 2; i+4 <= len(data) && data[i] == 0xFF; {
+		marker := data[i+1]
+		size := int(binary.BigEndian.Uint16(data[i+2:]))
+		if marker == 0xDA || size < 2 || i+2+size > len(data) {
+			break
+		}
+		segment := data[i+4 : i+2+size]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + size
+	}
+	return 1
+}
+
+// exifOrientation reads the orientation tag from the first IFD of TIFF-encoded EXIF data.
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1
+	}
+	entries := int(order.Uint16(tiff[ifd:]))
+	for i := range entries {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			break
+		}
+		// the orientation is a SHORT, stored in the first two bytes of the value field
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if o := int(order.Uint16(tiff[entry+8:])); o >= 1 && o <= 8 {
+				return o
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orientImage returns img transformed so that an image with the given EXIF orientation is upright.
+func orientImage(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		// orientations 5 to 8 swap the width and the height
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	if w == 0 || h == 0 {
+		return dst
+	}
+	// the pixels are copied between the Pix of RGBA images, as At and Set convert the color of each pixel
+	src, ok := img.(*image.RGBA)
+	if !ok {
+		src = image.NewRGBA(b)
+		draw.Draw(src, b, img, b.Min, draw.Src)
+	}
+
+	// the pixel of dst at (dx, dy) is at base + dx*stepX + dy*stepY in src.Pix
+	at := func(x, y int) int { return src.PixOffset(b.Min.X+x, b.Min.Y+y) }
+	var base, stepX, stepY int
+	switch orientation {
+	case 2: // mirrored horizontally
+		base, stepX, stepY = at(w-1, 0), -4, src.Stride
+	case 3: // rotated 180°
+		base, stepX, stepY = at(w-1, h-1), -4, -src.Stride
+	case 4: // mirrored vertically
+		base, stepX, stepY = at(0, h-1), 4, -src.Stride
+	case 5: // mirrored along the top-left to bottom-right diagonal
+		base, stepX, stepY = at(0, 0), src.Stride, 4
+	case 6: // needs rotating 90° clockwise
+		base, stepX, stepY = at(0, h-1), -src.Stride, 4
+	case 7: // mirrored along the top-right to bottom-left diagonal
+		base, stepX, stepY = at(w-1, h-1), -src.Stride, -4
+	case 8: // needs rotating 90° counterclockwise
+		base, stepX, stepY = at(w-1, 0), src.Stride, -4
+	}
+
+	for dy := range dh {
+		row := dst.Pix[dy*dst.Stride : dy*dst.Stride+dw*4]
+		i := base + dy*stepY
+		if stepX == 4 {
+			copy(row, src.Pix[i:i+len(row)])
+			continue
+		}
+		for dx := 0; dx < len(row); dx += 4 {
+			copy(row[dx:dx+4], src.Pix[i:i+4])
+			i += stepX
+		}
+	}
+	return dst
+}
+
+// stripWebPMetadata removes the EXIF and XMP chunks of a WebP image without re-encoding it.
+func stripWebPMetadata(data []byte) ([]byte, error) {
+	if len(data) < 12 || string(data[:4]) != "RIFF" || string(data[8:12]) != "WEBP" {
+		return nil, fmt.Errorf("not a WebP image")
+	}
+
+	out := append([]byte(nil), data[:12]...)
+	for i := 12; i < len(data); {
+		if i+8 > len(data) {
+			return nil, fmt.Errorf("truncated WebP chunk header")
+		}
+		fourCC := string(data[i : i+4])
+		size := int(binary.LittleEndian.Uint32(data[i+4:]))
+		end := i + 8 + size + size%2 // chunks are padded to an even size
+		if size < 0 || end > len(data) {
+			return nil, fmt.Errorf("truncated WebP chunk %q", fourCC)
+		}
+
+		switch fourCC {
+		case "EXIF", "XMP ":
+		case "VP8X":
+			chunk := append([]byte(nil), data[i:end]...)
+			if size > 0 {
+				// clear the flags announcing EXIF (0x08) and XMP (0x04) metadata
+				chunk[8] &^= 0x08 | 0x04
+			}
+			out = append(out, chunk...)
+		default:
+			out = append(out, data[i:end]...)
+		}
+		i = end
+	}
+
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out, nil
+}
@@ -0,0 +1,159 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// DefaultImageGCGracePeriod is how old an unreferenced image must be before CollectImages deletes it.
+// An image is stored before the item referring to it is written, so recent images may be about to be referenced.
+const DefaultImageGCGracePeriod = 24 * time.Hour
+
+// ImageGCOptions configures CollectImages.
+type ImageGCOptions struct {
+	// GracePeriod keeps unreferenced images younger than it.
+	GracePeriod time.Duration
+	// DryRun reports the orphans without deleting them.
+	DryRun bool
+}
+
+// OrphanImage is a stored image no item refers to.
+type OrphanImage struct {
+	Key string
+	ImageInfo
+}
+
+// ImageGCReport is the outcome of CollectImages.
+type ImageGCReport struct {
+	// Scanned is the number of images, thumbnails included, that storeImage stored and were looked at.
+	Scanned int
+	// Referenced is the number of scanned images some item refers to, directly or through their original.
+	Referenced int
+	// Recent is the number of unreferenced images kept because they or the images they go with
+	// are younger than the grace period.
+	Recent int
+	// Deleted are the orphans that were deleted, or that would have been in a dry run.
+	Deleted []OrphanImage
+	// Failed is the number of orphans that could not be deleted.
+	Failed int
+	DryRun bool
+}
+
+// DeletedBytes returns the total size of the deleted orphans.
+func (r *ImageGCReport) DeletedBytes() int64 {
+	var size int64
+	for _, orphan := range r.Deleted {
+		size += orphan.Size
+	}
+	return size
+}
+
+// CollectImages deletes the images of store that no item refers to and that are older than the grace period.
+// Only keys storeImage derives from the content of an image are considered, so that files such as defaultImage
+// are never deleted. An image and its thumbnails share the hash their keys start with and are kept or deleted
+// together: a recent thumbnail keeps its original, and a referenced original keeps its thumbnails.
+//
+// The store is listed before the references are read, and storeImage refreshes the modification time of the
+// images uploads reuse, so an image stored or reused before the listing is recent, and one referenced before the
+// references are read is referenced. The references of an orphan are read again right before it is deleted,
+// which leaves an upload reusing it meanwhile only the time between that check and the deletion; an upload
+// after the deletion finds it gone and stores it again. Deleting an orphan goes on after a failure to delete
+// another one; the failures are reported and returned together.
+func CollectImages(ctx context.Context, db *sql.DB, store ImageStore, opts ImageGCOptions) (*ImageGCReport, error) {
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	var candidates []OrphanImage
+	recent := map[string]bool{}
+	err := store.List(ctx, func(key string, info ImageInfo) error {
+		hash, ok := imageHash(key)
+		if !ok {
+			return nil
+		}
+		candidates = append(candidates, OrphanImage{Key: key, ImageInfo: info})
+		if info.ModTime.After(cutoff) {
+			recent[hash] = true
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list images: %w", err)
+	}
+
+	names, err := referencedImageNames(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image references: %w", err)
+	}
+	referenced := map[string]bool{}
+	for name := range names {
+		if hash, ok := imageHash(name); ok {
+			referenced[hash] = true
+		}
+	}
+
+	report := &ImageGCReport{Scanned: len(candidates), DryRun: opts.DryRun}
+	var errs []error
+	for _, candidate := range candidates {
+		hash, _ := imageHash(candidate.Key)
+		switch {
+		case referenced[hash]:
+			report.Referenced++
+			continue
+		case recent[hash]:
+			report.Recent++
+			continue
+		}
+
+		if !opts.DryRun {
+			// an item may have been written since the references were read
+			found, err := imageHashReferenced(ctx, db, hash)
+			if err != nil {
+				report.Failed++
+				errs = append(errs, fmt.Errorf("failed to read the references of %s: %w", candidate.Key, err))
+				continue
+			}
+			if found {
+				report.Referenced++
+				continue
+			}
+			if err := store.Delete(ctx, candidate.Key); err != nil {
+				report.Failed++
+				errs = append(errs, fmt.Errorf("failed to delete %s: %w", candidate.Key, err))
+				continue
+			}
+		}
+		report.Deleted = append(report.Deleted, candidate)
+	}
+	return report, errors.Join(errs...)
+}
+
+// runImageGC runs CollectImages every interval until ctx is done, logging a summary of each run.
+func runImageGC(ctx context.Context, db *sql.DB, store ImageStore, interval time.Duration, opts ImageGCOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := CollectImages(ctx, db, store, opts)
+		if report == nil {
+			slog.Error("image gc failed", "error", err)
+			continue
+		}
+		attrs := []any{
+			"scanned", report.Scanned, "referenced", report.Referenced, "recent", report.Recent,
+			"deleted", len(report.Deleted), "deleted_bytes", report.DeletedBytes(), "failed", report.Failed, "dry_run", report.DryRun,
+		}
+		if err != nil {
+			slog.Error("image gc failed to delete some images", append(attrs, "error", err)...)
+			continue
+		}
+		slog.Info("image gc finished", attrs...)
+	}
+}
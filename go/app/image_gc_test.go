@@ -0,0 +1,244 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestCollectImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+	ctx := t.Context()
+
+	hash := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+	referenced, orphan, recent := hash("referenced")+".gif", hash("orphan")+".jpg", hash("recent")+".jpg"
+	// an old image whose thumbnail was just stored again goes with the thumbnail
+	touched := hash("touched") + ".png"
+
+	dir := t.TempDir()
+	store := NewFileImageStore(dir)
+	old := time.Now().Add(-2 * DefaultImageGCGracePeriod)
+	for key, modTime := range map[string]time.Time{
+		referenced:                       old,
+		thumbnailKey(referenced, 150):    old,
+		thumbnailKey(referenced, 600):    old,
+		orphan:                           old,
+		thumbnailKey(orphan, 150):        old,
+		thumbnailKey(orphan, 600):        old,
+		recent:                           time.Now(),
+		touched:                          old,
+		thumbnailKey(touched, 150):       time.Now(),
+		defaultImage:                     old,
+		"5e1f.jpg":                       old,
+		hash("thumbnail") + "_w150.jpg":  old,
+		hash("thumbnail") + "_wide.jpeg": old,
+	} {
+		if err := store.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+		if err := os.Chtimes(filepath.Join(dir, key), modTime, modTime); err != nil {
+			t.Fatalf("failed to set the modification time: %v", err)
+		}
+	}
+
+	repo := &itemRepository{db: db}
+	if err := repo.Insert(ctx, &Item{Name: "camera", Category: "camera", Images: []ItemImage{{Name: referenced}}}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	keys := func(orphans []OrphanImage) []string {
+		var keys []string
+		for _, orphan := range orphans {
+			keys = append(keys, orphan.Key)
+		}
+		return keys
+	}
+	// thumbnails of an image nobody stores any more are orphans too
+	wantDeleted := []string{thumbnailKey(orphan, 150), thumbnailKey(orphan, 600), hash("thumbnail") + "_w150.jpg", orphan}
+	slices.Sort(wantDeleted)
+
+	t.Run("ok: dry run", func(t *testing.T) {
+		report, err := CollectImages(ctx, db, store, ImageGCOptions{GracePeriod: DefaultImageGCGracePeriod, DryRun: true})
+		if err != nil {
+			t.Fatalf("failed to collect images: %v", err)
+		}
+		if diff := cmp.Diff(wantDeleted, keys(report.Deleted)); diff != "" {
+			t.Errorf("unexpected orphans (-want +got):\n%s", diff)
+		}
+		for _, key := range wantDeleted {
+			if exists, err := store.Exists(ctx, key); err != nil || !exists {
+				t.Errorf("expected %s to be kept in a dry run, got %v, %v", key, exists, err)
+			}
+		}
+	})
+
+	t.Run("ok: orphans are deleted", func(t *testing.T) {
+		report, err := CollectImages(ctx, db, store, ImageGCOptions{GracePeriod: DefaultImageGCGracePeriod})
+		if err != nil {
+			t.Fatalf("failed to collect images: %v", err)
+		}
+		if diff := cmp.Diff(wantDeleted, keys(report.Deleted)); diff != "" {
+			t.Errorf("unexpected orphans (-want +got):\n%s", diff)
+		}
+		want := ImageGCReport{Scanned: 10, Referenced: 3, Recent: 3, Deleted: report.Deleted}
+		if diff := cmp.Diff(want, *report); diff != "" {
+			t.Errorf("unexpected report (-want +got):\n%s", diff)
+		}
+		if got, want := report.DeletedBytes(), int64(len(strings.Join(wantDeleted, ""))); got != want {
+			t.Errorf("expected %d bytes to be deleted, got %d", want, got)
+		}
+
+		for _, key := range wantDeleted {
+			if exists, err := store.Exists(ctx, key); err != nil || exists {
+				t.Errorf("expected %s to be deleted, got %v, %v", key, exists, err)
+			}
+		}
+		for _, key := range []string{referenced, thumbnailKey(referenced, 150), recent, touched, defaultImage, "5e1f.jpg", hash("thumbnail") + "_wide.jpeg"} {
+			if exists, err := store.Exists(ctx, key); err != nil || !exists {
+				t.Errorf("expected %s to be kept, got %v, %v", key, exists, err)
+			}
+		}
+	})
+
+	t.Run("ok: no grace period", func(t *testing.T) {
+		report, err := CollectImages(ctx, db, store, ImageGCOptions{})
+		if err != nil {
+			t.Fatalf("failed to collect images: %v", err)
+		}
+		want := []string{recent, touched, thumbnailKey(touched, 150)}
+		slices.Sort(want)
+		if diff := cmp.Diff(want, keys(report.Deleted)); diff != "" {
+			t.Errorf("unexpected orphans (-want +got):\n%s", diff)
+		}
+	})
+}
+
+// deleteHookImageStore calls beforeDelete before deleting an image, to change things while CollectImages runs.
+type deleteHookImageStore struct {
+	ImageStore
+	beforeDelete func(key string)
+}
+
+func (s *deleteHookImageStore) Delete(ctx context.Context, key string) error {
+	s.beforeDelete(key)
+	return s.ImageStore.Delete(ctx, key)
+}
+
+func TestCollectImagesReused(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+	ctx := t.Context()
+	repo := &itemRepository{db: db}
+	opts := ImageGCOptions{GracePeriod: DefaultImageGCGracePeriod}
+	hash := func(s string) string { return fmt.Sprintf("%x", sha256.Sum256([]byte(s))) }
+
+	// age makes every image of dir older than the grace period
+	age := func(t *testing.T, dir string) {
+		t.Helper()
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("failed to read images: %v", err)
+		}
+		old := time.Now().Add(-2 * DefaultImageGCGracePeriod)
+		for _, entry := range entries {
+			if err := os.Chtimes(filepath.Join(dir, entry.Name()), old, old); err != nil {
+				t.Fatalf("failed to set the modification time: %v", err)
+			}
+		}
+	}
+
+	t.Run("ok: an old orphan an upload reuses is kept", func(t *testing.T) {
+		dir := t.TempDir()
+		h := &Handlers{images: NewFileImageStore(dir)}
+		store := func() string {
+			t.Helper()
+			upload, err := stageImage(strings.NewReader(testImageData), defaultMaxImageSize)
+			if err != nil {
+				t.Fatalf("failed to stage image: %v", err)
+			}
+			defer upload.Close()
+			name, err := h.storeImage(ctx, upload)
+			if err != nil {
+				t.Fatalf("failed to store image: %v", err)
+			}
+			return name
+		}
+
+		name := store()
+		age(t, dir)
+		// the upload is stored again, but its item is not written yet
+		if got := store(); got != name {
+			t.Fatalf("expected the upload to reuse %s, got %s", name, got)
+		}
+
+		report, err := CollectImages(ctx, db, h.images, opts)
+		if err != nil {
+			t.Fatalf("failed to collect images: %v", err)
+		}
+		if len(report.Deleted) != 0 || report.Recent != report.Scanned {
+			t.Errorf("expected the reused image to be kept as recent, got %+v", report)
+		}
+	})
+
+	t.Run("ok: an orphan referenced while orphans are deleted is kept", func(t *testing.T) {
+		dir := t.TempDir()
+		// the orphans are deleted in the order of their keys
+		first, late := "0"+hash("first")[1:]+".jpg", "f"+hash("late")[1:]+".jpg"
+		for _, key := range []string{first, late} {
+			if err := NewFileImageStore(dir).Put(ctx, key, strings.NewReader(key)); err != nil {
+				t.Fatalf("failed to put image: %v", err)
+			}
+		}
+		age(t, dir)
+
+		store := &deleteHookImageStore{ImageStore: NewFileImageStore(dir), beforeDelete: func(key string) {
+			if key != first {
+				return
+			}
+			if err := repo.Insert(ctx, &Item{Name: "late", Category: "camera", Image: late}); err != nil {
+				t.Errorf("failed to insert item: %v", err)
+			}
+		}}
+		report, err := CollectImages(ctx, db, store, opts)
+		if err != nil {
+			t.Fatalf("failed to collect images: %v", err)
+		}
+		if report.Referenced != 1 || len(report.Deleted) != 1 || report.Deleted[0].Key != first {
+			t.Errorf("expected only %s to be deleted, got %+v", first, report)
+		}
+		if exists, err := store.Exists(ctx, late); err != nil || !exists {
+			t.Errorf("expected %s to be kept, got %v, %v", late, exists, err)
+		}
+	})
+}
@@ -0,0 +1,187 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var errImageNotFound error = newError(ErrNotFound, "image not found")
+
+// ImageStore is where item images are kept, addressed by a key such as "<sha256>.jpg".
+// Keys are plain file names; validateImageKey rejects anything else.
+type ImageStore interface {
+	// Put stores body under key, replacing any image stored there.
+	Put(ctx context.Context, key string, body io.ReadSeeker) error
+	// Get opens the image stored under key, or returns errImageNotFound. The caller closes the image.
+	Get(ctx context.Context, key string) (io.ReadCloser, ImageInfo, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	// Touch sets the modification time of the image stored under key to now, or returns errImageNotFound.
+	Touch(ctx context.Context, key string) error
+	// Delete deletes the image stored under key. Deleting a missing image is not an error.
+	Delete(ctx context.Context, key string) error
+	// URL returns where clients can download the image stored under key.
+	URL(key string) string
+	// List calls fn for every object of the store in lexical order of their keys, stopping at the first error fn returns.
+	// Objects that are not images, such as files left behind by an interrupted Put, are listed too.
+	List(ctx context.Context, fn func(key string, info ImageInfo) error) error
+}
+
+// ImageInfo describes a stored image.
+type ImageInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// validateImageKey checks that key is a plain file name, so that it cannot escape the directory or bucket of a store.
+func validateImageKey(key string) error {
+	if key == "" || key == "." || key == ".." || strings.ContainsAny(key, `/\`) {
+		return &Error{Kind: ErrInvalidRequest, Message: "invalid image path", Err: fmt.Errorf("image key %q is not a plain file name", key)}
+	}
+	return nil
+}
+
+// fileImageStore is an ImageStore keeping images in a local directory.
+type fileImageStore struct {
+	dir string
+}
+
+// NewFileImageStore creates an ImageStore keeping images in dir.
+func NewFileImageStore(dir string) ImageStore {
+	return &fileImageStore{dir: dir}
+}
+
+// Put writes the image to a temporary file first, so that a concurrent Get never sees a partial image.
+func (f *fileImageStore) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	if err := validateImageKey(key); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(f.dir, ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := io.Copy(tmp, body); err != nil {
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(f.dir, key))
+}
+
+func (f *fileImageStore) Get(ctx context.Context, key string) (io.ReadCloser, ImageInfo, error) {
+	if err := validateImageKey(key); err != nil {
+		return nil, ImageInfo{}, err
+	}
+
+	file, err := os.Open(filepath.Join(f.dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ImageInfo{}, errImageNotFound
+	}
+	if err != nil {
+		return nil, ImageInfo{}, err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, ImageInfo{}, err
+	}
+	return file, ImageInfo{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (f *fileImageStore) Exists(ctx context.Context, key string) (bool, error) {
+	if err := validateImageKey(key); err != nil {
+		return false, err
+	}
+
+	_, err := os.Stat(filepath.Join(f.dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (f *fileImageStore) Touch(ctx context.Context, key string) error {
+	if err := validateImageKey(key); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	err := os.Chtimes(filepath.Join(f.dir, key), now, now)
+	if errors.Is(err, fs.ErrNotExist) {
+		return errImageNotFound
+	}
+	return err
+}
+
+func (f *fileImageStore) Delete(ctx context.Context, key string) error {
+	if err := validateImageKey(key); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(f.dir, key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// URL returns the path of GET /images/{filename}, since the API serves the images of the directory itself.
+func (f *fileImageStore) URL(key string) string {
+	return "/images/" + url.PathEscape(key)
+}
+
+func (f *fileImageStore) List(ctx context.Context, fn func(key string, info ImageInfo) error) error {
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		stat, err := entry.Info()
+		if errors.Is(err, fs.ErrNotExist) {
+			// deleted since the directory was read
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(entry.Name(), ImageInfo{Size: stat.Size(), ModTime: stat.ModTime()}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// NewImageStore creates the ImageStore selected by c.Images.Store:
+// "file" keeps images in c.ImageDir, and "s3" keeps them in the bucket described by c.Images.S3.
+func NewImageStore(c *Config) (ImageStore, error) {
+	switch c.Images.Store {
+	case "file":
+		return NewFileImageStore(c.ImageDir), nil
+	case "s3":
+		return NewS3ImageStore(c.Images.S3, &http.Client{Timeout: 30 * time.Second})
+	default:
+		return nil, fmt.Errorf("unknown image store %q: must be file or s3", c.Images.Store)
+	}
+}
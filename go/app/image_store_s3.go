@@ -0,0 +1,370 @@
+package app
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// S3Config configures an ImageStore backed by an S3-compatible service such as AWS S3 or MinIO.
+type S3Config struct {
+	// Endpoint is the base URL of the service, e.g. https://s3.ap-northeast-1.amazonaws.com or http://minio:9000.
+	// Buckets are addressed path-style, which every S3-compatible service supports.
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	// PublicURL is the base URL clients download images from, such as a CDN in front of the bucket.
+	// It defaults to the URL of the bucket on Endpoint.
+	PublicURL string `yaml:"public_url"`
+}
+
+// s3ImageStore is an ImageStore keeping images as objects of an S3 bucket.
+// It speaks the REST API directly so that the server does not depend on an SDK for five requests.
+type s3ImageStore struct {
+	cfg    S3Config
+	client *http.Client
+	// now is the clock requests are signed with.
+	now func() time.Time
+}
+
+// NewS3ImageStore creates an ImageStore keeping images in the bucket described by cfg.
+func NewS3ImageStore(cfg S3Config, client *http.Client) (ImageStore, error) {
+	if cfg.Endpoint == "" || cfg.Bucket == "" {
+		return nil, errors.New("s3 image store needs an endpoint and a bucket")
+	}
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("s3 image store needs an access key id and a secret access key")
+	}
+	if _, err := url.Parse(cfg.Endpoint); err != nil {
+		return nil, fmt.Errorf("invalid s3 endpoint: %w", err)
+	}
+	cfg.Endpoint = strings.TrimRight(cfg.Endpoint, "/")
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.PublicURL == "" {
+		cfg.PublicURL = cfg.Endpoint + "/" + cfg.Bucket
+	}
+	cfg.PublicURL = strings.TrimRight(cfg.PublicURL, "/")
+
+	return &s3ImageStore{cfg: cfg, client: client, now: time.Now}, nil
+}
+
+func (s *s3ImageStore) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	resp, err := s.do(ctx, http.MethodPut, key, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return s3Error(resp, key)
+	}
+	return nil
+}
+
+func (s *s3ImageStore) Get(ctx context.Context, key string) (io.ReadCloser, ImageInfo, error) {
+	resp, err := s.do(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, ImageInfo{}, err
+	}
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, ImageInfo{}, errImageNotFound
+	default:
+		defer resp.Body.Close()
+		return nil, ImageInfo{}, s3Error(resp, key)
+	}
+
+	info := ImageInfo{Size: resp.ContentLength}
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		info.ModTime = t
+	}
+	return resp.Body, info, nil
+}
+
+func (s *s3ImageStore) Exists(ctx context.Context, key string) (bool, error) {
+	resp, err := s.do(ctx, http.MethodHead, key, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+	return false, s3Error(resp, key)
+}
+
+// Touch copies the object onto itself to refresh its Last-Modified. S3 only allows such a copy when it replaces
+// the metadata, so the content type is set again.
+func (s *s3ImageStore) Touch(ctx context.Context, key string) error {
+	if err := validateImageKey(key); err != nil {
+		return err
+	}
+	path := "/" + s.cfg.Bucket + "/" + s3Escape(key)
+	header := http.Header{
+		"X-Amz-Copy-Source":        {path},
+		"X-Amz-Metadata-Directive": {"REPLACE"},
+	}
+	if contentType, ok := imageContentType(key); ok {
+		header.Set("Content-Type", contentType)
+	}
+	resp, err := s.send(ctx, http.MethodPut, path, nil, header, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// a copy can fail after S3 has answered 200, in which case the body is an error rather than a result
+		var result struct {
+			XMLName xml.Name
+		}
+		if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err == nil && result.XMLName.Local == "Error" {
+			return fmt.Errorf("s3 %s %s: copy failed", resp.Request.Method, key)
+		}
+		return nil
+	case http.StatusNotFound:
+		return errImageNotFound
+	}
+	return s3Error(resp, key)
+}
+
+func (s *s3ImageStore) Delete(ctx context.Context, key string) error {
+	resp, err := s.do(ctx, http.MethodDelete, key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusNoContent, http.StatusNotFound:
+		return nil
+	}
+	return s3Error(resp, key)
+}
+
+func (s *s3ImageStore) URL(key string) string {
+	return s.cfg.PublicURL + "/" + s3Escape(key)
+}
+
+// s3ListPage is the part of a ListObjectsV2 response the store reads.
+type s3ListPage struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through the bucket with ListObjectsV2, which returns keys in lexical order.
+func (s *s3ImageStore) List(ctx context.Context, fn func(key string, info ImageInfo) error) error {
+	query := url.Values{"list-type": {"2"}}
+	for {
+		resp, err := s.send(ctx, http.MethodGet, "/"+s.cfg.Bucket, query, nil, nil)
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			defer resp.Body.Close()
+			return s3Error(resp, "?list-type=2")
+		}
+		var page s3ListPage
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("s3 list %s: %w", s.cfg.Bucket, err)
+		}
+
+		for _, object := range page.Contents {
+			if err := fn(object.Key, ImageInfo{Size: object.Size, ModTime: object.LastModified}); err != nil {
+				return err
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for the object stored under key.
+func (s *s3ImageStore) do(ctx context.Context, method, key string, body io.ReadSeeker) (*http.Response, error) {
+	if err := validateImageKey(key); err != nil {
+		return nil, err
+	}
+	return s.send(ctx, method, "/"+s.cfg.Bucket+"/"+s3Escape(key), nil, nil, body)
+}
+
+// send sends a signed request for the escaped path on the endpoint, with an optional query, headers and body.
+func (s *s3ImageStore) send(ctx context.Context, method, path string, query url.Values, header http.Header, body io.ReadSeeker) (*http.Response, error) {
+	target := s.cfg.Endpoint + path
+	if len(query) > 0 {
+		target += "?" + canonicalQuery(query)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		h := sha256.New()
+		size, err := io.Copy(h, body)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := body.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		payloadHash = hex.EncodeToString(h.Sum(nil))
+
+		req.Body = io.NopCloser(body)
+		req.ContentLength = size
+		if contentType, ok := imageContentType(path); ok {
+			req.Header.Set("Content-Type", contentType)
+		}
+	}
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signV4(req, payloadHash, s.cfg.AccessKeyID, s.cfg.SecretAccessKey, s.cfg.Region, "s3", s.now())
+
+	return s.client.Do(req)
+}
+
+// s3Error builds an error from a failed response, including the S3 error code when the body has one.
+func s3Error(resp *http.Response, key string) error {
+	var body struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Code != "" {
+		return fmt.Errorf("s3 %s %s: %s: %s: %s", resp.Request.Method, key, resp.Status, body.Code, body.Message)
+	}
+	return fmt.Errorf("s3 %s %s: %s", resp.Request.Method, key, resp.Status)
+}
+
+// emptyPayloadHash is the SHA-256 of an empty body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// signV4 signs req with AWS Signature Version 4 as of now.
+// The signature covers the host, the content type and every X-Amz-* header set on req,
+// and payloadHash is the hex SHA-256 of the body.
+func signV4(req *http.Request, payloadHash, accessKeyID, secretAccessKey, region, service string, now time.Time) {
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalURI := req.URL.EscapedPath()
+	if canonicalURI == "" {
+		canonicalURI = "/"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI,
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	digest := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(digest[:])
+
+	key := []byte("AWS4" + secretAccessKey)
+	for _, part := range []string{date, region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+// canonicalQuery encodes q sorted by key and then by value, as Signature Version 4 requires.
+func canonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for key := range q {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := append([]string(nil), q[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, s3Escape(key)+"="+s3Escape(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// s3Escape percent-encodes every byte of s except the unreserved characters of RFC 3986.
+func s3Escape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,368 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// testImageStore runs the behaviour every ImageStore shares against store.
+func testImageStore(t *testing.T, store ImageStore) {
+	t.Helper()
+	ctx := t.Context()
+
+	if exists, err := store.Exists(ctx, "a.jpg"); err != nil || exists {
+		t.Fatalf("expected a.jpg not to exist, got %v, %v", exists, err)
+	}
+	if _, _, err := store.Get(ctx, "a.jpg"); !errors.Is(err, errImageNotFound) {
+		t.Fatalf("expected errImageNotFound, got %v", err)
+	}
+	if err := store.Touch(ctx, "a.jpg"); !errors.Is(err, errImageNotFound) {
+		t.Fatalf("expected errImageNotFound for touching a missing image, got %v", err)
+	}
+
+	if err := store.Put(ctx, "a.jpg", strings.NewReader("first")); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+	// a second put replaces the image
+	if err := store.Put(ctx, "a.jpg", strings.NewReader("second")); err != nil {
+		t.Fatalf("failed to put image: %v", err)
+	}
+
+	if exists, err := store.Exists(ctx, "a.jpg"); err != nil || !exists {
+		t.Fatalf("expected a.jpg to exist, got %v, %v", exists, err)
+	}
+	body, info, err := store.Get(ctx, "a.jpg")
+	if err != nil {
+		t.Fatalf("failed to get image: %v", err)
+	}
+	got, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		t.Fatalf("failed to read image: %v", err)
+	}
+	if string(got) != "second" {
+		t.Errorf("expected the image to be %q, got %q", "second", got)
+	}
+	if info.Size != int64(len("second")) {
+		t.Errorf("expected size %d, got %d", len("second"), info.Size)
+	}
+	if info.ModTime.IsZero() {
+		t.Error("expected a modification time")
+	}
+	if err := store.Touch(ctx, "a.jpg"); err != nil {
+		t.Fatalf("failed to touch image: %v", err)
+	}
+
+	for _, key := range []string{"c.png", "b.gif"} {
+		if err := store.Put(ctx, key, strings.NewReader(key)); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+	}
+	var listed []string
+	err = store.List(ctx, func(key string, info ImageInfo) error {
+		listed = append(listed, key)
+		if info.Size == 0 || info.ModTime.IsZero() {
+			t.Errorf("expected the size and modification time of %s, got %+v", key, info)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to list images: %v", err)
+	}
+	if diff := cmp.Diff([]string{"a.jpg", "b.gif", "c.png"}, listed); diff != "" {
+		t.Errorf("unexpected images (-want +got):\n%s", diff)
+	}
+	errStop := errors.New("stop")
+	if err := store.List(ctx, func(string, ImageInfo) error { return errStop }); !errors.Is(err, errStop) {
+		t.Errorf("expected List to return the error of fn, got %v", err)
+	}
+	for _, key := range []string{"c.png", "b.gif"} {
+		if err := store.Delete(ctx, key); err != nil {
+			t.Fatalf("failed to delete image: %v", err)
+		}
+	}
+
+	if err := store.Delete(ctx, "a.jpg"); err != nil {
+		t.Fatalf("failed to delete image: %v", err)
+	}
+	if exists, err := store.Exists(ctx, "a.jpg"); err != nil || exists {
+		t.Errorf("expected a.jpg to be deleted, got %v, %v", exists, err)
+	}
+	if err := store.Delete(ctx, "a.jpg"); err != nil {
+		t.Errorf("expected deleting a missing image to succeed, got %v", err)
+	}
+
+	for _, key := range []string{"", "..", "../a.jpg", "dir/a.jpg"} {
+		if err := store.Put(ctx, key, strings.NewReader("x")); !errors.Is(err, ErrInvalidRequest) {
+			t.Errorf("expected key %q to be rejected, got %v", key, err)
+		}
+	}
+}
+
+func TestFileImageStore(t *testing.T) {
+	t.Parallel()
+
+	store := NewFileImageStore(t.TempDir())
+	testImageStore(t, store)
+
+	if got := store.URL("a b.jpg"); got != "/images/a%20b.jpg" {
+		t.Errorf("unexpected url: %s", got)
+	}
+}
+
+func TestS3ImageStore(t *testing.T) {
+	t.Parallel()
+
+	fake := newFakeS3("images", "key-id", "secret")
+	// list a page per image, so that the listing has to follow continuation tokens
+	fake.pageSize = 1
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	cfg := S3Config{Endpoint: server.URL, Region: "ap-northeast-1", Bucket: "images", AccessKeyID: "key-id", SecretAccessKey: "secret"}
+	store, err := NewS3ImageStore(cfg, server.Client())
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	testImageStore(t, store)
+
+	t.Run("ok: url", func(t *testing.T) {
+		if got, want := store.URL("a.jpg"), server.URL+"/images/a.jpg"; got != want {
+			t.Errorf("expected url %s, got %s", want, got)
+		}
+
+		cfg := cfg
+		cfg.PublicURL = "https://cdn.example.com/"
+		store, err := NewS3ImageStore(cfg, server.Client())
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		if got, want := store.URL("a.jpg"), "https://cdn.example.com/a.jpg"; got != want {
+			t.Errorf("expected url %s, got %s", want, got)
+		}
+	})
+
+	t.Run("ok: touch keeps the image", func(t *testing.T) {
+		if err := store.Put(t.Context(), "t.jpg", strings.NewReader("touched")); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+		old := time.Now().Add(-time.Hour)
+		fake.mu.Lock()
+		fake.objects["t.jpg"] = fakeS3Object{data: fake.objects["t.jpg"].data, modTime: old}
+		fake.mu.Unlock()
+
+		if err := store.Touch(t.Context(), "t.jpg"); err != nil {
+			t.Fatalf("failed to touch image: %v", err)
+		}
+		fake.mu.Lock()
+		object := fake.objects["t.jpg"]
+		fake.mu.Unlock()
+		if !object.modTime.After(old) {
+			t.Errorf("expected the modification time to be refreshed, got %v", object.modTime)
+		}
+		if string(object.data) != "touched" {
+			t.Errorf("expected the image to be kept, got %q", object.data)
+		}
+	})
+
+	t.Run("ng: wrong secret", func(t *testing.T) {
+		cfg := cfg
+		cfg.SecretAccessKey = "wrong"
+		store, err := NewS3ImageStore(cfg, server.Client())
+		if err != nil {
+			t.Fatalf("failed to create store: %v", err)
+		}
+		err = store.Put(t.Context(), "b.jpg", strings.NewReader("x"))
+		if err == nil || !strings.Contains(err.Error(), "SignatureDoesNotMatch") {
+			t.Errorf("expected a signature error, got %v", err)
+		}
+	})
+
+	t.Run("ng: incomplete config", func(t *testing.T) {
+		if _, err := NewS3ImageStore(S3Config{Endpoint: server.URL, Bucket: "images"}, server.Client()); err == nil {
+			t.Error("expected an error without credentials")
+		}
+	})
+}
+
+// TestSignV4 checks the signer against the get-vanilla case of the AWS Signature Version 4 test suite.
+func TestSignV4(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, emptyPayloadHash, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("unexpected authorization:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+// fakeS3 is an in-memory S3-compatible server with a single bucket, like a local MinIO.
+// It checks the signature of every request the way S3 does.
+type fakeS3 struct {
+	bucket, accessKeyID, secretAccessKey string
+
+	// pageSize is the number of keys per page of a listing, 1000 like S3 when zero.
+	pageSize int
+
+	mu      sync.Mutex
+	objects map[string]fakeS3Object
+}
+
+type fakeS3Object struct {
+	data    []byte
+	modTime time.Time
+}
+
+func newFakeS3(bucket, accessKeyID, secretAccessKey string) *fakeS3 {
+	return &fakeS3{bucket: bucket, accessKeyID: accessKeyID, secretAccessKey: secretAccessKey, objects: map[string]fakeS3Object{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if code := f.authenticate(r, body); code != "" {
+		w.WriteHeader(http.StatusForbidden)
+		io.WriteString(w, "<Error><Code>"+code+"</Code><Message>request rejected</Message></Error>")
+		return
+	}
+
+	if r.URL.Path == "/"+f.bucket && r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2" {
+		f.list(w, r.URL.Query().Get("continuation-token"))
+		return
+	}
+
+	key, ok := strings.CutPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if !ok || key == "" {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchBucket</Code></Error>")
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodPut:
+		if source := r.Header.Get("X-Amz-Copy-Source"); source != "" {
+			f.copy(w, key, source, r.Header.Get("X-Amz-Metadata-Directive"))
+			return
+		}
+		f.objects[key] = fakeS3Object{data: body, modTime: time.Now()}
+	case http.MethodGet, http.MethodHead:
+		object, ok := f.objects[key]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Last-Modified", object.modTime.UTC().Format(http.TimeFormat))
+		http.ServeContent(w, r, key, object.modTime, bytes.NewReader(object.data))
+	case http.MethodDelete:
+		delete(f.objects, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// copy copies the object at source, "/<bucket>/<escaped key>", to key. Like S3, it refuses to copy an object
+// onto itself without replacing its metadata. f.mu is held.
+func (f *fakeS3) copy(w http.ResponseWriter, key, source, directive string) {
+	sourceKey, err := url.PathUnescape(strings.TrimPrefix(source, "/"+f.bucket+"/"))
+	if err != nil || (sourceKey == key && directive != "REPLACE") {
+		w.WriteHeader(http.StatusBadRequest)
+		io.WriteString(w, "<Error><Code>InvalidRequest</Code></Error>")
+		return
+	}
+	object, ok := f.objects[sourceKey]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		io.WriteString(w, "<Error><Code>NoSuchKey</Code></Error>")
+		return
+	}
+	f.objects[key] = fakeS3Object{data: object.data, modTime: time.Now()}
+	io.WriteString(w, "<CopyObjectResult></CopyObjectResult>")
+}
+
+// list writes the page of a ListObjectsV2 listing following the key token, which is the last key of the previous page.
+func (f *fakeS3) list(w http.ResponseWriter, token string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	keys := make([]string, 0, len(f.objects))
+	for key := range f.objects {
+		if key > token {
+			keys = append(keys, key)
+		}
+	}
+	slices.Sort(keys)
+	pageSize := f.pageSize
+	if pageSize == 0 {
+		pageSize = 1000
+	}
+
+	var page bytes.Buffer
+	page.WriteString("<ListBucketResult>")
+	for i, key := range keys {
+		if i == pageSize {
+			fmt.Fprintf(&page, "<IsTruncated>true</IsTruncated><NextContinuationToken>%s</NextContinuationToken>", keys[i-1])
+			break
+		}
+		object := f.objects[key]
+		fmt.Fprintf(&page, "<Contents><Key>%s</Key><LastModified>%s</LastModified><Size>%d</Size></Contents>",
+			key, object.modTime.UTC().Format(time.RFC3339Nano), len(object.data))
+	}
+	page.WriteString("</ListBucketResult>")
+	w.Header().Set("Content-Type", "application/xml")
+	w.Write(page.Bytes())
+}
+
+// authenticate returns the S3 error code of a request that is not correctly signed, or "" when it is.
+func (f *fakeS3) authenticate(r *http.Request, body []byte) string {
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	sum := sha256.Sum256(body)
+	if payloadHash != hex.EncodeToString(sum[:]) {
+		return "XAmzContentSHA256Mismatch"
+	}
+	now, err := time.Parse("20060102T150405Z", r.Header.Get("X-Amz-Date"))
+	if err != nil {
+		return "AccessDenied"
+	}
+
+	// sign the request as received and compare the result with what the client sent
+	signed, err := http.NewRequestWithContext(context.Background(), r.Method, "http://"+r.Host+r.URL.RequestURI(), nil)
+	if err != nil {
+		return "AccessDenied"
+	}
+	for name, values := range r.Header {
+		if name == "Content-Type" || strings.HasPrefix(name, "X-Amz-") {
+			signed.Header[name] = values
+		}
+	}
+	signV4(signed, payloadHash, f.accessKeyID, f.secretAccessKey, "ap-northeast-1", "s3", now)
+	if signed.Header.Get("Authorization") != r.Header.Get("Authorization") {
+		return "SignatureDoesNotMatch"
+	}
+	return ""
+}
@@ -0,0 +1,406 @@
+package app
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestSniffImage(t *testing.T) {
+	t.Parallel()
+
+	img := image.NewRGBA(image.Rect(0, 0, 2, 1))
+	encode := func(enc func(*bytes.Buffer) error) []byte {
+		var buf bytes.Buffer
+		if err := enc(&buf); err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		return buf.Bytes()
+	}
+	// a 1x1 lossless WebP image; the standard library has no WebP encoder
+	webp, err := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+	if err != nil {
+		t.Fatalf("failed to decode webp: %v", err)
+	}
+
+	cases := map[string]struct {
+		data []byte
+		want imageFormat
+		err  error
+	}{
+		"ok: jpeg": {
+			data: encode(func(b *bytes.Buffer) error { return jpeg.Encode(b, img, nil) }),
+			want: imageFormat{ext: ".jpg", contentType: "image/jpeg", thumbnailExt: ".jpg"},
+		},
+		"ok: png": {
+			data: encode(func(b *bytes.Buffer) error { return png.Encode(b, img) }),
+			want: imageFormat{ext: ".png", contentType: "image/png", thumbnailExt: ".png"},
+		},
+		"ok: gif": {
+			data: encode(func(b *bytes.Buffer) error { return gif.Encode(b, img, nil) }),
+			want: imageFormat{ext: ".gif", contentType: "image/gif", thumbnailExt: ".png"},
+		},
+		"ok: webp": {
+			data: webp,
+			want: imageFormat{ext: ".webp", contentType: "image/webp", thumbnailExt: ".jpg"},
+		},
+		"ng: text": {
+			data: []byte("test.jpg"),
+			err:  ErrUnsupportedMediaType,
+		},
+		"ng: truncated png header": {
+			data: []byte("\x89PNG\r\n\x1a\n"),
+			err:  ErrUnsupportedMediaType,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := sniffImage(bytes.NewReader(tt.data))
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("expected %v, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("expected %+v, got %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestImageContentType(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		key  string
+		want string
+		ok   bool
+	}{
+		"jpg":          {key: "a.jpg", want: "image/jpeg", ok: true},
+		"legacy jpeg":  {key: "a.jpeg", want: "image/jpeg", ok: true},
+		"upper case":   {key: "a.PNG", want: "image/png", ok: true},
+		"webp":         {key: "a.webp", want: "image/webp", ok: true},
+		"unknown":      {key: "a.txt"},
+		"no extension": {key: "a"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, ok := imageContentType(tt.key)
+			if got != tt.want || ok != tt.ok {
+				t.Errorf("expected %q, %v, got %q, %v", tt.want, tt.ok, got, ok)
+			}
+		})
+	}
+}
+
+func TestOrientImage(t *testing.T) {
+	t.Parallel()
+
+	// a b c
+	// d e f
+	// away from the origin, like the sub-images of larger images
+	src := image.NewGray(image.Rect(10, 20, 13, 22))
+	copy(src.Pix, []byte("abcdef"))
+
+	cases := map[int][]string{
+		1: {"abc", "def"},
+		2: {"cba", "fed"},
+		3: {"fed", "cba"},
+		4: {"def", "abc"},
+		5: {"ad", "be", "cf"},
+		6: {"da", "eb", "fc"},
+		7: {"fc", "eb", "da"},
+		8: {"cf", "be", "ad"},
+	}
+
+	for orientation, want := range cases {
+		t.Run(strconv.Itoa(orientation), func(t *testing.T) {
+			t.Parallel()
+
+			img := orientImage(src, orientation)
+			bounds := img.Bounds()
+			var got []string
+			for y := range bounds.Dy() {
+				var row []byte
+				for x := range bounds.Dx() {
+					row = append(row, color.GrayModel.Convert(img.At(bounds.Min.X+x, bounds.Min.Y+y)).(color.Gray).Y)
+				}
+				got = append(got, string(row))
+			}
+			if diff := cmp.Diff(want, got); diff != "" {
+				t.Errorf("unexpected pixels (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func BenchmarkOrientImage(b *testing.B) {
+	// a photo as a phone camera stores it, decoded from JPEG
+	src := image.NewYCbCr(image.Rect(0, 0, 4032, 3024), image.YCbCrSubsampleRatio420)
+
+	for _, orientation := range []int{3, 6} {
+		b.Run(strconv.Itoa(orientation), func(b *testing.B) {
+			for b.Loop() {
+				orientImage(src, orientation)
+			}
+		})
+	}
+}
+
+func TestProcessImage(t *testing.T) {
+	t.Parallel()
+
+	// withExif inserts an APP1 segment with the given orientation and a fake GPS position after the SOI marker of a JPEG image.
+	withExif := func(data []byte, order binary.AppendByteOrder, orientation uint16) []byte {
+		tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+		if order == binary.LittleEndian {
+			tiff = []byte("II\x2a\x00\x08\x00\x00\x00")
+		}
+		tiff = order.AppendUint16(tiff, 1)
+		tiff = order.AppendUint16(tiff, 0x0112)
+		tiff = order.AppendUint16(tiff, 3)
+		tiff = order.AppendUint32(tiff, 1)
+		tiff = order.AppendUint16(tiff, orientation)
+		tiff = append(tiff, 0, 0, 0, 0, 0, 0)
+		tiff = append(tiff, "GPS 35.6812N 139.7671E"...)
+
+		segment := append([]byte("Exif\x00\x00"), tiff...)
+		app1 := binary.BigEndian.AppendUint16([]byte{0xFF, 0xE1}, uint16(len(segment)+2))
+		return slices.Concat(data[:2], app1, segment, data[2:])
+	}
+
+	// a landscape photo, red on the left and blue on the right
+	photo := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for y := range 400 {
+		for x := range 800 {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 400 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			photo.Set(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, photo, nil); err != nil {
+		t.Fatalf("failed to encode image: %v", err)
+	}
+	jpegData := buf.Bytes()
+
+	// process returns the processed image along with its original, read in full
+	process := func(t *testing.T, data []byte) (*processedImage, []byte) {
+		t.Helper()
+		format, err := sniffImage(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to sniff image: %v", err)
+		}
+		p, err := processImage(bytes.NewReader(data), format)
+		if err != nil {
+			t.Fatalf("failed to process image: %v", err)
+		}
+		original, err := io.ReadAll(p.original)
+		if err != nil {
+			t.Fatalf("failed to read the original: %v", err)
+		}
+		return p, original
+	}
+	decode := func(t *testing.T, data []byte) image.Image {
+		t.Helper()
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("failed to decode image: %v", err)
+		}
+		return img
+	}
+
+	t.Run("ok: exif orientation is applied and exif is stripped", func(t *testing.T) {
+		t.Parallel()
+
+		for _, order := range []binary.AppendByteOrder{binary.BigEndian, binary.LittleEndian} {
+			data := withExif(jpegData, order, 6)
+			if got := jpegOrientation(data); got != 6 {
+				t.Fatalf("expected orientation 6, got %d", got)
+			}
+
+			_, original := process(t, data)
+			if bytes.Contains(original, []byte("Exif")) || bytes.Contains(original, []byte("GPS")) {
+				t.Error("expected exif data to be stripped")
+			}
+
+			// rotated clockwise, the red left half becomes the top half
+			img := decode(t, original)
+			if got := img.Bounds().Size(); got != image.Pt(400, 800) {
+				t.Fatalf("expected a 400x800 image, got %v", got)
+			}
+			if r, _, b, _ := img.At(200, 100).RGBA(); r < b {
+				t.Errorf("expected the top to be red, got r=%d b=%d", r, b)
+			}
+			if r, _, b, _ := img.At(200, 700).RGBA(); r > b {
+				t.Errorf("expected the bottom to be blue, got r=%d b=%d", r, b)
+			}
+		}
+	})
+
+	t.Run("ok: thumbnails keep the aspect ratio", func(t *testing.T) {
+		t.Parallel()
+
+		p, _ := process(t, jpegData)
+		want := map[int]image.Point{150: image.Pt(150, 75), 600: image.Pt(600, 300)}
+		for width, size := range want {
+			if got := decode(t, p.thumbnails[width]).Bounds().Size(); got != size {
+				t.Errorf("expected the %dpx thumbnail to be %v, got %v", width, size, got)
+			}
+		}
+	})
+
+	t.Run("ok: small images are not enlarged", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 100, 50))); err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		p, _ := process(t, buf.Bytes())
+		for _, width := range thumbnailWidths {
+			img, format, err := image.Decode(bytes.NewReader(p.thumbnails[width]))
+			if err != nil {
+				t.Fatalf("failed to decode thumbnail: %v", err)
+			}
+			if format != "png" || img.Bounds().Size() != image.Pt(100, 50) {
+				t.Errorf("expected a 100x50 png thumbnail, got a %v %s", img.Bounds().Size(), format)
+			}
+		}
+	})
+
+	t.Run("ok: webp metadata chunks are removed", func(t *testing.T) {
+		t.Parallel()
+
+		lossless, err := base64.StdEncoding.DecodeString("UklGRhoAAABXRUJQVlA4TA0AAAAvAAAAEAcQERGIiP4HAA==")
+		if err != nil {
+			t.Fatalf("failed to decode webp: %v", err)
+		}
+		exif := []byte("GPS 35.6812N 139.7671E")
+		data := slices.Concat(
+			[]byte("RIFF\x00\x00\x00\x00WEBP"),
+			// VP8X with the EXIF flag set on a 1x1 canvas
+			[]byte("VP8X\x0a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+			lossless[12:],
+			[]byte("EXIF"), binary.LittleEndian.AppendUint32(nil, uint32(len(exif))), exif,
+		)
+		binary.LittleEndian.PutUint32(data[4:], uint32(len(data)-8))
+
+		_, original := process(t, data)
+		if bytes.Contains(original, []byte("EXIF")) || bytes.Contains(original, []byte("GPS")) {
+			t.Error("expected the EXIF chunk to be removed")
+		}
+		if original[20]&0x08 != 0 {
+			t.Error("expected the EXIF flag to be cleared")
+		}
+		if got := decode(t, original).Bounds().Size(); got != image.Pt(1, 1) {
+			t.Errorf("expected a 1x1 image, got %v", got)
+		}
+	})
+
+	t.Run("ok: gif originals are kept as they are", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		if err := gif.Encode(&buf, image.NewPaletted(image.Rect(0, 0, 20, 10), color.Palette{color.White, color.Black}), nil); err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		_, original := process(t, buf.Bytes())
+		if !bytes.Equal(original, buf.Bytes()) {
+			t.Error("expected the gif to be kept as it is")
+		}
+	})
+
+	t.Run("ng: too large", func(t *testing.T) {
+		t.Parallel()
+
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, maxImageDimension+1, 1))); err != nil {
+			t.Fatalf("failed to encode image: %v", err)
+		}
+		if _, err := sniffImage(&buf); !errors.Is(err, ErrValidation) {
+			t.Errorf("expected a validation error, got %v", err)
+		}
+	})
+}
+
+func TestThumbnailKey(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		key   string
+		width int
+		want  string
+	}{
+		"jpeg":        {key: "abc.jpg", width: 150, want: "abc_w150.jpg"},
+		"legacy jpeg": {key: "abc.jpeg", width: 150, want: "abc_w150.jpg"},
+		"gif":         {key: "abc.gif", width: 600, want: "abc_w600.png"},
+		"webp":        {key: "abc.webp", width: 600, want: "abc_w600.jpg"},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := thumbnailKey(tt.key, tt.width); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestContentAddressed(t *testing.T) {
+	t.Parallel()
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte("image")))
+	cases := map[string]struct {
+		key  string
+		want bool
+	}{
+		"original":           {key: hash + ".jpg", want: true},
+		"thumbnail":          {key: hash + "_w150.png", want: true},
+		"legacy extension":   {key: hash + ".jpeg", want: true},
+		"default image":      {key: defaultImage, want: false},
+		"short hash":         {key: hash[:32] + ".jpg", want: false},
+		"not hex":            {key: strings.Repeat("z", 64) + ".jpg", want: false},
+		"not a width":        {key: hash + "_wide.jpg", want: false},
+		"hash with a suffix": {key: hash + "-copy.jpg", want: false},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			if got := contentAddressed(tt.key); got != tt.want {
+				t.Errorf("expected %v for %s, got %v", tt.want, tt.key, got)
+			}
+		})
+	}
+}
@@ -4,19 +4,168 @@ import (
 	"context"
 	"database/sql"
 	"errors"
-	"log/slog"
-	"os"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf8"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-var errImageNotFound = errors.New("image not found")
+var (
+	errItemNotFound  error = newError(ErrNotFound, "item not found")
+	errItemNotOnSale error = newError(ErrConflict, "item is not on sale")
+	errItemHasOrders error = newError(ErrConflict, "item has orders")
+	errItemNotTraded error = newError(ErrConflict, "item is not in a trade")
+	errTradeChanged  error = newError(ErrConflict, "the trade of the item has changed")
+)
 
 type Item struct {
 	ID       int    `db:"id" json:"-"`
 	Name     string `db:"name" json:"name"`
 	Category string `db:"category" json:"category"`
-	Image    string `db:"image_name" json:"image_name"`
+	// Image is the main image of the item, the first of Images.
+	Image string `db:"image_name" json:"image_name"`
+	// ImageURL is where clients download the image from. Handlers set it from the ImageStore; it is not stored.
+	ImageURL string `db:"-" json:"image_url,omitempty"`
+	// Images are all the images of the item in the order the seller arranged them.
+	Images []ItemImage `db:"-" json:"images"`
+	// SellerID is the id of the user who listed the item, or 0 for items listed before accounts existed.
+	SellerID int `db:"seller_id" json:"seller_id,omitempty"`
+	// Price is in the minor unit of Currency, e.g. yen for JPY and cents for USD.
+	Price       int64     `db:"price" json:"price"`
+	Currency    string    `db:"currency" json:"currency"`
+	Description string    `db:"description" json:"description"`
+	Condition   Condition `db:"condition" json:"condition"`
+	// Status only changes through the transitions allowed by ItemStatus.CanTransitionTo.
+	Status    ItemStatus `db:"status" json:"status"`
+	CreatedAt time.Time  `db:"created_at" json:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at" json:"updated_at"`
+}
+
+// Condition is the state of a listed item.
+type Condition string
+
+const (
+	ConditionNew     Condition = "new"
+	ConditionLikeNew Condition = "like-new"
+	ConditionGood    Condition = "good"
+	ConditionFair    Condition = "fair"
+	ConditionPoor    Condition = "poor"
+)
+
+// Valid reports whether c is a known condition.
+func (c Condition) Valid() bool {
+	switch c {
+	case ConditionNew, ConditionLikeNew, ConditionGood, ConditionFair, ConditionPoor:
+		return true
+	}
+	return false
+}
+
+// ItemStatus is where an item is in its sale.
+type ItemStatus string
+
+const (
+	// ItemStatusOnSale means the item is listed and can be purchased.
+	ItemStatusOnSale ItemStatus = "on_sale"
+	// ItemStatusTrading means a buyer has purchased the item and the trade is in progress.
+	ItemStatusTrading ItemStatus = "trading"
+	// ItemStatusSoldOut means the trade has completed.
+	ItemStatusSoldOut ItemStatus = "sold_out"
+)
+
+// itemStatusTransitions lists the statuses each status may move to.
+// A trade in progress can be completed or cancelled; a sold out item is final.
+var itemStatusTransitions = map[ItemStatus][]ItemStatus{
+	ItemStatusOnSale:  {ItemStatusTrading},
+	ItemStatusTrading: {ItemStatusSoldOut, ItemStatusOnSale},
+	ItemStatusSoldOut: nil,
+}
+
+// Valid reports whether s is a known status.
+func (s ItemStatus) Valid() bool {
+	_, ok := itemStatusTransitions[s]
+	return ok
+}
+
+// CanTransitionTo reports whether an item may move from s to next.
+func (s ItemStatus) CanTransitionTo(next ItemStatus) bool {
+	for _, t := range itemStatusTransitions[s] {
+		if t == next {
+			return true
+		}
+	}
+	return false
+}
+
+// timestampLayout is how timestamps are stored. It is fixed-width so that the text sorts in time order.
+const timestampLayout = "2006-01-02T15:04:05.000Z"
+
+// formatTimestamp formats t for storage.
+func formatTimestamp(t time.Time) string {
+	return t.UTC().Format(timestampLayout)
+}
+
+// ItemSortKey is a key GetAllItem can order items by.
+type ItemSortKey string
+
+const (
+	ItemSortCreatedAt ItemSortKey = "created_at"
+	ItemSortName      ItemSortKey = "name"
+	ItemSortID        ItemSortKey = "id"
+)
+
+// itemSortColumns maps each sort key to the column it orders by.
+var itemSortColumns = map[ItemSortKey]string{
+	ItemSortCreatedAt: "items.created_at",
+	ItemSortName:      "items.name",
+	ItemSortID:        "items.id",
+}
+
+// SortOrder is the direction of a sort.
+type SortOrder string
+
+const (
+	SortAsc  SortOrder = "asc"
+	SortDesc SortOrder = "desc"
+)
+
+// ItemCursor points at the last item of a page.
+// The next page starts right after it, so rows inserted concurrently never shift pages.
+type ItemCursor struct {
+	Sort  ItemSortKey `json:"s"`
+	Order SortOrder   `json:"o"`
+	// Value is the sort key of the last item. It is empty when the sort column is the id itself.
+	Value string `json:"v,omitempty"`
+	ID    int    `json:"i"`
+}
+
+// ListItemsOptions controls which page of items GetAllItem returns.
+type ListItemsOptions struct {
+	// SellerID restricts the items to the ones listed by the seller, unless it is 0.
+	SellerID int
+	// CategoryID restricts the items to the ones in the category or its subcategories, unless it is 0.
+	CategoryID int
+	Limit      int
+	Sort       ItemSortKey
+	Order      SortOrder
+	// After is the cursor of the previous page, or nil for the first page.
+	After *ItemCursor
+}
+
+// ItemUpdate is a partial update of an item. Its nil fields are left as they are, so that concurrent updates
+// of different fields do not undo each other.
+type ItemUpdate struct {
+	Name        *string
+	Category    *string
+	Price       *int64
+	Currency    *string
+	Description *string
+	Condition   *Condition
+	// Images replace all the images of the item unless nil. Otherwise the images are left as they are,
+	// so that an update does not undo the images added to the item since it was read.
+	Images []ItemImage
 }
 
 // Please run `go generate ./...` to generate the mock implementation
@@ -25,134 +174,799 @@ type Item struct {
 //go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
 type ItemRepository interface {
 	Insert(ctx context.Context, item *Item) error
-	GetAllItem(ctx context.Context) ([]Item, error)
+	GetAllItem(ctx context.Context, opts ListItemsOptions) ([]Item, *ItemCursor, error)
 	GetItemById(ctx context.Context, itemId string) (Item, error)
-	SearchItemsByKeyword(ctx context.Context, keyword string) ([]Item, error)
+	SearchItemsByKeyword(ctx context.Context, opts SearchItemsOptions) ([]ItemSearchHit, *SearchCursor, error)
+	CountItemFacets(ctx context.Context, query SearchQuery, filter ItemFilter) (ItemFacets, error)
+	// Update applies update to the item and returns the item as updated.
+	Update(ctx context.Context, itemId string, update ItemUpdate) (Item, error)
+	// Delete deletes the item along with its images. It fails with errItemNotOnSale or errItemHasOrders
+	// unless the item is on sale and was never ordered, so that orders keep their item.
+	Delete(ctx context.Context, itemId string) error
+	// Purchase moves the item from on sale to trading and records the order of buyerID in one transaction.
+	// It fails with errItemNotOnSale when the item is no longer on sale.
+	Purchase(ctx context.Context, itemId string, buyerID int) (Order, error)
+	// GetTradeOrder returns the order of the trade the item is in, or errItemNotTraded when it is not trading.
+	GetTradeOrder(ctx context.Context, itemId string) (Order, error)
+	// CompleteTrade moves the item of order from trading to sold out, and CancelTrade puts it back on sale.
+	// They fail with ErrConflict unless the item is still in the trade of order.
+	CompleteTrade(ctx context.Context, order Order) error
+	CancelTrade(ctx context.Context, order Order) error
+	AddImages(ctx context.Context, itemId string, names []string) ([]ItemImage, error)
+	DeleteImage(ctx context.Context, itemId string, imageID int) ([]ItemImage, error)
+	ReorderImages(ctx context.Context, itemId string, imageIDs []int) ([]ItemImage, error)
 }
 
 // itemRepository is an implementation of ItemRepository
 type itemRepository struct {
 	db *sql.DB
+	// metrics times the operations, nil to not time them.
+	metrics *Metrics
 }
 
-// NewItemRepository creates a new itemRepository.
-func NewItemRepository(db *sql.DB) ItemRepository {
-	return &itemRepository{db: db}
+// NewItemRepository creates a new itemRepository. Its operations are timed into metrics unless it is nil.
+func NewItemRepository(db *sql.DB, metrics *Metrics) ItemRepository {
+	return &itemRepository{db: db, metrics: metrics}
 }
 
-func initDB(db *sql.DB) error {
-	const sqlFile = "db/items.sql"
-
-	if _, err := os.Stat(sqlFile); os.IsNotExist(err) {
-		slog.Error("SQL file not found", "file", sqlFile)
-		return errors.New("SQL file not found")
-	}
-
-	sqlBytes, err := os.ReadFile(sqlFile)
+// WithTx runs fn in a transaction on db. The transaction is committed when fn returns nil
+// and rolled back otherwise, and the error of fn is returned as is.
+//
+// SQLite takes the write lock of a transaction at its first write, and a transaction that
+// has read before it writes fails with SQLITE_BUSY instead of waiting when another one holds the lock.
+// Functions that may run concurrently should therefore write before they read.
+func WithTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		slog.Error("Failed to read SQL file", "file", sqlFile, "error", err)
 		return err
 	}
+	defer tx.Rollback()
 
-	_, err = db.Exec(string(sqlBytes))
-	if err != nil {
-		slog.Error("Failed to execute SQL file", "error", err)
+	if err := fn(tx); err != nil {
 		return err
 	}
-
-	slog.Info("Database initialized successfully")
-	return nil
+	return tx.Commit()
 }
 
-// Insert inserts an item into the repository.
+// Insert inserts an item into the repository along with its images.
+// The category is created when it does not exist yet, in the same transaction as the item.
 func (i *itemRepository) Insert(ctx context.Context, item *Item) error {
+	ctx, span := startSpan(ctx, "ItemRepository.Insert")
+	defer span.End()
+	defer i.metrics.observeQuery("insert", time.Now())
 
-	var categoryID int
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	names := itemImageNames(item)
+	if len(names) > maxItemImages {
+		return errTooManyItemImages
+	}
 
-	err := i.db.QueryRowContext(ctx, "SELECT id FROM categories WHERE name = ?", item.Category).Scan(&categoryID)
-	if err != nil {
-		res, err := i.db.ExecContext(ctx, "INSERT INTO categories (name) VALUES (?)", item.Category)
+	var images []ItemImage
+
+	var id int64
+	err := WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		categoryID, err := upsertCategory(ctx, tx, item.Category)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO items (name, category_id, image_name, seller_id, price, currency, description, condition, created_at, updated_at)
+			VALUES (?, ?, ?, NULLIF(?, 0), ?, ?, ?, ?, ?, ?)`,
+			item.Name, categoryID, names[0], item.SellerID, item.Price, item.Currency, item.Description, item.Condition, formatTimestamp(now), formatTimestamp(now))
 		if err != nil {
 			return err
 		}
-		lastID, _ := res.LastInsertId()
-		categoryID = int(lastID)
+		if id, err = res.LastInsertId(); err != nil {
+			return err
+		}
+		images, err = insertItemImages(ctx, tx, int(id), names)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	_, err = i.db.ExecContext(ctx, "INSERT INTO items (name,category_id, image_name) VALUES (?, ?, ?)", item.Name, categoryID, item.Image)
-	return err
+	item.ID = int(id)
+	item.Image, item.Images = names[0], images
+	item.Status = ItemStatusOnSale
+	item.CreatedAt, item.UpdatedAt = now, now
+	return nil
 }
 
-// StoreImage stores an image and returns an error if any.
-// This package doesn't have a related interface for simplicity.
-func StoreImage(fileName string, image []byte) error {
-	// STEP 4-4: add an implementation to store an image
-	if err := os.WriteFile(fileName, image, 0644); err != nil {
-		return err
+// upsertCategory returns the id of the category with the given name,
+// creating the category if it does not exist yet.
+//
+// The insert comes first so that the transaction holds the write lock before it reads;
+// when a concurrent transaction has just created the same category, the insert does nothing
+// and the category it committed is read instead.
+func upsertCategory(ctx context.Context, tx *sql.Tx, name string) (int, error) {
+	var categoryID int
+
+	err := tx.QueryRowContext(ctx, "INSERT INTO categories (name) VALUES (?) ON CONFLICT (name) DO NOTHING RETURNING id", name).
+		Scan(&categoryID)
+	if errors.Is(err, sql.ErrNoRows) {
+		err = tx.QueryRowContext(ctx, "SELECT id FROM categories WHERE name = ?", name).Scan(&categoryID)
+	}
+	if err != nil {
+		return 0, err
 	}
 
-	return nil
+	return categoryID, nil
 }
 
-func (i *itemRepository) GetAllItem(ctx context.Context) ([]Item, error) {
-	rows, err := i.db.QueryContext(ctx, `
-		SELECT items.id, items.name, categories.name AS category ,items.image_name
-		FROM items
-		JOIN categories ON items.category_id = categories.id
-		`)
+// GetAllItem returns a page of at most opts.Limit items ordered by opts.Sort,
+// along with the cursor of the next page, which is nil on the last page.
+func (i *itemRepository) GetAllItem(ctx context.Context, opts ListItemsOptions) ([]Item, *ItemCursor, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.GetAllItem")
+	defer span.End()
+	defer i.metrics.observeQuery("get_all", time.Now())
+
+	column, ok := itemSortColumns[opts.Sort]
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown sort key: %s", opts.Sort)
+	}
+	op, dir := ">", "ASC"
+	if opts.Order == SortDesc {
+		op, dir = "<", "DESC"
+	}
 
+	b := newSelect(itemColumns).From("items").Join("JOIN categories ON items.category_id = categories.id")
+	if opts.SellerID != 0 {
+		b.Where("items.seller_id = ?", opts.SellerID)
+	}
+	if opts.CategoryID != 0 {
+		b.Where("items.category_id IN ("+categorySubtree+")", opts.CategoryID)
+	}
+	if opts.After != nil {
+		if column == "items.id" {
+			b.Where("items.id "+op+" ?", opts.After.ID)
+		} else {
+			b.Where("("+column+" "+op+" ? OR ("+column+" = ? AND items.id "+op+" ?))", opts.After.Value, opts.After.Value, opts.After.ID)
+		}
+	}
+	if column == "items.id" {
+		b.OrderBy("items.id " + dir)
+	} else {
+		b.OrderBy(column+" "+dir, "items.id "+dir)
+	}
+	// fetch one extra row to know whether there is a next page
+	b.Limit(opts.Limit+1, 0)
+
+	query, args := b.Build()
+	rows, err := i.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	defer rows.Close()
 
 	var items []Item
 	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image); err != nil {
-			return nil, err
+		item, err := scanItem(rows)
+		if err != nil {
+			return nil, nil, err
 		}
 		items = append(items, item)
 	}
-	return items, nil
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *ItemCursor
+	if len(items) > opts.Limit {
+		items = items[:opts.Limit]
+		next = newItemCursor(items[len(items)-1], opts)
+	}
+	refs := make([]*Item, len(items))
+	for j := range items {
+		refs[j] = &items[j]
+	}
+	if err := i.attachItemImages(ctx, refs...); err != nil {
+		return nil, nil, err
+	}
+	return items, next, nil
 }
 
-func (i *itemRepository) GetItemById(ctx context.Context, itemId string) (Item, error) {
+// itemColumns is the column list scanItem expects, selected from items joined with categories.
+const itemColumns = `items.id, items.name, categories.name AS category, items.image_name, COALESCE(items.seller_id, 0),
+	items.price, items.currency, items.description, items.condition, items.status, items.created_at, items.updated_at`
+
+// scanItem scans a row selected with itemColumns.
+func scanItem(row interface{ Scan(dest ...any) error }) (Item, error) {
 	var item Item
-	err := i.db.QueryRowContext(ctx, `
-	SELECT items.id,items.name,categories.name AS category_name,items.image_name
+	var createdAt, updatedAt string
+	err := row.Scan(&item.ID, &item.Name, &item.Category, &item.Image, &item.SellerID,
+		&item.Price, &item.Currency, &item.Description, &item.Condition, &item.Status, &createdAt, &updatedAt)
+	if err != nil {
+		return Item{}, err
+	}
+
+	if item.CreatedAt, err = time.Parse(timestampLayout, createdAt); err != nil {
+		return Item{}, fmt.Errorf("invalid created_at of item %d: %w", item.ID, err)
+	}
+	if item.UpdatedAt, err = time.Parse(timestampLayout, updatedAt); err != nil {
+		return Item{}, fmt.Errorf("invalid updated_at of item %d: %w", item.ID, err)
+	}
+	return item, nil
+}
+
+// newItemCursor builds the cursor pointing at item for the given sort.
+func newItemCursor(item Item, opts ListItemsOptions) *ItemCursor {
+	c := &ItemCursor{Sort: opts.Sort, Order: opts.Order, ID: item.ID}
+	switch opts.Sort {
+	case ItemSortName:
+		c.Value = item.Name
+	case ItemSortCreatedAt:
+		c.Value = formatTimestamp(item.CreatedAt)
+	}
+	return c
+}
+
+func (i *itemRepository) GetItemById(ctx context.Context, itemId string) (Item, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.GetItemById")
+	defer span.End()
+	defer i.metrics.observeQuery("get_by_id", time.Now())
+
+	item, err := scanItem(i.db.QueryRowContext(ctx, `
+	SELECT `+itemColumns+`
 	FROM items
 	JOIN categories ON items.category_id = categories.id
 	WHERE items.id = ?
-	`, itemId).Scan(&item.ID, &item.Name, &item.Category, &item.Image)
+	`, itemId))
 
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return Item{}, errors.New("item not found")
+			return Item{}, errItemNotFound
 		}
 		return Item{}, err
 	}
+	if err := i.attachItemImages(ctx, &item); err != nil {
+		return Item{}, err
+	}
 	return item, nil
 }
 
-func (i *itemRepository) SearchItemsByKeyword(ctx context.Context, keyword string) ([]Item, error) {
-	rows, err := i.db.QueryContext(ctx, `
-		SELECT items.id, items.name, categories.name AS category_name, items.image_name
-		FROM items
-		JOIN categories ON items.category_id = categories.id
-		WHERE items.name LIKE ?`, "%"+keyword+"%")
+// ItemFilter narrows items down by their attributes. Zero fields match every item.
+type ItemFilter struct {
+	// Categories, Conditions and Statuses match items having any of the values.
+	Categories []string
+	Conditions []Condition
+	Statuses   []ItemStatus
+	SellerID   int
+	// MinPrice and MaxPrice are inclusive bounds in minor units, or nil when unbounded.
+	MinPrice *int64
+	MaxPrice *int64
+	// CreatedFrom is inclusive and CreatedTo exclusive. Zero times are unbounded.
+	CreatedFrom time.Time
+	CreatedTo   time.Time
+}
+
+// SearchItemsOptions selects a page of search results.
+type SearchItemsOptions struct {
+	// Query is nil to select items by Filter alone.
+	Query  SearchQuery
+	Filter ItemFilter
+	Limit  int
+	Offset int
+}
+
+// SearchCursor points at the next page of search results.
+// Results are ranked by relevance, which changes as items are added, so pages are addressed by offset.
+type SearchCursor struct {
+	Offset int `json:"o"`
+}
+
+// ItemSearchHit is an item matching a search along with the matched text.
+type ItemSearchHit struct {
+	Item
+	// Highlight is nil when the search could not use the full-text index.
+	Highlight *ItemHighlight `json:"highlight,omitempty"`
+}
+
+// ItemHighlight holds the matched text of an item wrapped in highlightStart and highlightEnd.
+// The text is not HTML-escaped, so clients must escape it around the markers.
+type ItemHighlight struct {
+	Name string `json:"name"`
+	// Description is an excerpt around the match, or empty when the description did not match.
+	Description string `json:"description,omitempty"`
+}
+
+// ItemFacets counts the items matching a search per value of an attribute.
+// Each facet ignores the filter on its own attribute, so that it tells how many items
+// selecting another value would add.
+type ItemFacets struct {
+	Categories []FacetCount `json:"category"`
+	Conditions []FacetCount `json:"condition"`
+}
+
+// FacetCount is the number of items having an attribute value.
+type FacetCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+const (
+	highlightStart = "<mark>"
+	highlightEnd   = "</mark>"
+	// snippetTokens is roughly how many characters of a description a snippet shows.
+	snippetTokens = 32
+)
+
+// minIndexedTermLength is the shortest term the trigram index can look up.
+// Shorter terms are matched with LIKE instead.
+const minIndexedTermLength = 3
+
+// SearchItemsByKeyword returns a page of items matching opts.Query and opts.Filter, the most relevant first,
+// along with the cursor of the next page, which is nil on the last page.
+//
+// Items are looked up in the items_fts index and ranked by BM25, weighting name over category over description.
+// When SQLite is built without FTS5, the index does not exist and every term is matched with LIKE instead.
+// Results that are not ranked, including those of a search without a query, are ordered newest first.
+func (i *itemRepository) SearchItemsByKeyword(ctx context.Context, opts SearchItemsOptions) ([]ItemSearchHit, *SearchCursor, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.SearchItemsByKeyword")
+	defer span.End()
+	defer i.metrics.observeQuery("search", time.Now())
+
+	b, matched, err := i.selectMatchingItems(ctx, opts.Query, opts.Filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	b.Column(itemColumns)
+	if matched {
+		b.Column("highlight(items_fts, 0, ?, ?)", highlightStart, highlightEnd).
+			Column("snippet(items_fts, 2, ?, ?, '…', ?)", highlightStart, highlightEnd, snippetTokens).
+			OrderBy("bm25(items_fts, 10.0, 5.0, 1.0)", "items.id")
+	} else {
+		b.Column("NULL").Column("NULL").OrderBy("items.created_at DESC", "items.id DESC")
+	}
+	// fetch one extra row to know whether there is a next page
+	b.Limit(opts.Limit+1, opts.Offset)
+
+	query, args := b.Build()
+	rows, err := i.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var hits []ItemSearchHit
+	for rows.Next() {
+		var name, description sql.NullString
+		item, err := scanItem(scannerFunc(func(dest ...any) error {
+			return rows.Scan(append(dest, &name, &description)...)
+		}))
+		if err != nil {
+			return nil, nil, err
+		}
+
+		hit := ItemSearchHit{Item: item}
+		if name.Valid {
+			hit.Highlight = &ItemHighlight{Name: name.String}
+			if strings.Contains(description.String, highlightStart) {
+				hit.Highlight.Description = description.String
+			}
+		}
+		hits = append(hits, hit)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	var next *SearchCursor
+	if len(hits) > opts.Limit {
+		hits = hits[:opts.Limit]
+		next = &SearchCursor{Offset: opts.Offset + opts.Limit}
+	}
+	refs := make([]*Item, len(hits))
+	for j := range hits {
+		refs[j] = &hits[j].Item
+	}
+	if err := i.attachItemImages(ctx, refs...); err != nil {
+		return nil, nil, err
+	}
+	return hits, next, nil
+}
+
+// CountItemFacets counts the items matching query and filter per category and per condition.
+func (i *itemRepository) CountItemFacets(ctx context.Context, query SearchQuery, filter ItemFilter) (ItemFacets, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.CountItemFacets")
+	defer span.End()
+	defer i.metrics.observeQuery("count_facets", time.Now())
+
+	var facets ItemFacets
+	var err error
+
+	f := filter
+	f.Categories = nil
+	if facets.Categories, err = i.countFacet(ctx, query, f, "categories.name"); err != nil {
+		return ItemFacets{}, err
+	}
+
+	f = filter
+	f.Conditions = nil
+	if facets.Conditions, err = i.countFacet(ctx, query, f, "items.condition"); err != nil {
+		return ItemFacets{}, err
+	}
+	return facets, nil
+}
+
+// countFacet counts the items matching query and filter per value of column, the most frequent first.
+func (i *itemRepository) countFacet(ctx context.Context, query SearchQuery, filter ItemFilter, column string) ([]FacetCount, error) {
+	b, _, err := i.selectMatchingItems(ctx, query, filter)
+	if err != nil {
+		return nil, err
+	}
+	// items listed before the attribute existed have an empty value, which is not worth a facet
+	b.Column(column).Column("COUNT(*)").
+		Where(column+" != ''").
+		GroupBy(column).
+		OrderBy("COUNT(*) DESC", column)
+
+	q, args := b.Build()
+	rows, err := i.db.QueryContext(ctx, q, args...)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	var items []Item
+	counts := []FacetCount{}
 	for rows.Next() {
-		var item Item
-		if err := rows.Scan(&item.ID, &item.Name, &item.Category, &item.Image); err != nil {
+		var c FacetCount
+		if err := rows.Scan(&c.Value, &c.Count); err != nil {
 			return nil, err
 		}
-		items = append(items, item)
+		counts = append(counts, c)
+	}
+	return counts, rows.Err()
+}
+
+// selectMatchingItems returns a SELECT without columns over the items matching query and filter,
+// and whether it looks them up in the items_fts index, which is then available to ranking functions.
+func (i *itemRepository) selectMatchingItems(ctx context.Context, query SearchQuery, filter ItemFilter) (*selectBuilder, bool, error) {
+	indexed, err := i.hasSearchIndex(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	b := newSelect()
+	var match []string
+	for _, clause := range query {
+		if indexed && indexableClause(clause) {
+			match = append(match, ftsClause(clause))
+			continue
+		}
+		cond, args := likeClause(clause)
+		b.Where(cond, args...)
+	}
+
+	if len(match) > 0 {
+		b.From("items_fts").
+			Join("JOIN items ON items.id = items_fts.rowid").
+			Where("items_fts MATCH ?", strings.Join(match, " AND "))
+	} else {
+		b.From("items")
+	}
+	b.Join("JOIN categories ON items.category_id = categories.id")
+
+	if len(filter.Categories) > 0 {
+		b.WhereIn("categories.name", anySlice(filter.Categories)...)
+	}
+	if len(filter.Conditions) > 0 {
+		b.WhereIn("items.condition", anySlice(filter.Conditions)...)
+	}
+	if len(filter.Statuses) > 0 {
+		b.WhereIn("items.status", anySlice(filter.Statuses)...)
+	}
+	if filter.SellerID != 0 {
+		b.Where("items.seller_id = ?", filter.SellerID)
+	}
+	if filter.MinPrice != nil {
+		b.Where("items.price >= ?", *filter.MinPrice)
+	}
+	if filter.MaxPrice != nil {
+		b.Where("items.price <= ?", *filter.MaxPrice)
+	}
+	if !filter.CreatedFrom.IsZero() {
+		b.Where("items.created_at >= ?", formatTimestamp(filter.CreatedFrom))
+	}
+	if !filter.CreatedTo.IsZero() {
+		b.Where("items.created_at < ?", formatTimestamp(filter.CreatedTo))
+	}
+	return b, len(match) > 0, nil
+}
+
+// hasSearchIndex reports whether the items_fts index exists. It is created by a migration
+// that is skipped when SQLite is built without FTS5.
+func (i *itemRepository) hasSearchIndex(ctx context.Context) (bool, error) {
+	var n int
+	err := i.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'items_fts'").Scan(&n)
+	return n > 0, err
+}
+
+// indexableClause reports whether every term of clause is long enough to be looked up in the trigram index.
+func indexableClause(clause []string) bool {
+	for _, term := range clause {
+		if utf8.RuneCountInString(term) < minIndexedTermLength {
+			return false
+		}
+	}
+	return true
+}
+
+// ftsClause builds the FTS5 query matching any term of clause.
+// Terms are quoted as FTS5 strings, so operators in them are matched literally.
+func ftsClause(clause []string) string {
+	quoted := make([]string, len(clause))
+	for i, term := range clause {
+		quoted[i] = `"` + strings.ReplaceAll(term, `"`, `""`) + `"`
+	}
+	return "(" + strings.Join(quoted, " OR ") + ")"
+}
+
+// likeClause builds the condition matching items whose searchable text contains any term of clause.
+func likeClause(clause []string) (string, []any) {
+	var ors []string
+	var args []any
+	for _, term := range clause {
+		pattern := "%" + likeEscaper.Replace(term) + "%"
+		ors = append(ors, `items.name LIKE ? ESCAPE '\' OR categories.name LIKE ? ESCAPE '\' OR items.description LIKE ? ESCAPE '\'`)
+		args = append(args, pattern, pattern, pattern)
+	}
+	return "(" + strings.Join(ors, " OR ") + ")", args
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// scannerFunc adapts a function to the interface scanItem reads rows from.
+type scannerFunc func(dest ...any) error
+
+func (f scannerFunc) Scan(dest ...any) error {
+	return f(dest...)
+}
+
+// Update writes the fields set in update to the item with the given id, bumps its updated_at
+// and returns the item as updated.
+func (i *itemRepository) Update(ctx context.Context, itemId string, update ItemUpdate) (Item, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.Update")
+	defer span.End()
+	defer i.metrics.observeQuery("update", time.Now())
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	var names []string
+	// a NULL image name keeps the main image
+	var imageName sql.NullString
+	if update.Images != nil {
+		names = itemImageNames(&Item{Images: update.Images})
+		if len(names) > maxItemImages {
+			return Item{}, errTooManyItemImages
+		}
+		imageName = sql.NullString{String: names[0], Valid: true}
+	}
+
+	var item Item
+	err := WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		// updated_at is bumped first, by a statement that fires no trigger of the search index:
+		// preparing those triggers reads the index, and the transaction would read before it writes.
+		res, err := tx.ExecContext(ctx, "UPDATE items SET updated_at = ? WHERE id = ?", formatTimestamp(now), itemId)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res, errItemNotFound); err != nil {
+			return err
+		}
+
+		// a NULL category id keeps the category
+		var categoryID sql.NullInt64
+		if update.Category != nil {
+			id, err := upsertCategory(ctx, tx, *update.Category)
+			if err != nil {
+				return err
+			}
+			categoryID = sql.NullInt64{Int64: int64(id), Valid: true}
+		}
+
+		// every column is written only when it is part of the update, so a partial update of some fields
+		// does not write back the stale values of the others
+		_, err = tx.ExecContext(ctx, `
+			UPDATE items
+			SET name = COALESCE(?, name), category_id = COALESCE(?, category_id), image_name = COALESCE(?, image_name),
+				price = COALESCE(?, price), currency = COALESCE(?, currency), description = COALESCE(?, description),
+				condition = COALESCE(?, condition)
+			WHERE id = ?`,
+			update.Name, categoryID, imageName, update.Price, update.Currency, update.Description, update.Condition, itemId)
+		if err != nil {
+			return err
+		}
+
+		item, err = scanItem(tx.QueryRowContext(ctx, `
+			SELECT `+itemColumns+`
+			FROM items
+			JOIN categories ON items.category_id = categories.id
+			WHERE items.id = ?`, itemId))
+		if err != nil {
+			return err
+		}
+		if update.Images == nil {
+			byItem, err := queryItemImages(ctx, tx, item.ID)
+			item.Images = byItem[item.ID]
+			return err
+		}
+		item.Images, err = replaceItemImages(ctx, tx, item.ID, names)
+		return err
+	})
+	if err != nil {
+		return Item{}, err
+	}
+	return item, nil
+}
+
+// Delete deletes the item with the given id along with its images.
+func (i *itemRepository) Delete(ctx context.Context, itemId string) error {
+	ctx, span := startSpan(ctx, "ItemRepository.Delete")
+	defer span.End()
+	defer i.metrics.observeQuery("delete", time.Now())
+
+	return WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		// the item is deleted before anything is read so that the transaction takes the write lock first;
+		// a concurrent purchase then either comes first and keeps the item, or finds it gone.
+		// Its images go first, since preparing the trigger of the search index on items reads the index;
+		// they are rolled back when the item is not deleted.
+		if _, err := tx.ExecContext(ctx, "DELETE FROM item_images WHERE item_id = ?", itemId); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, `
+			DELETE FROM items
+			WHERE id = ? AND status = ? AND NOT EXISTS (SELECT 1 FROM orders WHERE orders.item_id = items.id)`,
+			itemId, ItemStatusOnSale)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return itemNotDeletable(ctx, tx, itemId)
+		}
+		return nil
+	})
+}
+
+// itemNotDeletable tells why Delete deleted nothing.
+func itemNotDeletable(ctx context.Context, tx *sql.Tx, itemId string) error {
+	var status ItemStatus
+	var ordered bool
+	err := tx.QueryRowContext(ctx, "SELECT status, EXISTS (SELECT 1 FROM orders WHERE item_id = items.id) FROM items WHERE id = ?", itemId).
+		Scan(&status, &ordered)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return errItemNotFound
+	case err != nil:
+		return err
+	case status != ItemStatusOnSale:
+		return errItemNotOnSale
+	case ordered:
+		return errItemHasOrders
+	}
+	return fmt.Errorf("item %s was not deleted", itemId)
+}
+
+// checkAffected returns notFound when a statement touched no row.
+func checkAffected(res sql.Result, notFound error) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return notFound
+	}
+	return nil
+}
+
+// selectBuilder assembles a SELECT statement clause by clause.
+// Each clause keeps its own arguments, so they end up in the order of their placeholders
+// whatever order the clauses are added in.
+type selectBuilder struct {
+	columns    []string
+	columnArgs []any
+	from       string
+	joins      []string
+	where      []string
+	whereArgs  []any
+	groupBy    []string
+	orderBy    []string
+	limit      int
+	offset     int
+}
+
+// newSelect starts a SELECT of the given columns.
+func newSelect(columns ...string) *selectBuilder {
+	return &selectBuilder{columns: columns}
+}
+
+// Column adds a column expression with the arguments of its placeholders.
+func (b *selectBuilder) Column(column string, args ...any) *selectBuilder {
+	b.columns = append(b.columns, column)
+	b.columnArgs = append(b.columnArgs, args...)
+	return b
+}
+
+// From sets the table to select from.
+func (b *selectBuilder) From(table string) *selectBuilder {
+	b.from = table
+	return b
+}
+
+// Join adds a JOIN clause, e.g. "JOIN categories ON items.category_id = categories.id".
+func (b *selectBuilder) Join(join string) *selectBuilder {
+	b.joins = append(b.joins, join)
+	return b
+}
+
+// Where adds a condition with the arguments of its placeholders. Conditions are combined with AND.
+func (b *selectBuilder) Where(cond string, args ...any) *selectBuilder {
+	b.where = append(b.where, cond)
+	b.whereArgs = append(b.whereArgs, args...)
+	return b
+}
+
+// WhereIn adds a condition matching rows whose column equals any of values, which must not be empty.
+func (b *selectBuilder) WhereIn(column string, values ...any) *selectBuilder {
+	return b.Where(column+" IN ("+strings.Repeat("?, ", len(values)-1)+"?)", values...)
+}
+
+// GroupBy sets the grouping columns.
+func (b *selectBuilder) GroupBy(columns ...string) *selectBuilder {
+	b.groupBy = columns
+	return b
+}
+
+// OrderBy sets the ordering terms, e.g. "items.id DESC".
+func (b *selectBuilder) OrderBy(terms ...string) *selectBuilder {
+	b.orderBy = terms
+	return b
+}
+
+// Limit sets the number of rows to return after skipping offset rows. A limit of 0 returns every row.
+func (b *selectBuilder) Limit(limit, offset int) *selectBuilder {
+	b.limit, b.offset = limit, offset
+	return b
+}
+
+// Build returns the statement and its arguments.
+func (b *selectBuilder) Build() (string, []any) {
+	var sb strings.Builder
+	args := append([]any{}, b.columnArgs...)
+
+	sb.WriteString("SELECT " + strings.Join(b.columns, ", "))
+	sb.WriteString("\nFROM " + b.from)
+	for _, j := range b.joins {
+		sb.WriteString("\n" + j)
+	}
+	if len(b.where) > 0 {
+		sb.WriteString("\nWHERE " + strings.Join(b.where, "\nAND "))
+		args = append(args, b.whereArgs...)
+	}
+	if len(b.groupBy) > 0 {
+		sb.WriteString("\nGROUP BY " + strings.Join(b.groupBy, ", "))
+	}
+	if len(b.orderBy) > 0 {
+		sb.WriteString("\nORDER BY " + strings.Join(b.orderBy, ", "))
+	}
+	if b.limit > 0 {
+		sb.WriteString("\nLIMIT ? OFFSET ?")
+		args = append(args, b.limit, b.offset)
+	}
+	return sb.String(), args
+}
+
+// anySlice converts values to the []any taken by query arguments.
+func anySlice[T any](values []T) []any {
+	args := make([]any, len(values))
+	for i, v := range values {
+		args[i] = v
 	}
-	return items, nil
+	return args
 }
@@ -0,0 +1,248 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	errCategoryNotFound  error = newError(ErrNotFound, "category not found")
+	errCategoryDuplicate error = fieldError(ErrConflict, "name", "category already exists")
+	errCategoryInUse     error = newError(ErrConflict, "category still has items or subcategories")
+	errParentNotFound    error = fieldError(ErrValidation, "parent_id", "parent category does not exist")
+	errCategoryCycle     error = fieldError(ErrValidation, "parent_id", "a category cannot be nested under itself or its subcategories")
+	errMergeIntoSubtree  error = fieldError(ErrValidation, "into", "a category cannot be merged into itself or its subcategories")
+)
+
+type Category struct {
+	ID   int    `db:"id" json:"id"`
+	Name string `db:"name" json:"name"`
+	// ParentID is the id of the parent category, or 0 for a top-level category.
+	ParentID int `db:"parent_id" json:"parent_id,omitempty"`
+}
+
+// CategoryRepository is an interface to manage the category tree.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type CategoryRepository interface {
+	List(ctx context.Context) ([]Category, error)
+	Get(ctx context.Context, id int) (Category, error)
+	GetByName(ctx context.Context, name string) (Category, error)
+	Insert(ctx context.Context, category *Category) error
+	Update(ctx context.Context, category *Category) error
+	// Delete deletes a category that has neither items nor subcategories.
+	Delete(ctx context.Context, id int) error
+	// Merge moves the items and subcategories of category from into category into, then deletes from.
+	Merge(ctx context.Context, from, into int) error
+}
+
+// categorySubtree selects the id of the category bound to its placeholder and of all its descendants.
+const categorySubtree = `
+	WITH RECURSIVE subtree(id) AS (
+		SELECT ?
+		UNION
+		SELECT categories.id FROM categories JOIN subtree ON categories.parent_id = subtree.id
+	)
+	SELECT id FROM subtree`
+
+// categoryRepository is an implementation of CategoryRepository
+type categoryRepository struct {
+	db *sql.DB
+}
+
+// NewCategoryRepository creates a new categoryRepository.
+func NewCategoryRepository(db *sql.DB) CategoryRepository {
+	return &categoryRepository{db: db}
+}
+
+// List returns every category ordered by id. Clients build the tree from the parent ids.
+func (c *categoryRepository) List(ctx context.Context) ([]Category, error) {
+	rows, err := c.db.QueryContext(ctx, "SELECT id, name, COALESCE(parent_id, 0) FROM categories ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	categories := []Category{}
+	for rows.Next() {
+		var category Category
+		if err := rows.Scan(&category.ID, &category.Name, &category.ParentID); err != nil {
+			return nil, err
+		}
+		categories = append(categories, category)
+	}
+	return categories, rows.Err()
+}
+
+func (c *categoryRepository) Get(ctx context.Context, id int) (Category, error) {
+	return c.getCategory(ctx, "id = ?", id)
+}
+
+func (c *categoryRepository) GetByName(ctx context.Context, name string) (Category, error) {
+	return c.getCategory(ctx, "name = ?", name)
+}
+
+// getCategory returns the category matching cond, or errCategoryNotFound.
+func (c *categoryRepository) getCategory(ctx context.Context, cond string, arg any) (Category, error) {
+	var category Category
+	err := c.db.QueryRowContext(ctx, "SELECT id, name, COALESCE(parent_id, 0) FROM categories WHERE "+cond, arg).
+		Scan(&category.ID, &category.Name, &category.ParentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Category{}, errCategoryNotFound
+	}
+	return category, err
+}
+
+// Insert inserts a category and sets the id it was given.
+// It returns errCategoryDuplicate when the name is taken and errParentNotFound when the parent does not exist.
+func (c *categoryRepository) Insert(ctx context.Context, category *Category) error {
+	return WithTx(ctx, c.db, func(tx *sql.Tx) error {
+		// the category is written before the parent is checked so that the transaction takes the write lock first;
+		// a failed check rolls it back
+		res, err := tx.ExecContext(ctx, "INSERT INTO categories (name, parent_id) VALUES (?, NULLIF(?, 0))", category.Name, category.ParentID)
+		if err != nil {
+			return categoryWriteError(err)
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		// a new category has no subcategories to be nested under, so only the existence of the parent is checked
+		if err := checkParent(ctx, tx, &Category{ParentID: category.ParentID}); err != nil {
+			return err
+		}
+		category.ID = int(id)
+		return nil
+	})
+}
+
+// Update renames and moves the category identified by category.ID.
+// Besides the errors of Insert, it returns errCategoryCycle when the new parent is inside the category.
+func (c *categoryRepository) Update(ctx context.Context, category *Category) error {
+	return WithTx(ctx, c.db, func(tx *sql.Tx) error {
+		// like in Insert, the parent is checked after the write; moving the category does not change its subtree,
+		// so a parent inside it is still found there.
+		// The parent is written before the name, whose trigger updates the search index: preparing it reads the
+		// index, which would make the transaction read before it writes.
+		res, err := tx.ExecContext(ctx, "UPDATE categories SET parent_id = NULLIF(?, 0) WHERE id = ?", category.ParentID, category.ID)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res, errCategoryNotFound); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE categories SET name = ? WHERE id = ?", category.Name, category.ID); err != nil {
+			return categoryWriteError(err)
+		}
+		return checkParent(ctx, tx, category)
+	})
+}
+
+func (c *categoryRepository) Delete(ctx context.Context, id int) error {
+	return WithTx(ctx, c.db, func(tx *sql.Tx) error {
+		// the category is deleted before its uses are looked up so that the transaction takes the write lock first
+		res, err := tx.ExecContext(ctx, "DELETE FROM categories WHERE id = ?", id)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res, errCategoryNotFound); err != nil {
+			return err
+		}
+
+		var used bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT EXISTS (SELECT 1 FROM items WHERE category_id = ?) OR EXISTS (SELECT 1 FROM categories WHERE parent_id = ?)`,
+			id, id).Scan(&used)
+		if err != nil {
+			return err
+		}
+		if used {
+			return errCategoryInUse
+		}
+		return nil
+	})
+}
+
+// Merge moves the items and subcategories of category from into category into, then deletes from.
+// It returns errMergeIntoSubtree when into is from or one of its descendants.
+func (c *categoryRepository) Merge(ctx context.Context, from, into int) error {
+	return WithTx(ctx, c.db, func(tx *sql.Tx) error {
+		// a write that changes nothing takes the write lock before anything is read
+		res, err := tx.ExecContext(ctx, "UPDATE categories SET id = id WHERE id = ?", from)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res, errCategoryNotFound); err != nil {
+			return err
+		}
+		var exists bool
+		if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM categories WHERE id = ?)", into).Scan(&exists); err != nil {
+			return err
+		}
+		if !exists {
+			return errCategoryNotFound
+		}
+		if inside, err := inCategorySubtree(ctx, tx, into, from); err != nil {
+			return err
+		} else if inside {
+			return errMergeIntoSubtree
+		}
+
+		for _, stmt := range []string{
+			"UPDATE items SET category_id = ? WHERE category_id = ?",
+			"UPDATE categories SET parent_id = ? WHERE parent_id = ?",
+		} {
+			if _, err := tx.ExecContext(ctx, stmt, into, from); err != nil {
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM categories WHERE id = ?", from)
+		return err
+	})
+}
+
+// checkParent checks that the parent of category exists and is not category itself or one of its descendants.
+func checkParent(ctx context.Context, tx *sql.Tx, category *Category) error {
+	if category.ParentID == 0 {
+		return nil
+	}
+
+	var exists bool
+	if err := tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM categories WHERE id = ?)", category.ParentID).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return errParentNotFound
+	}
+
+	if category.ID == 0 {
+		return nil
+	}
+	inside, err := inCategorySubtree(ctx, tx, category.ParentID, category.ID)
+	if err != nil {
+		return err
+	}
+	if inside {
+		return errCategoryCycle
+	}
+	return nil
+}
+
+// inCategorySubtree reports whether category id is root or one of its descendants.
+func inCategorySubtree(ctx context.Context, tx *sql.Tx, id, root int) (bool, error) {
+	var inside bool
+	err := tx.QueryRowContext(ctx, "SELECT ? IN ("+categorySubtree+")", id, root).Scan(&inside)
+	return inside, err
+}
+
+// categoryWriteError maps the error of writing a category to errCategoryDuplicate when the name is taken.
+func categoryWriteError(err error) error {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return errCategoryDuplicate
+	}
+	return err
+}
@@ -0,0 +1,287 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxItemImages is the number of images an item can have at most.
+const maxItemImages = 10
+
+var (
+	errItemImageNotFound  error = newError(ErrNotFound, "item image not found")
+	errItemImageDuplicate error = fieldError(ErrConflict, "image", "the item already has this image")
+	errTooManyItemImages  error = fieldError(ErrValidation, "image", fmt.Sprintf("an item can have at most %d images", maxItemImages))
+	errLastItemImage      error = newError(ErrConflict, "an item must keep at least one image")
+	errItemImageOrder     error = fieldError(ErrValidation, "image_ids", "image_ids must list every image of the item exactly once")
+)
+
+// ItemImage is one of the images of an item.
+type ItemImage struct {
+	ID   int    `db:"id" json:"id"`
+	Name string `db:"image_name" json:"image_name"`
+	// URL is where clients download the image from. Handlers set it from the ImageStore; it is not stored.
+	URL string `db:"-" json:"url,omitempty"`
+}
+
+// itemImageNames returns the names of the images of item in order.
+// Items built without Images have their main image only.
+func itemImageNames(item *Item) []string {
+	if len(item.Images) == 0 {
+		return []string{item.Image}
+	}
+	names := make([]string, len(item.Images))
+	for i, image := range item.Images {
+		names[i] = image.Name
+	}
+	return names
+}
+
+// AddImages appends images to the item and returns all its images in order.
+// It fails with errTooManyItemImages when the item would have more than maxItemImages images.
+func (i *itemRepository) AddImages(ctx context.Context, itemId string, names []string) ([]ItemImage, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.AddImages")
+	defer span.End()
+	defer i.metrics.observeQuery("add_images", time.Now())
+
+	var images []ItemImage
+	err := WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		id, err := parseItemID(itemId)
+		if err != nil {
+			return err
+		}
+		if _, err := insertItemImages(ctx, tx, id, names); err != nil {
+			return err
+		}
+		images, err = syncItemImages(ctx, tx, id)
+		return err
+	})
+	return images, err
+}
+
+// DeleteImage removes an image from the item and returns the remaining images in order.
+// The last image of an item cannot be removed.
+func (i *itemRepository) DeleteImage(ctx context.Context, itemId string, imageID int) ([]ItemImage, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.DeleteImage")
+	defer span.End()
+	defer i.metrics.observeQuery("delete_image", time.Now())
+
+	var images []ItemImage
+	err := WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		id, err := parseItemID(itemId)
+		if err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, "DELETE FROM item_images WHERE id = ? AND item_id = ?", imageID, id)
+		if err != nil {
+			return err
+		}
+		if err := checkAffected(res, errItemImageNotFound); err != nil {
+			return err
+		}
+		images, err = syncItemImages(ctx, tx, id)
+		return err
+	})
+	return images, err
+}
+
+// ReorderImages puts the images of the item in the order of imageIDs, which must list each of them once,
+// and returns them in their new order.
+func (i *itemRepository) ReorderImages(ctx context.Context, itemId string, imageIDs []int) ([]ItemImage, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.ReorderImages")
+	defer span.End()
+	defer i.metrics.observeQuery("reorder_images", time.Now())
+
+	var images []ItemImage
+	err := WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		id, err := parseItemID(itemId)
+		if err != nil {
+			return err
+		}
+		for position, imageID := range imageIDs {
+			res, err := tx.ExecContext(ctx, "UPDATE item_images SET position = ? WHERE id = ? AND item_id = ?", position, imageID, id)
+			if err != nil {
+				return err
+			}
+			if err := checkAffected(res, errItemImageOrder); err != nil {
+				return err
+			}
+		}
+		if images, err = syncItemImages(ctx, tx, id); err != nil {
+			return err
+		}
+		// an id listed twice leaves another image out
+		if len(images) != len(imageIDs) {
+			return errItemImageOrder
+		}
+		return nil
+	})
+	return images, err
+}
+
+// parseItemID converts an item id validated by the handlers to the integer stored in item_images.
+func parseItemID(itemId string) (int, error) {
+	id, err := strconv.Atoi(itemId)
+	if err != nil {
+		return 0, errItemNotFound
+	}
+	return id, nil
+}
+
+// insertItemImages appends the images named names to the images of the item and returns them with their ids.
+func insertItemImages(ctx context.Context, tx *sql.Tx, itemID int, names []string) ([]ItemImage, error) {
+	images := make([]ItemImage, len(names))
+	for i, name := range names {
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO item_images (item_id, position, image_name)
+			VALUES (?, (SELECT COALESCE(MAX(position), -1) + 1 FROM item_images WHERE item_id = ?), ?)
+			RETURNING id`,
+			itemID, itemID, name).Scan(&images[i].ID)
+		if err != nil {
+			var sqliteErr sqlite3.Error
+			if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+				return nil, errItemImageDuplicate
+			}
+			return nil, err
+		}
+		images[i].Name = name
+	}
+	return images, nil
+}
+
+// syncItemImages checks the number of images of the item after they changed, points items.image_name
+// at the first one and bumps updated_at. It returns the images in order.
+func syncItemImages(ctx context.Context, tx *sql.Tx, itemID int) ([]ItemImage, error) {
+	byItem, err := queryItemImages(ctx, tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	images := byItem[itemID]
+	switch {
+	case len(images) == 0:
+		return nil, errLastItemImage
+	case len(images) > maxItemImages:
+		return nil, errTooManyItemImages
+	}
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	res, err := tx.ExecContext(ctx, "UPDATE items SET image_name = ?, updated_at = ? WHERE id = ?", images[0].Name, formatTimestamp(now), itemID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkAffected(res, errItemNotFound); err != nil {
+		return nil, err
+	}
+	return images, nil
+}
+
+// replaceItemImages replaces the images of the item with the images named names,
+// unless they are the images the item already has.
+// It reads before it writes, so it must run after the transaction has written the item.
+func replaceItemImages(ctx context.Context, tx *sql.Tx, itemID int, names []string) ([]ItemImage, error) {
+	byItem, err := queryItemImages(ctx, tx, itemID)
+	if err != nil {
+		return nil, err
+	}
+	current := byItem[itemID]
+	if len(current) == len(names) {
+		same := true
+		for i, image := range current {
+			same = same && image.Name == names[i]
+		}
+		if same {
+			return current, nil
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM item_images WHERE item_id = ?", itemID); err != nil {
+		return nil, err
+	}
+	return insertItemImages(ctx, tx, itemID, names)
+}
+
+// queryItemImages returns the images of the given items in order, by item id.
+func queryItemImages(ctx context.Context, q interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}, itemIDs ...int) (map[int][]ItemImage, error) {
+	byItem := map[int][]ItemImage{}
+	if len(itemIDs) == 0 {
+		return byItem, nil
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT item_id, id, image_name FROM item_images
+		WHERE item_id IN (`+strings.Repeat("?, ", len(itemIDs)-1)+`?)
+		ORDER BY item_id, position, id`,
+		anySlice(itemIDs)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var itemID int
+		var image ItemImage
+		if err := rows.Scan(&itemID, &image.ID, &image.Name); err != nil {
+			return nil, err
+		}
+		byItem[itemID] = append(byItem[itemID], image)
+	}
+	return byItem, rows.Err()
+}
+
+// attachItemImages sets the Images of each item from item_images.
+func (i *itemRepository) attachItemImages(ctx context.Context, items ...*Item) error {
+	ids := make([]int, len(items))
+	for j, item := range items {
+		ids[j] = item.ID
+	}
+	byItem, err := queryItemImages(ctx, i.db, ids...)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		item.Images = byItem[item.ID]
+		if item.Images == nil {
+			item.Images = []ItemImage{}
+		}
+	}
+	return nil
+}
+
+// referencedImageNames returns the names of the images some item refers to,
+// through item_images or through items.image_name.
+func referencedImageNames(ctx context.Context, db *sql.DB) (map[string]bool, error) {
+	rows, err := db.QueryContext(ctx, "SELECT image_name FROM item_images UNION SELECT image_name FROM items")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names[name] = true
+	}
+	return names, rows.Err()
+}
+
+// imageHashReferenced reports whether an item refers to the image whose key starts with hash.
+func imageHashReferenced(ctx context.Context, db *sql.DB, hash string) (bool, error) {
+	var referenced bool
+	err := db.QueryRowContext(ctx, `
+		SELECT EXISTS (SELECT 1 FROM item_images WHERE image_name LIKE ?1)
+			OR EXISTS (SELECT 1 FROM items WHERE image_name LIKE ?1)`,
+		hash+"%").Scan(&referenced)
+	return referenced, err
+}
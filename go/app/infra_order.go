@@ -0,0 +1,174 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Order is the purchase of an item by a buyer.
+type Order struct {
+	ID      int `db:"id" json:"id"`
+	ItemID  int `db:"item_id" json:"item_id"`
+	BuyerID int `db:"buyer_id" json:"buyer_id"`
+	// Price and Currency are what the item cost when it was purchased.
+	Price     int64     `db:"price" json:"price"`
+	Currency  string    `db:"currency" json:"currency"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+// Purchase moves the item from on sale to trading and records the order of buyerID in one transaction.
+func (i *itemRepository) Purchase(ctx context.Context, itemId string, buyerID int) (Order, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.Purchase")
+	defer span.End()
+	defer i.metrics.observeQuery("purchase", time.Now())
+
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	order := Order{BuyerID: buyerID, CreatedAt: now}
+
+	err := WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		// the status is changed before anything is read so that the transaction takes the write lock first;
+		// a concurrent purchase then waits for this one and sees the item as trading.
+		if err := transitionItemStatus(ctx, tx, itemId, ItemStatusOnSale, ItemStatusTrading, now); err != nil {
+			return err
+		}
+
+		err := tx.QueryRowContext(ctx, "SELECT id, price, currency FROM items WHERE id = ?", itemId).
+			Scan(&order.ItemID, &order.Price, &order.Currency)
+		if err != nil {
+			return err
+		}
+
+		res, err := tx.ExecContext(ctx, "INSERT INTO orders (item_id, buyer_id, price, currency, created_at) VALUES (?, ?, ?, ?, ?)",
+			order.ItemID, order.BuyerID, order.Price, order.Currency, formatTimestamp(now))
+		if err != nil {
+			return err
+		}
+		id, err := res.LastInsertId()
+		if err != nil {
+			return err
+		}
+		order.ID = int(id)
+		return nil
+	})
+	if err != nil {
+		return Order{}, err
+	}
+	return order, nil
+}
+
+// GetTradeOrder returns the latest order of the item while it is trading.
+func (i *itemRepository) GetTradeOrder(ctx context.Context, itemId string) (Order, error) {
+	ctx, span := startSpan(ctx, "ItemRepository.GetTradeOrder")
+	defer span.End()
+	defer i.metrics.observeQuery("get_trade_order", time.Now())
+
+	var order Order
+	var createdAt string
+	err := i.db.QueryRowContext(ctx, `
+		SELECT orders.id, orders.item_id, orders.buyer_id, orders.price, orders.currency, orders.created_at
+		FROM orders JOIN items ON items.id = orders.item_id
+		WHERE orders.item_id = ? AND items.status = ?
+		ORDER BY orders.id DESC
+		LIMIT 1`,
+		itemId, ItemStatusTrading).
+		Scan(&order.ID, &order.ItemID, &order.BuyerID, &order.Price, &order.Currency, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		// tell a missing item from one that is not trading
+		var status ItemStatus
+		err := i.db.QueryRowContext(ctx, "SELECT status FROM items WHERE id = ?", itemId).Scan(&status)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Order{}, errItemNotFound
+		}
+		if err != nil {
+			return Order{}, err
+		}
+		return Order{}, errItemNotTraded
+	}
+	if err != nil {
+		return Order{}, err
+	}
+	if order.CreatedAt, err = time.Parse(timestampLayout, createdAt); err != nil {
+		return Order{}, fmt.Errorf("invalid created_at of order %d: %w", order.ID, err)
+	}
+	return order, nil
+}
+
+// CompleteTrade ends the trade of order with the item sold out.
+func (i *itemRepository) CompleteTrade(ctx context.Context, order Order) error {
+	ctx, span := startSpan(ctx, "ItemRepository.CompleteTrade")
+	defer span.End()
+	defer i.metrics.observeQuery("complete_trade", time.Now())
+
+	return i.endTrade(ctx, order, ItemStatusSoldOut)
+}
+
+// CancelTrade ends the trade of order with the item back on sale. The order is kept, as a record of the trade.
+func (i *itemRepository) CancelTrade(ctx context.Context, order Order) error {
+	ctx, span := startSpan(ctx, "ItemRepository.CancelTrade")
+	defer span.End()
+	defer i.metrics.observeQuery("cancel_trade", time.Now())
+
+	return i.endTrade(ctx, order, ItemStatusOnSale)
+}
+
+// endTrade moves the item of order from trading to status to, as long as order is still the order of its trade.
+func (i *itemRepository) endTrade(ctx context.Context, order Order, to ItemStatus) error {
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	itemId := strconv.Itoa(order.ItemID)
+
+	return WithTx(ctx, i.db, func(tx *sql.Tx) error {
+		// like in Purchase, the status is changed before anything is read so that the transaction takes the write lock first
+		if err := transitionItemStatus(ctx, tx, itemId, ItemStatusTrading, to, now); err != nil {
+			return err
+		}
+
+		// the trade may have been cancelled and the item purchased again since order was read
+		var latest int
+		if err := tx.QueryRowContext(ctx, "SELECT MAX(id) FROM orders WHERE item_id = ?", itemId).Scan(&latest); err != nil {
+			return err
+		}
+		if latest != order.ID {
+			return errTradeChanged
+		}
+		return nil
+	})
+}
+
+// transitionItemStatus moves the item from status from to status to.
+// The update only applies while the item still has status from, so concurrent transitions cannot both succeed.
+func transitionItemStatus(ctx context.Context, tx *sql.Tx, itemId string, from, to ItemStatus, now time.Time) error {
+	if !from.CanTransitionTo(to) {
+		return fmt.Errorf("invalid item status transition from %s to %s", from, to)
+	}
+
+	res, err := tx.ExecContext(ctx, "UPDATE items SET status = ?, updated_at = ? WHERE id = ? AND status = ?",
+		to, formatTimestamp(now), itemId, from)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 1 {
+		return nil
+	}
+
+	// nothing was updated: tell a missing item from one in another status
+	var status ItemStatus
+	err = tx.QueryRowContext(ctx, "SELECT status FROM items WHERE id = ?", itemId).Scan(&status)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errItemNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if from == ItemStatusOnSale {
+		return errItemNotOnSale
+	}
+	return newError(ErrConflict, fmt.Sprintf("item is %s, not %s", status, from))
+}
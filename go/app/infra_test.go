@@ -0,0 +1,1335 @@
+package app
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestItemRepositoryGetAllItemPagination(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	ctx := t.Context()
+
+	// names are inserted out of order so that sorting by name differs from sorting by id
+	for _, name := range []string{"d", "b", "e", "a", "c"} {
+		if err := repo.Insert(ctx, &Item{Name: name, Category: "test", Image: "test.jpg"}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	seller := &User{Name: "seller", Email: "seller@example.com", PasswordHash: "hash", Role: RoleSeller}
+	if err := (&userRepository{db: db}).Insert(ctx, seller); err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	for _, name := range []string{"z", "y"} {
+		if err := repo.Insert(ctx, &Item{Name: name, Category: "test", Image: "test.jpg", SellerID: seller.ID}); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	cases := map[string]struct {
+		sellerID int
+		sort     ItemSortKey
+		order    SortOrder
+		// insert is inserted after the first page has been read.
+		insert string
+		want   []string
+	}{
+		"ok: by id ascending": {
+			sort:  ItemSortID,
+			order: SortAsc,
+			want:  []string{"d", "b", "e", "a", "c", "z", "y"},
+		},
+		"ok: filtered by seller": {
+			sellerID: seller.ID,
+			sort:     ItemSortName,
+			order:    SortAsc,
+			want:     []string{"y", "z"},
+		},
+		"ok: by name descending": {
+			sort:  ItemSortName,
+			order: SortDesc,
+			want:  []string{"z", "y", "e", "d", "c", "b", "a"},
+		},
+		"ok: rows inserted before the cursor do not shift pages": {
+			sort:   ItemSortName,
+			order:  SortAsc,
+			insert: "aa",
+			want:   []string{"a", "b", "c", "d", "e", "y", "z"},
+		},
+		"ok: rows inserted after the cursor show up on later pages": {
+			sort:   ItemSortCreatedAt,
+			order:  SortAsc,
+			insert: "f",
+			want:   []string{"d", "b", "e", "a", "c", "z", "y", "f"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			opts := ListItemsOptions{SellerID: tt.sellerID, Limit: 2, Sort: tt.sort, Order: tt.order}
+
+			var got []string
+			for page := 0; ; page++ {
+				if page > 10 {
+					t.Fatal("pagination did not terminate")
+				}
+
+				items, next, err := repo.GetAllItem(ctx, opts)
+				if err != nil {
+					t.Fatalf("failed to get items: %v", err)
+				}
+				for _, item := range items {
+					got = append(got, item.Name)
+				}
+				if page == 0 && tt.insert != "" {
+					if err := repo.Insert(ctx, &Item{Name: tt.insert, Category: "test", Image: "test.jpg"}); err != nil {
+						t.Fatalf("failed to insert item: %v", err)
+					}
+					t.Cleanup(func() {
+						if _, err := db.Exec("DELETE FROM items WHERE name = ?", tt.insert); err != nil {
+							t.Errorf("failed to clean up item: %v", err)
+						}
+					})
+				}
+				if next == nil {
+					break
+				}
+				opts.After = next
+			}
+
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestItemRepositoryInsertConcurrently(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	ctx := t.Context()
+
+	// every item is in the same new category, so all inserts race to create it
+	const n = 16
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = repo.Insert(ctx, &Item{Name: "item " + strconv.Itoa(i), Category: "brand-new", Image: "test.jpg"})
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("insert %d failed: %v", i, err)
+		}
+	}
+
+	var categories, items int
+	err = db.QueryRowContext(ctx, `
+		SELECT (SELECT COUNT(*) FROM categories WHERE name = 'brand-new'),
+		       (SELECT COUNT(*) FROM items JOIN categories ON items.category_id = categories.id WHERE categories.name = 'brand-new')`).
+		Scan(&categories, &items)
+	if err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	if categories != 1 {
+		t.Errorf("expected exactly one category, got %d", categories)
+	}
+	if items != n {
+		t.Errorf("expected %d items in the category, got %d", n, items)
+	}
+}
+
+func TestItemRepositoryDeleteConcurrently(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	ctx := t.Context()
+
+	const n = 8
+	itemIds := make([]string, n)
+	for i := range n {
+		item := &Item{Name: "item " + strconv.Itoa(i), Category: "audio", Image: "test.jpg"}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		itemIds[i] = strconv.Itoa(item.ID)
+	}
+
+	errs := make([]error, n)
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, itemId := range itemIds {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			errs[i] = repo.Delete(ctx, itemId)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("delete %d failed: %v", i, err)
+		}
+	}
+
+	var items, images int
+	err = db.QueryRowContext(ctx, "SELECT (SELECT COUNT(*) FROM items), (SELECT COUNT(*) FROM item_images)").Scan(&items, &images)
+	if err != nil {
+		t.Fatalf("failed to count rows: %v", err)
+	}
+	if items != 0 || images != 0 {
+		t.Errorf("expected every item and image to be deleted, got %d items and %d images", items, images)
+	}
+}
+
+func TestItemRepositoryUpdate(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	ctx := t.Context()
+
+	item := &Item{Name: "walkman", Category: "audio", Image: "test.jpg", Price: 10000, Currency: "JPY", Condition: ConditionGood}
+	if err := repo.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	itemId := strconv.Itoa(item.ID)
+
+	// each update sets a different field, so none of them may undo the others
+	name, category, price, description, condition := "discman", "portable audio", int64(8000), "barely used", ConditionLikeNew
+	updates := []ItemUpdate{
+		{Name: &name},
+		{Category: &category},
+		{Price: &price},
+		{Description: &description},
+		{Condition: &condition},
+	}
+	errs := make([]error, len(updates))
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i, update := range updates {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			_, errs[i] = repo.Update(ctx, itemId, update)
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("update %d failed: %v", i, err)
+		}
+	}
+
+	got, err := repo.GetItemById(ctx, itemId)
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	want := Item{
+		ID: item.ID, Name: name, Category: category, Image: "test.jpg", Images: item.Images,
+		Price: price, Currency: "JPY", Description: description, Condition: condition, Status: item.Status,
+	}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Item{}, "CreatedAt", "UpdatedAt")); diff != "" {
+		t.Errorf("unexpected item after the updates (-want +got):\n%s", diff)
+	}
+
+	if _, err := repo.Update(ctx, "999", ItemUpdate{Name: &name}); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound, got %v", err)
+	}
+}
+
+func TestWithTx(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	ctx := t.Context()
+	insert := func(name string, fnErr error) error {
+		return WithTx(ctx, db, func(tx *sql.Tx) error {
+			if _, err := upsertCategory(ctx, tx, name); err != nil {
+				return err
+			}
+			return fnErr
+		})
+	}
+	exists := func(name string) bool {
+		var exists bool
+		if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM categories WHERE name = ?)", name).Scan(&exists); err != nil {
+			t.Fatalf("failed to query category: %v", err)
+		}
+		return exists
+	}
+
+	if err := insert("committed", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !exists("committed") {
+		t.Error("expected the category to be committed")
+	}
+
+	errFn := errors.New("fn failed")
+	if err := insert("rolled back", errFn); !errors.Is(err, errFn) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	if exists("rolled back") {
+		t.Error("expected the category to be rolled back")
+	}
+}
+
+func TestItemRepositoryPurchase(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	users := &userRepository{db: db}
+	ctx := t.Context()
+
+	var buyers []*User
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		u := &User{Name: name, Email: name + "@example.com", PasswordHash: "hash", Role: RoleBuyer}
+		if err := users.Insert(ctx, u); err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+		buyers = append(buyers, u)
+	}
+
+	t.Run("ok: second purchase conflicts", func(t *testing.T) {
+		item := &Item{Name: "bag", Category: "fashion", Image: "bag.jpg", Price: 3000, Currency: "JPY", Condition: ConditionGood}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		itemId := strconv.Itoa(item.ID)
+
+		order, err := repo.Purchase(ctx, itemId, buyers[0].ID)
+		if err != nil {
+			t.Fatalf("failed to purchase item: %v", err)
+		}
+		want := Order{ItemID: item.ID, BuyerID: buyers[0].ID, Price: 3000, Currency: "JPY"}
+		if diff := cmp.Diff(want, order, cmpopts.IgnoreFields(Order{}, "ID", "CreatedAt")); diff != "" {
+			t.Errorf("unexpected order (-want +got):\n%s", diff)
+		}
+
+		got, err := repo.GetItemById(ctx, itemId)
+		if err != nil {
+			t.Fatalf("failed to get item: %v", err)
+		}
+		if got.Status != ItemStatusTrading {
+			t.Errorf("expected status %s after purchase, got %s", ItemStatusTrading, got.Status)
+		}
+
+		if _, err := repo.Purchase(ctx, itemId, buyers[1].ID); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected a conflict on second purchase, got %v", err)
+		}
+	})
+
+	t.Run("ng: item not found", func(t *testing.T) {
+		if _, err := repo.Purchase(ctx, "999", buyers[0].ID); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found, got %v", err)
+		}
+	})
+
+	t.Run("ng: ordered items cannot be deleted", func(t *testing.T) {
+		item := &Item{Name: "coat", Category: "fashion", Image: "coat.jpg", Price: 8000, Currency: "JPY", Condition: ConditionGood}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		itemId := strconv.Itoa(item.ID)
+		if _, err := repo.Purchase(ctx, itemId, buyers[0].ID); err != nil {
+			t.Fatalf("failed to purchase item: %v", err)
+		}
+
+		if err := repo.Delete(ctx, itemId); !errors.Is(err, errItemNotOnSale) {
+			t.Errorf("expected errItemNotOnSale for an item in a trade, got %v", err)
+		}
+		// a cancelled trade puts the item back on sale, but its order stays
+		if _, err := db.ExecContext(ctx, "UPDATE items SET status = ? WHERE id = ?", ItemStatusOnSale, item.ID); err != nil {
+			t.Fatalf("failed to put the item back on sale: %v", err)
+		}
+		if err := repo.Delete(ctx, itemId); !errors.Is(err, errItemHasOrders) {
+			t.Errorf("expected errItemHasOrders for an ordered item, got %v", err)
+		}
+		if _, err := repo.GetItemById(ctx, itemId); err != nil {
+			t.Errorf("expected the item to be kept, got %v", err)
+		}
+		if err := repo.Delete(ctx, "999"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found, got %v", err)
+		}
+	})
+
+	t.Run("ok: trades are completed or cancelled", func(t *testing.T) {
+		item := &Item{Name: "scarf", Category: "fashion", Image: "scarf.jpg", Price: 2000, Currency: "JPY", Condition: ConditionNew}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		itemId := strconv.Itoa(item.ID)
+		status := func(t *testing.T) ItemStatus {
+			t.Helper()
+			got, err := repo.GetItemById(ctx, itemId)
+			if err != nil {
+				t.Fatalf("failed to get item: %v", err)
+			}
+			return got.Status
+		}
+
+		if _, err := repo.GetTradeOrder(ctx, itemId); !errors.Is(err, errItemNotTraded) {
+			t.Errorf("expected errItemNotTraded before a purchase, got %v", err)
+		}
+		first, err := repo.Purchase(ctx, itemId, buyers[0].ID)
+		if err != nil {
+			t.Fatalf("failed to purchase item: %v", err)
+		}
+		order, err := repo.GetTradeOrder(ctx, itemId)
+		if err != nil {
+			t.Fatalf("failed to get trade order: %v", err)
+		}
+		if diff := cmp.Diff(first, order); diff != "" {
+			t.Errorf("unexpected trade order (-want +got):\n%s", diff)
+		}
+
+		if err := repo.CancelTrade(ctx, order); err != nil {
+			t.Fatalf("failed to cancel trade: %v", err)
+		}
+		if got := status(t); got != ItemStatusOnSale {
+			t.Errorf("expected status %s after cancel, got %s", ItemStatusOnSale, got)
+		}
+		if err := repo.CancelTrade(ctx, order); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected a conflict for a cancelled trade, got %v", err)
+		}
+
+		second, err := repo.Purchase(ctx, itemId, buyers[1].ID)
+		if err != nil {
+			t.Fatalf("failed to purchase item again: %v", err)
+		}
+		// the first trade is over even though the item is trading again
+		if err := repo.CompleteTrade(ctx, first); !errors.Is(err, errTradeChanged) {
+			t.Errorf("expected errTradeChanged for the first trade, got %v", err)
+		}
+		if got := status(t); got != ItemStatusTrading {
+			t.Errorf("expected status %s after a failed completion, got %s", ItemStatusTrading, got)
+		}
+		if err := repo.CompleteTrade(ctx, second); err != nil {
+			t.Fatalf("failed to complete trade: %v", err)
+		}
+		if got := status(t); got != ItemStatusSoldOut {
+			t.Errorf("expected status %s after completion, got %s", ItemStatusSoldOut, got)
+		}
+		if _, err := repo.GetTradeOrder(ctx, itemId); !errors.Is(err, errItemNotTraded) {
+			t.Errorf("expected errItemNotTraded after completion, got %v", err)
+		}
+		if _, err := repo.GetTradeOrder(ctx, "999"); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found, got %v", err)
+		}
+	})
+
+	t.Run("ok: only one of parallel buyers wins", func(t *testing.T) {
+		item := &Item{Name: "watch", Category: "fashion", Image: "watch.jpg", Price: 12000, Currency: "JPY", Condition: ConditionNew}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		itemId := strconv.Itoa(item.ID)
+
+		errs := make([]error, len(buyers))
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i, buyer := range buyers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				_, errs[i] = repo.Purchase(ctx, itemId, buyer.ID)
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		var won int
+		for _, err := range errs {
+			switch {
+			case err == nil:
+				won++
+			case !errors.Is(err, errItemNotOnSale):
+				t.Errorf("expected a conflict for losing buyers, got %v", err)
+			}
+		}
+		if won != 1 {
+			t.Errorf("expected exactly one buyer to win, got %d", won)
+		}
+
+		var orders int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders WHERE item_id = ?", item.ID).Scan(&orders); err != nil {
+			t.Fatalf("failed to count orders: %v", err)
+		}
+		if orders != 1 {
+			t.Errorf("expected exactly one order, got %d", orders)
+		}
+	})
+}
+
+func TestItemRepositoryImages(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	ctx := t.Context()
+
+	item := &Item{Name: "camera", Category: "camera", Images: []ItemImage{{Name: "front.jpg"}, {Name: "back.jpg"}}}
+	if err := repo.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	itemId := strconv.Itoa(item.ID)
+
+	// names returns the image names of the stored item, checking that its main image is the first one.
+	names := func(t *testing.T) []string {
+		t.Helper()
+		got, err := repo.GetItemById(ctx, itemId)
+		if err != nil {
+			t.Fatalf("failed to get item: %v", err)
+		}
+		var names []string
+		for _, image := range got.Images {
+			names = append(names, image.Name)
+		}
+		if len(names) > 0 && got.Image != names[0] {
+			t.Errorf("expected the main image to be %s, got %s", names[0], got.Image)
+		}
+		return names
+	}
+	ids := func(images []ItemImage) map[string]int {
+		ids := map[string]int{}
+		for _, image := range images {
+			ids[image.Name] = image.ID
+		}
+		return ids
+	}
+
+	if diff := cmp.Diff([]string{"front.jpg", "back.jpg"}, names(t)); diff != "" {
+		t.Fatalf("unexpected images after insert (-want +got):\n%s", diff)
+	}
+
+	t.Run("ok: images are added, reordered and deleted", func(t *testing.T) {
+		images, err := repo.AddImages(ctx, itemId, []string{"side.jpg", "box.jpg"})
+		if err != nil {
+			t.Fatalf("failed to add images: %v", err)
+		}
+		id := ids(images)
+
+		if _, err := repo.ReorderImages(ctx, itemId, []int{id["box.jpg"], id["front.jpg"], id["side.jpg"], id["back.jpg"]}); err != nil {
+			t.Fatalf("failed to reorder images: %v", err)
+		}
+		if diff := cmp.Diff([]string{"box.jpg", "front.jpg", "side.jpg", "back.jpg"}, names(t)); diff != "" {
+			t.Errorf("unexpected images after reorder (-want +got):\n%s", diff)
+		}
+
+		if _, err := repo.DeleteImage(ctx, itemId, id["box.jpg"]); err != nil {
+			t.Fatalf("failed to delete image: %v", err)
+		}
+		if diff := cmp.Diff([]string{"front.jpg", "side.jpg", "back.jpg"}, names(t)); diff != "" {
+			t.Errorf("unexpected images after delete (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ng: invalid changes are rolled back", func(t *testing.T) {
+		before := names(t)
+
+		var many []string
+		for i := range maxItemImages {
+			many = append(many, "extra"+strconv.Itoa(i)+".jpg")
+		}
+		if _, err := repo.AddImages(ctx, itemId, many); !errors.Is(err, ErrValidation) {
+			t.Errorf("expected a validation error for too many images, got %v", err)
+		}
+		if _, err := repo.AddImages(ctx, itemId, []string{"front.jpg"}); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected a conflict for a duplicate image, got %v", err)
+		}
+		if _, err := repo.AddImages(ctx, "999", []string{"front.jpg"}); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found for a missing item, got %v", err)
+		}
+
+		got, err := repo.GetItemById(ctx, itemId)
+		if err != nil {
+			t.Fatalf("failed to get item: %v", err)
+		}
+		id := ids(got.Images)
+		if _, err := repo.ReorderImages(ctx, itemId, []int{id["back.jpg"]}); !errors.Is(err, ErrValidation) {
+			t.Errorf("expected a validation error for a partial order, got %v", err)
+		}
+		if _, err := repo.DeleteImage(ctx, itemId, 999); !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected not found for a missing image, got %v", err)
+		}
+		if diff := cmp.Diff(before, names(t)); diff != "" {
+			t.Errorf("unexpected images after failed changes (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ng: the last image cannot be deleted", func(t *testing.T) {
+		single := &Item{Name: "lens", Category: "camera", Image: "lens.jpg"}
+		if err := repo.Insert(ctx, single); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		if _, err := repo.DeleteImage(ctx, strconv.Itoa(single.ID), single.Images[0].ID); !errors.Is(err, ErrConflict) {
+			t.Errorf("expected a conflict, got %v", err)
+		}
+	})
+
+	t.Run("ok: update keeps the images added since the item was read", func(t *testing.T) {
+		if _, err := repo.AddImages(ctx, itemId, []string{"later.jpg"}); err != nil {
+			t.Fatalf("failed to add image: %v", err)
+		}
+		want := names(t)
+
+		renamed := "renamed"
+		got, err := repo.Update(ctx, itemId, ItemUpdate{Name: &renamed})
+		if err != nil {
+			t.Fatalf("failed to update item: %v", err)
+		}
+		if diff := cmp.Diff(want, names(t)); diff != "" {
+			t.Errorf("unexpected images after update (-want +got):\n%s", diff)
+		}
+		var gotNames []string
+		for _, image := range got.Images {
+			gotNames = append(gotNames, image.Name)
+		}
+		if diff := cmp.Diff(want, gotNames); diff != "" {
+			t.Errorf("unexpected images of the updated item (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ok: update replaces the images and delete removes them", func(t *testing.T) {
+		if _, err := repo.Update(ctx, itemId, ItemUpdate{Images: []ItemImage{{Name: "new.jpg"}}}); err != nil {
+			t.Fatalf("failed to update item: %v", err)
+		}
+		if diff := cmp.Diff([]string{"new.jpg"}, names(t)); diff != "" {
+			t.Errorf("unexpected images after update (-want +got):\n%s", diff)
+		}
+
+		if err := repo.Delete(ctx, itemId); err != nil {
+			t.Fatalf("failed to delete item: %v", err)
+		}
+		var n int
+		if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM item_images WHERE item_id = ?", item.ID).Scan(&n); err != nil {
+			t.Fatalf("failed to count images: %v", err)
+		}
+		if n != 0 {
+			t.Errorf("expected the images of the deleted item to be removed, got %d", n)
+		}
+	})
+}
+
+func TestItemRepositorySearchItemsByKeyword(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	ctx := t.Context()
+
+	for _, item := range []*Item{
+		{Name: "中古 iPhone 16e", Category: "スマートフォン", Description: "画面に傷があります"},
+		{Name: "iPhone ケース", Category: "アクセサリー", Description: "iPhone 16e 対応の手帳型"},
+		{Name: "Android スマホ", Category: "スマートフォン", Description: "ほぼ新品の美品です"},
+		{Name: "ナイキ スニーカー", Category: "靴", Description: "サイズ 27cm"},
+		{Name: "100% cotton shirt", Category: "fashion", Description: "plain white"},
+	} {
+		item.Image, item.Currency, item.Condition = "test.jpg", "JPY", ConditionGood
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	indexed, err := repo.hasSearchIndex(ctx)
+	if err != nil {
+		t.Fatalf("failed to check search index: %v", err)
+	}
+
+	cases := map[string]struct {
+		keyword string
+		// want is the names of the matching items; the order is only checked when ranked is true.
+		want   []string
+		ranked bool
+	}{
+		"ok: name and description are searched": {
+			keyword: "iphone",
+			want:    []string{"中古 iPhone 16e", "iPhone ケース"},
+		},
+		"ok: category is searched": {
+			keyword: "スマートフォン",
+			want:    []string{"中古 iPhone 16e", "Android スマホ"},
+		},
+		"ok: all terms must match": {
+			keyword: "iphone ケース",
+			want:    []string{"iPhone ケース"},
+		},
+		"ok: either term of OR matches": {
+			keyword: "ケース OR Android",
+			want:    []string{"iPhone ケース", "Android スマホ"},
+		},
+		"ok: phrase": {
+			keyword: `"iPhone 16e"`,
+			want:    []string{"中古 iPhone 16e", "iPhone ケース"},
+		},
+		"ok: prefix": {
+			keyword: "スニー*",
+			want:    []string{"ナイキ スニーカー"},
+		},
+		"ok: terms shorter than a trigram": {
+			keyword: "靴 OR 美品",
+			want:    []string{"Android スマホ", "ナイキ スニーカー"},
+		},
+		"ok: wildcards are matched literally": {
+			keyword: "100%",
+			want:    []string{"100% cotton shirt"},
+		},
+		"ok: a match in the name ranks above one in the description": {
+			keyword: "16e",
+			want:    []string{"中古 iPhone 16e", "iPhone ケース"},
+			ranked:  indexed,
+		},
+		"ok: no match": {
+			keyword: "tablet",
+			want:    nil,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			query, err := parseSearchQuery(tt.keyword)
+			if err != nil {
+				t.Fatalf("failed to parse query: %v", err)
+			}
+			hits, next, err := repo.SearchItemsByKeyword(ctx, SearchItemsOptions{Query: query, Limit: 10})
+			if err != nil {
+				t.Fatalf("failed to search items: %v", err)
+			}
+			if next != nil {
+				t.Errorf("expected no next page, got %+v", next)
+			}
+
+			var got []string
+			for _, hit := range hits {
+				got = append(got, hit.Name)
+			}
+			opts := []cmp.Option{cmpopts.SortSlices(func(a, b string) bool { return a < b })}
+			if tt.ranked {
+				opts = nil
+			}
+			if diff := cmp.Diff(tt.want, got, opts...); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("ok: matches are highlighted", func(t *testing.T) {
+		if !indexed {
+			t.Skip("sqlite3 is built without FTS5; run with -tags sqlite_fts5")
+		}
+
+		hits, _, err := repo.SearchItemsByKeyword(ctx, SearchItemsOptions{Query: SearchQuery{{"16e"}}, Limit: 10})
+		if err != nil {
+			t.Fatalf("failed to search items: %v", err)
+		}
+		want := []*ItemHighlight{
+			{Name: "中古 iPhone <mark>16e</mark>"},
+			{Name: "iPhone ケース", Description: "iPhone <mark>16e</mark> 対応の手帳型"},
+		}
+		var got []*ItemHighlight
+		for _, hit := range hits {
+			got = append(got, hit.Highlight)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("unexpected highlights (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ok: updates and deletes are reflected", func(t *testing.T) {
+		item := &Item{Name: "walkman", Category: "audio", Image: "test.jpg", Currency: "JPY", Condition: ConditionFair}
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+		search := func(keyword string) int {
+			t.Helper()
+			hits, _, err := repo.SearchItemsByKeyword(ctx, SearchItemsOptions{Query: SearchQuery{{keyword}}, Limit: 10})
+			if err != nil {
+				t.Fatalf("failed to search items: %v", err)
+			}
+			return len(hits)
+		}
+
+		name, category := "discman", "portable audio"
+		if _, err := repo.Update(ctx, strconv.Itoa(item.ID), ItemUpdate{Name: &name, Category: &category}); err != nil {
+			t.Fatalf("failed to update item: %v", err)
+		}
+		if n := search("walkman"); n != 0 {
+			t.Errorf("expected the old name not to match after update, got %d hits", n)
+		}
+		if n := search("discman"); n != 1 {
+			t.Errorf("expected the new name to match after update, got %d hits", n)
+		}
+		if n := search("portable"); n != 1 {
+			t.Errorf("expected the new category to match after update, got %d hits", n)
+		}
+
+		if err := repo.Delete(ctx, strconv.Itoa(item.ID)); err != nil {
+			t.Fatalf("failed to delete item: %v", err)
+		}
+		if n := search("discman"); n != 0 {
+			t.Errorf("expected no match after delete, got %d hits", n)
+		}
+	})
+
+	t.Run("ok: pages", func(t *testing.T) {
+		opts := SearchItemsOptions{Query: SearchQuery{{"iPhone", "スマホ", "スニーカー"}}, Limit: 2}
+		var got []string
+		for page := 0; ; page++ {
+			if page > 10 {
+				t.Fatal("pagination did not terminate")
+			}
+			hits, next, err := repo.SearchItemsByKeyword(ctx, opts)
+			if err != nil {
+				t.Fatalf("failed to search items: %v", err)
+			}
+			for _, hit := range hits {
+				got = append(got, hit.Name)
+			}
+			if next == nil {
+				break
+			}
+			opts.Offset = next.Offset
+		}
+
+		want := []string{"中古 iPhone 16e", "iPhone ケース", "Android スマホ", "ナイキ スニーカー"}
+		if diff := cmp.Diff(want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+			t.Errorf("unexpected items (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestItemRepositorySearchItemsFilters(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	ctx := t.Context()
+
+	seller := &User{Name: "seller", Email: "seller@example.com", PasswordHash: "hash", Role: RoleSeller}
+	if err := (&userRepository{db: db}).Insert(ctx, seller); err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	for _, item := range []*Item{
+		{Name: "iPhone 15", Category: "phone", Price: 80000, Condition: ConditionGood},
+		{Name: "iPhone 16", Category: "phone", Price: 120000, Condition: ConditionNew, SellerID: seller.ID},
+		{Name: "Pixel 9", Category: "phone", Price: 90000, Condition: ConditionLikeNew},
+		{Name: "iPad mini", Category: "tablet", Price: 50000, Condition: ConditionGood, SellerID: seller.ID},
+		{Name: "jacket", Category: "fashion", Price: 8000, Condition: ConditionFair},
+	} {
+		item.Image, item.Currency = "test.jpg", "JPY"
+		if err := repo.Insert(ctx, item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+	if _, err := repo.Purchase(ctx, "3", seller.ID); err != nil {
+		t.Fatalf("failed to purchase item: %v", err)
+	}
+	// backdate the jacket to test date ranges
+	if _, err := db.ExecContext(ctx, "UPDATE items SET created_at = '2020-01-01T00:00:00.000Z' WHERE name = 'jacket'"); err != nil {
+		t.Fatalf("failed to backdate item: %v", err)
+	}
+
+	price := func(p int64) *int64 { return &p }
+	cases := map[string]struct {
+		keyword string
+		filter  ItemFilter
+		want    []string
+		facets  ItemFacets
+	}{
+		"ok: no filter": {
+			want: []string{"iPhone 15", "iPhone 16", "Pixel 9", "iPad mini", "jacket"},
+			facets: ItemFacets{
+				Categories: []FacetCount{{"phone", 3}, {"fashion", 1}, {"tablet", 1}},
+				Conditions: []FacetCount{{"good", 2}, {"fair", 1}, {"like-new", 1}, {"new", 1}},
+			},
+		},
+		"ok: keyword with category": {
+			keyword: "iP",
+			filter:  ItemFilter{Categories: []string{"phone"}},
+			want:    []string{"iPhone 15", "iPhone 16"},
+			facets: ItemFacets{
+				Categories: []FacetCount{{"phone", 2}, {"tablet", 1}},
+				Conditions: []FacetCount{{"good", 1}, {"new", 1}},
+			},
+		},
+		"ok: several conditions": {
+			filter: ItemFilter{Conditions: []Condition{ConditionNew, ConditionFair}},
+			want:   []string{"iPhone 16", "jacket"},
+			facets: ItemFacets{
+				Categories: []FacetCount{{"fashion", 1}, {"phone", 1}},
+				Conditions: []FacetCount{{"good", 2}, {"fair", 1}, {"like-new", 1}, {"new", 1}},
+			},
+		},
+		"ok: price range": {
+			filter: ItemFilter{MinPrice: price(50000), MaxPrice: price(90000)},
+			want:   []string{"iPhone 15", "Pixel 9", "iPad mini"},
+			facets: ItemFacets{
+				Categories: []FacetCount{{"phone", 2}, {"tablet", 1}},
+				Conditions: []FacetCount{{"good", 2}, {"like-new", 1}},
+			},
+		},
+		"ok: status and seller": {
+			filter: ItemFilter{Statuses: []ItemStatus{ItemStatusOnSale}, SellerID: seller.ID},
+			want:   []string{"iPhone 16", "iPad mini"},
+			facets: ItemFacets{
+				Categories: []FacetCount{{"phone", 1}, {"tablet", 1}},
+				Conditions: []FacetCount{{"good", 1}, {"new", 1}},
+			},
+		},
+		"ok: date range": {
+			filter: ItemFilter{CreatedFrom: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC), CreatedTo: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)},
+			want:   []string{"jacket"},
+			facets: ItemFacets{
+				Categories: []FacetCount{{"fashion", 1}},
+				Conditions: []FacetCount{{"fair", 1}},
+			},
+		},
+		"ok: nothing matches": {
+			keyword: "iPhone",
+			filter:  ItemFilter{Categories: []string{"fashion"}},
+			want:    nil,
+			facets: ItemFacets{
+				Categories: []FacetCount{{"phone", 2}},
+				Conditions: []FacetCount{},
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			var query SearchQuery
+			if tt.keyword != "" {
+				if query, err = parseSearchQuery(tt.keyword); err != nil {
+					t.Fatalf("failed to parse query: %v", err)
+				}
+			}
+
+			hits, _, err := repo.SearchItemsByKeyword(ctx, SearchItemsOptions{Query: query, Filter: tt.filter, Limit: 10})
+			if err != nil {
+				t.Fatalf("failed to search items: %v", err)
+			}
+			var got []string
+			for _, hit := range hits {
+				got = append(got, hit.Name)
+			}
+			if diff := cmp.Diff(tt.want, got, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+				t.Errorf("unexpected items (-want +got):\n%s", diff)
+			}
+
+			facets, err := repo.CountItemFacets(ctx, query, tt.filter)
+			if err != nil {
+				t.Fatalf("failed to count facets: %v", err)
+			}
+			if diff := cmp.Diff(tt.facets, facets, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected facets (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectBuilder(t *testing.T) {
+	t.Parallel()
+
+	query, args := newSelect("items.id").
+		Column("highlight(items_fts, 0, ?, ?)", "<b>", "</b>").
+		Where("items.price >= ?", 100).
+		From("items").
+		Join("JOIN categories ON items.category_id = categories.id").
+		WhereIn("categories.name", "phone", "tablet").
+		OrderBy("items.id DESC").
+		Limit(10, 20).
+		Build()
+
+	wantQuery := `SELECT items.id, highlight(items_fts, 0, ?, ?)
+FROM items
+JOIN categories ON items.category_id = categories.id
+WHERE items.price >= ?
+AND categories.name IN (?, ?)
+ORDER BY items.id DESC
+LIMIT ? OFFSET ?`
+	if diff := cmp.Diff(wantQuery, query); diff != "" {
+		t.Errorf("unexpected query (-want +got):\n%s", diff)
+	}
+	wantArgs := []any{"<b>", "</b>", 100, "phone", "tablet", 10, 20}
+	if diff := cmp.Diff(wantArgs, args); diff != "" {
+		t.Errorf("unexpected args (-want +got):\n%s", diff)
+	}
+}
+
+func TestCategoryRepository(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := NewCategoryRepository(db)
+	items := &itemRepository{db: db}
+	ctx := t.Context()
+
+	// fashion > shoes > sneakers, and the misspelled fashon
+	insert := func(name string, parentID int) Category {
+		t.Helper()
+		c := Category{Name: name, ParentID: parentID}
+		if err := repo.Insert(ctx, &c); err != nil {
+			t.Fatalf("failed to insert category %s: %v", name, err)
+		}
+		return c
+	}
+	fashion := insert("fashion", 0)
+	shoes := insert("shoes", fashion.ID)
+	sneakers := insert("sneakers", shoes.ID)
+	fashon := insert("fashon", 0)
+
+	for _, item := range []Item{
+		{Name: "coat", Category: "fashion"},
+		{Name: "boots", Category: "shoes"},
+		{Name: "runners", Category: "sneakers"},
+		{Name: "scarf", Category: "fashon"},
+		{Name: "phone", Category: "phone"},
+	} {
+		item.Image = "test.jpg"
+		if err := items.Insert(ctx, &item); err != nil {
+			t.Fatalf("failed to insert item: %v", err)
+		}
+	}
+
+	categoryItems := func(id int) []string {
+		t.Helper()
+		got, _, err := items.GetAllItem(ctx, ListItemsOptions{CategoryID: id, Limit: 10, Sort: ItemSortName, Order: SortAsc})
+		if err != nil {
+			t.Fatalf("failed to get items: %v", err)
+		}
+		var names []string
+		for _, item := range got {
+			names = append(names, item.Name)
+		}
+		return names
+	}
+
+	t.Run("ok: items of subcategories are included", func(t *testing.T) {
+		if diff := cmp.Diff([]string{"boots", "coat", "runners"}, categoryItems(fashion.ID)); diff != "" {
+			t.Errorf("unexpected items (-want +got):\n%s", diff)
+		}
+		if diff := cmp.Diff([]string{"runners"}, categoryItems(sneakers.ID)); diff != "" {
+			t.Errorf("unexpected items (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ng: invalid trees are rejected", func(t *testing.T) {
+		cases := map[string]struct {
+			err  error
+			call func() error
+		}{
+			"duplicate name": {
+				err:  errCategoryDuplicate,
+				call: func() error { return repo.Insert(ctx, &Category{Name: "shoes"}) },
+			},
+			"unknown parent": {
+				err:  errParentNotFound,
+				call: func() error { return repo.Insert(ctx, &Category{Name: "bags", ParentID: 999}) },
+			},
+			"nested under itself": {
+				err: errCategoryCycle,
+				call: func() error {
+					return repo.Update(ctx, &Category{ID: fashion.ID, Name: "fashion", ParentID: fashion.ID})
+				},
+			},
+			"nested under a descendant": {
+				err: errCategoryCycle,
+				call: func() error {
+					return repo.Update(ctx, &Category{ID: fashion.ID, Name: "fashion", ParentID: sneakers.ID})
+				},
+			},
+			"deleted while it has items": {
+				err:  errCategoryInUse,
+				call: func() error { return repo.Delete(ctx, sneakers.ID) },
+			},
+			"deleted while it has subcategories": {
+				err:  errCategoryInUse,
+				call: func() error { return repo.Delete(ctx, shoes.ID) },
+			},
+			"merged into a descendant": {
+				err:  errMergeIntoSubtree,
+				call: func() error { return repo.Merge(ctx, fashion.ID, shoes.ID) },
+			},
+			"merged into an unknown category": {
+				err:  errCategoryNotFound,
+				call: func() error { return repo.Merge(ctx, fashon.ID, 999) },
+			},
+		}
+		for name, tt := range cases {
+			if err := tt.call(); !errors.Is(err, tt.err) {
+				t.Errorf("%s: expected %v, got %v", name, tt.err, err)
+			}
+		}
+	})
+
+	t.Run("ok: merge moves items and subcategories", func(t *testing.T) {
+		// fashon gets a subcategory so that merging it has one to move
+		bags := insert("bags", fashon.ID)
+
+		if err := repo.Merge(ctx, fashon.ID, fashion.ID); err != nil {
+			t.Fatalf("failed to merge: %v", err)
+		}
+
+		if _, err := repo.Get(ctx, fashon.ID); !errors.Is(err, errCategoryNotFound) {
+			t.Errorf("expected the merged category to be deleted, got %v", err)
+		}
+		got, err := repo.Get(ctx, bags.ID)
+		if err != nil {
+			t.Fatalf("failed to get category: %v", err)
+		}
+		if got.ParentID != fashion.ID {
+			t.Errorf("expected bags to move under fashion, got parent %d", got.ParentID)
+		}
+		if diff := cmp.Diff([]string{"boots", "coat", "runners", "scarf"}, categoryItems(fashion.ID)); diff != "" {
+			t.Errorf("unexpected items (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("ok: moving a category to the top level and deleting an unused one", func(t *testing.T) {
+		sneakers.ParentID = 0
+		if err := repo.Update(ctx, &sneakers); err != nil {
+			t.Fatalf("failed to update category: %v", err)
+		}
+		if diff := cmp.Diff([]string{"boots", "coat", "scarf"}, categoryItems(fashion.ID)); diff != "" {
+			t.Errorf("unexpected items (-want +got):\n%s", diff)
+		}
+
+		unused := insert("unused", 0)
+		if err := repo.Delete(ctx, unused.ID); err != nil {
+			t.Fatalf("failed to delete category: %v", err)
+		}
+		if err := repo.Delete(ctx, unused.ID); !errors.Is(err, errCategoryNotFound) {
+			t.Errorf("expected errCategoryNotFound on the second delete, got %v", err)
+		}
+	})
+
+	t.Run("ok: concurrent writes wait for each other", func(t *testing.T) {
+		// each writer inserts, renames, merges and deletes categories of its own under the same parent
+		write := func(i int) error {
+			c := Category{Name: fmt.Sprintf("writer %d", i), ParentID: fashion.ID}
+			if err := repo.Insert(ctx, &c); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			c.Name += " renamed"
+			if err := repo.Update(ctx, &c); err != nil {
+				return fmt.Errorf("update: %w", err)
+			}
+			dup := Category{Name: fmt.Sprintf("writer %d duplicate", i), ParentID: fashion.ID}
+			if err := repo.Insert(ctx, &dup); err != nil {
+				return fmt.Errorf("insert: %w", err)
+			}
+			if err := repo.Merge(ctx, dup.ID, c.ID); err != nil {
+				return fmt.Errorf("merge: %w", err)
+			}
+			if err := repo.Delete(ctx, c.ID); err != nil {
+				return fmt.Errorf("delete: %w", err)
+			}
+			return nil
+		}
+
+		errs := make([]error, 8)
+		start := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := range errs {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				<-start
+				errs[i] = write(i)
+			}()
+		}
+		close(start)
+		wg.Wait()
+
+		for i, err := range errs {
+			if err != nil {
+				t.Errorf("writer %d failed: %v", i, err)
+			}
+		}
+	})
+}
+
+func TestUserRepositoryInsert(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &userRepository{db: db}
+	ctx := t.Context()
+
+	user := &User{Name: "mercari", Email: "mercari@example.com", PasswordHash: "hash", Role: RoleBuyer}
+	if err := repo.Insert(ctx, user); err != nil {
+		t.Fatalf("failed to insert user: %v", err)
+	}
+	if user.ID == 0 {
+		t.Error("expected the inserted user to get an id")
+	}
+
+	got, err := repo.GetUserByEmail(ctx, "mercari@example.com")
+	if err != nil {
+		t.Fatalf("failed to get user: %v", err)
+	}
+	if diff := cmp.Diff(*user, got); diff != "" {
+		t.Errorf("unexpected user (-want +got):\n%s", diff)
+	}
+
+	err = repo.Insert(ctx, &User{Name: "another", Email: "mercari@example.com", PasswordHash: "hash", Role: RoleSeller})
+	if !errors.Is(err, errEmailDuplicate) {
+		t.Errorf("expected errEmailDuplicate, got %v", err)
+	}
+
+	if _, err := repo.GetUserById(ctx, user.ID+1); !errors.Is(err, errUserNotFound) {
+		t.Errorf("expected errUserNotFound, got %v", err)
+	}
+}
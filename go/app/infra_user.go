@@ -0,0 +1,81 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+var (
+	errUserNotFound   error = newError(ErrNotFound, "user not found")
+	errEmailDuplicate error = fieldError(ErrConflict, "email", "email is already registered")
+)
+
+type User struct {
+	ID           int    `db:"id" json:"id"`
+	Name         string `db:"name" json:"name"`
+	Email        string `db:"email" json:"email"`
+	PasswordHash string `db:"password_hash" json:"-"`
+	Role         Role   `db:"role" json:"role"`
+}
+
+// UserRepository is an interface to manage users.
+//
+//go:generate go run go.uber.org/mock/mockgen -source=$GOFILE -package=${GOPACKAGE} -destination=./mock_$GOFILE
+type UserRepository interface {
+	Insert(ctx context.Context, user *User) error
+	GetUserById(ctx context.Context, id int) (User, error)
+	GetUserByEmail(ctx context.Context, email string) (User, error)
+}
+
+// userRepository is an implementation of UserRepository
+type userRepository struct {
+	db *sql.DB
+}
+
+// NewUserRepository creates a new userRepository.
+func NewUserRepository(db *sql.DB) UserRepository {
+	return &userRepository{db: db}
+}
+
+// Insert inserts a user and sets the id it was given.
+// It returns errEmailDuplicate when the email is already registered.
+func (u *userRepository) Insert(ctx context.Context, user *User) error {
+	res, err := u.db.ExecContext(ctx, "INSERT INTO users (name, email, password_hash, role) VALUES (?, ?, ?, ?)", user.Name, user.Email, user.PasswordHash, user.Role)
+	if err != nil {
+		var sqliteErr sqlite3.Error
+		if errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return errEmailDuplicate
+		}
+		return err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	user.ID = int(id)
+	return nil
+}
+
+func (u *userRepository) GetUserById(ctx context.Context, id int) (User, error) {
+	return u.getUser(ctx, "SELECT id, name, email, password_hash, role FROM users WHERE id = ?", id)
+}
+
+func (u *userRepository) GetUserByEmail(ctx context.Context, email string) (User, error) {
+	return u.getUser(ctx, "SELECT id, name, email, password_hash, role FROM users WHERE email = ?", email)
+}
+
+func (u *userRepository) getUser(ctx context.Context, query string, arg any) (User, error) {
+	var user User
+	err := u.db.QueryRowContext(ctx, query, arg).Scan(&user.ID, &user.Name, &user.Email, &user.PasswordHash, &user.Role)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return User{}, errUserNotFound
+		}
+		return User{}, err
+	}
+	return user, nil
+}
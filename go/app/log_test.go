@@ -0,0 +1,76 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestContextHandler(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		ctx context.Context
+		// log logs a line with the logger.
+		log  func(ctx context.Context, logger *slog.Logger)
+		want map[string]any
+	}{
+		"ok: request ID": {
+			ctx:  withRequestID(context.Background(), "req-1"),
+			log:  func(ctx context.Context, logger *slog.Logger) { logger.InfoContext(ctx, "hello") },
+			want: map[string]any{"level": "INFO", "msg": "hello", "request_id": "req-1"},
+		},
+		"ok: outside a request": {
+			ctx:  context.Background(),
+			log:  func(ctx context.Context, logger *slog.Logger) { logger.InfoContext(ctx, "hello") },
+			want: map[string]any{"level": "INFO", "msg": "hello"},
+		},
+		"ok: trace ID": {
+			ctx: trace.ContextWithSpanContext(withRequestID(context.Background(), "req-1"), trace.NewSpanContext(trace.SpanContextConfig{
+				TraceID: trace.TraceID{0x4b, 0xf9},
+				SpanID:  trace.SpanID{0x00, 0xf0},
+			})),
+			log: func(ctx context.Context, logger *slog.Logger) { logger.InfoContext(ctx, "hello") },
+			want: map[string]any{
+				"level": "INFO", "msg": "hello", "request_id": "req-1",
+				"trace_id": "4bf90000000000000000000000000000", "span_id": "00f0000000000000",
+			},
+		},
+		"ok: derived logger": {
+			ctx: withRequestID(context.Background(), "req-1"),
+			log: func(ctx context.Context, logger *slog.Logger) {
+				logger.With("item_id", "1").WarnContext(ctx, "hello")
+			},
+			want: map[string]any{"level": "WARN", "msg": "hello", "item_id": "1", "request_id": "req-1"},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			removeTime := func(groups []string, a slog.Attr) slog.Attr {
+				if a.Key == slog.TimeKey && len(groups) == 0 {
+					return slog.Attr{}
+				}
+				return a
+			}
+			logger := slog.New(newContextHandler(slog.NewJSONHandler(&buf, &slog.HandlerOptions{ReplaceAttr: removeTime})))
+			tt.log(tt.ctx, logger)
+
+			var got map[string]any
+			if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+				t.Fatalf("failed to decode log line %q: %v", buf.String(), err)
+			}
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("unexpected log line (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
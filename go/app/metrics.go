@@ -0,0 +1,164 @@
+package app
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// unmatchedRoute labels the requests no route of the ServeMux matches, so that arbitrary paths
+// do not each create a time series.
+const unmatchedRoute = "unmatched"
+
+// otherMethod labels the requests whose method is not in labelledMethods, since clients may send any method.
+const otherMethod = "OTHER"
+
+// labelledMethods are the methods that label requests as they are.
+var labelledMethods = map[string]bool{
+	http.MethodGet: true, http.MethodHead: true, http.MethodPost: true, http.MethodPut: true,
+	http.MethodPatch: true, http.MethodDelete: true, http.MethodOptions: true,
+}
+
+// methodLabel returns the label of the method of a request, bounding the number of time series like unmatchedRoute.
+func methodLabel(method string) string {
+	if labelledMethods[method] {
+		return method
+	}
+	return otherMethod
+}
+
+// Metrics collects the metrics of a server in a registry of its own, so that servers started by tests do not share them.
+// The methods of a nil *Metrics record nothing, so that the parts of the server can be used without metrics.
+type Metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	queryDuration   *prometheus.HistogramVec
+	imagesStored    prometheus.Counter
+	imageBytes      prometheus.Counter
+	uploadSize      prometheus.Histogram
+}
+
+// NewMetrics creates the metrics of a server, along with those of the Go runtime and of the process.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "mercari",
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests by method, route pattern and status code.",
+		}, []string{"method", "route", "code"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "mercari",
+			Name:      "http_request_duration_seconds",
+			Help:      "Time taken to handle HTTP requests by method and route pattern.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		queryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "mercari",
+			Name:      "db_query_duration_seconds",
+			Help:      "Time taken by the operations of the item repository, failed ones included.",
+			Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+		}, []string{"operation"}),
+		imagesStored: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "mercari",
+			Name:      "images_stored_total",
+			Help:      "Number of images and thumbnails written to the image store.",
+		}),
+		imageBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "mercari",
+			Name:      "images_stored_bytes_total",
+			Help:      "Size of the images and thumbnails written to the image store.",
+		}),
+		uploadSize: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: "mercari",
+			Name:      "image_upload_size_bytes",
+			Help:      "Size of the uploaded images, before they are processed.",
+			// 16 KiB to 64 MiB
+			Buckets: prometheus.ExponentialBuckets(16<<10, 4, 7),
+		}),
+	}
+	m.registry.MustRegister(
+		m.requests, m.requestDuration, m.queryDuration, m.imagesStored, m.imageBytes, m.uploadSize,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler is a handler for GET /metrics, which exposes the metrics in the Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// observeQuery records the time an operation of a repository has taken since start.
+// It is meant to be deferred: defer i.metrics.observeQuery("insert", time.Now()).
+func (m *Metrics) observeQuery(operation string, start time.Time) {
+	if m == nil {
+		return
+	}
+	m.queryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// observeUpload records the size of an uploaded image.
+func (m *Metrics) observeUpload(size int64) {
+	if m == nil {
+		return
+	}
+	m.uploadSize.Observe(float64(size))
+}
+
+// instrumentImageStore counts the images written to store and their bytes.
+func (m *Metrics) instrumentImageStore(store ImageStore) ImageStore {
+	if m == nil {
+		return store
+	}
+	return &instrumentedImageStore{ImageStore: store, metrics: m}
+}
+
+type instrumentedImageStore struct {
+	ImageStore
+	metrics *Metrics
+}
+
+func (s *instrumentedImageStore) Put(ctx context.Context, key string, body io.ReadSeeker) error {
+	size, err := body.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := s.ImageStore.Put(ctx, key, body); err != nil {
+		return err
+	}
+	s.metrics.imagesStored.Inc()
+	s.metrics.imageBytes.Add(float64(size))
+	return nil
+}
+
+// metricsMiddleware counts the requests and times them, labelling them with the pattern of mux that routes them
+// rather than with their path, which would create a time series per item. It goes outside the other middlewares
+// so that the requests they answer themselves are counted too.
+func metricsMiddleware(next http.Handler, mux *http.ServeMux, m *Metrics) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		_, route := mux.Handler(r)
+		if route == "" {
+			route = unmatchedRoute
+		}
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+
+		method := methodLabel(r.Method)
+		m.requests.WithLabelValues(method, route, strconv.Itoa(rec.statusCode())).Inc()
+		m.requestDuration.WithLabelValues(method, route).Observe(time.Since(start).Seconds())
+	})
+}
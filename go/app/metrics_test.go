@@ -0,0 +1,151 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// scrapeMetrics returns the metrics GET /metrics exposes.
+func scrapeMetrics(t *testing.T, srv *httptest.Server) string {
+	t.Helper()
+
+	res, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatalf("failed to scrape metrics: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read metrics: %v", err)
+	}
+	return string(body)
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /items/{item_id}", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, r, http.StatusOK, HelloResponse{Message: r.PathValue("item_id")})
+	})
+	mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, fieldError(ErrInvalidRequest, "name", "name is required"))
+	})
+	mux.HandleFunc("DELETE /items/{item_id}", func(w http.ResponseWriter, r *http.Request) {
+		// a handler writing nothing responds 200
+	})
+	mux.Handle("GET /metrics", m.Handler())
+	srv := httptest.NewServer(metricsMiddleware(mux, mux, m))
+	t.Cleanup(srv.Close)
+
+	for _, req := range []struct{ method, path string }{
+		{"GET", "/items/1"},
+		{"GET", "/items/2"},
+		{"POST", "/items"},
+		{"DELETE", "/items/1"},
+		{"GET", "/no/such/path"},
+		{"BREW", "/items/1"},
+		{"PURGE", "/items/1"},
+	} {
+		r, err := http.NewRequest(req.method, srv.URL+req.path, nil)
+		if err != nil {
+			t.Fatalf("failed to create request: %v", err)
+		}
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("failed to send request: %v", err)
+		}
+		res.Body.Close()
+	}
+
+	got := scrapeMetrics(t, srv)
+	for _, want := range []string{
+		`mercari_http_requests_total{code="200",method="GET",route="GET /items/{item_id}"} 2`,
+		`mercari_http_requests_total{code="400",method="POST",route="POST /items"} 1`,
+		`mercari_http_requests_total{code="200",method="DELETE",route="DELETE /items/{item_id}"} 1`,
+		`mercari_http_requests_total{code="404",method="GET",route="unmatched"} 1`,
+		`mercari_http_requests_total{code="405",method="OTHER",route="unmatched"} 2`,
+		`mercari_http_request_duration_seconds_count{method="GET",route="GET /items/{item_id}"} 2`,
+		"go_goroutines ",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected the metrics to contain %s, got:\n%s", want, got)
+		}
+	}
+	// paths are not labels, so that the number of time series is bounded
+	if strings.Contains(got, `"/items/1"`) || strings.Contains(got, "/no/such/path") {
+		t.Errorf("expected no path in the labels, got:\n%s", got)
+	}
+	// nor are arbitrary methods
+	if strings.Contains(got, "BREW") || strings.Contains(got, "PURGE") {
+		t.Errorf("expected no custom method in the labels, got:\n%s", got)
+	}
+}
+
+func TestMetricsInstrumentation(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+	ctx := t.Context()
+
+	m := NewMetrics()
+	srv := httptest.NewServer(m.Handler())
+	t.Cleanup(srv.Close)
+
+	repo := NewItemRepository(db, m)
+	item := &Item{Name: "camera", Category: "camera"}
+	if err := repo.Insert(ctx, item); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+	if _, err := repo.GetItemById(ctx, "1"); err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	if _, err := repo.GetItemById(ctx, "2"); err == nil {
+		t.Fatal("expected the missing item not to be found")
+	}
+
+	store := m.instrumentImageStore(NewFileImageStore(t.TempDir()))
+	for _, key := range []string{"a.jpg", "b.jpg"} {
+		if err := store.Put(ctx, key, strings.NewReader("image")); err != nil {
+			t.Fatalf("failed to put image: %v", err)
+		}
+	}
+	// a failed write is not counted
+	if err := store.Put(ctx, "../c.jpg", strings.NewReader("image")); err == nil {
+		t.Fatal("expected an invalid key to be rejected")
+	}
+	m.observeUpload(20 << 10)
+
+	got := scrapeMetrics(t, srv)
+	for _, want := range []string{
+		`mercari_db_query_duration_seconds_count{operation="insert"} 1`,
+		// failed operations are timed too
+		`mercari_db_query_duration_seconds_count{operation="get_by_id"} 2`,
+		"mercari_images_stored_total 2",
+		"mercari_images_stored_bytes_total 10",
+		`mercari_image_upload_size_bytes_bucket{le="16384"} 0`,
+		`mercari_image_upload_size_bytes_bucket{le="65536"} 1`,
+		"mercari_image_upload_size_bytes_count 1",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected the metrics to contain %s, got:\n%s", want, got)
+		}
+	}
+}
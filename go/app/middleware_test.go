@@ -0,0 +1,184 @@
+package app
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSimpleCORSMiddleware(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		// origin is the expected Access-Control-Allow-Origin, empty when it must not be set.
+		origin string
+		vary   bool
+	}
+	cases := map[string]struct {
+		origins []string
+		origin  string
+		wants
+	}{
+		"single origin": {
+			origins: []string{"http://localhost:3000"},
+			origin:  "http://example.com",
+			wants:   wants{origin: "http://localhost:3000"},
+		},
+		"allowed origin among several": {
+			origins: []string{"https://a.example.com", "https://b.example.com"},
+			origin:  "https://b.example.com",
+			wants:   wants{origin: "https://b.example.com", vary: true},
+		},
+		"other origin among several": {
+			origins: []string{"https://a.example.com", "https://b.example.com"},
+			origin:  "https://c.example.com",
+			wants:   wants{vary: true},
+		},
+		"any origin": {
+			origins: []string{"https://a.example.com", "*"},
+			origin:  "https://c.example.com",
+			wants:   wants{origin: "*", vary: true},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			h := simpleCORSMiddleware(http.NotFoundHandler(), tt.origins, []string{"GET"})
+			req := httptest.NewRequest("OPTIONS", "/items", nil)
+			req.Header.Set("Origin", tt.origin)
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			if got := rr.Header().Get("Access-Control-Allow-Origin"); got != tt.wants.origin {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", tt.wants.origin, got)
+			}
+			if got := rr.Header().Get("Vary") == "Origin"; got != tt.vary {
+				t.Errorf("expected Vary: Origin to be %v, got %v", tt.vary, got)
+			}
+			if rr.Code != http.StatusOK {
+				t.Errorf("expected preflight requests to succeed, got %d", rr.Code)
+			}
+		})
+	}
+}
+
+func TestRequestIDMiddleware(t *testing.T) {
+	t.Parallel()
+
+	generated := regexp.MustCompile(`^[0-9a-f]{32}$`)
+	cases := map[string]struct {
+		inbound string
+		// kept tells that the inbound ID is used rather than a generated one.
+		kept bool
+	}{
+		"ok: generated":              {},
+		"ok: inbound kept":           {inbound: "7f3c9a1e-proxy.request:42", kept: true},
+		"ng: inbound with a space":   {inbound: "evil id"},
+		"ng: inbound with a newline": {inbound: "id\nforged log line"},
+		"ng: inbound too long":       {inbound: strings.Repeat("a", maxRequestIDLength+1)},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			var fromContext string
+			h := requestIDMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fromContext = requestIDFromContext(r.Context())
+			}))
+			req := httptest.NewRequest("GET", "/items", nil)
+			if tt.inbound != "" {
+				req.Header.Set(requestIDHeader, tt.inbound)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+
+			got := rr.Header().Get(requestIDHeader)
+			if got != fromContext {
+				t.Errorf("expected the header and the context to carry the same ID, got %q and %q", got, fromContext)
+			}
+			if tt.kept {
+				if got != tt.inbound {
+					t.Errorf("expected the inbound ID %q, got %q", tt.inbound, got)
+				}
+				return
+			}
+			if !generated.MatchString(got) {
+				t.Errorf("expected a generated ID, got %q", got)
+			}
+		})
+	}
+}
+
+// TestSimpleLoggerMiddleware is not parallel as it replaces the default logger.
+func TestSimpleLoggerMiddleware(t *testing.T) {
+	var buf bytes.Buffer
+	defaultLogger := slog.Default()
+	slog.SetDefault(slog.New(newContextHandler(slog.NewJSONHandler(&buf, nil))))
+	t.Cleanup(func() { slog.SetDefault(defaultLogger) })
+
+	h := requestIDMiddleware(simpleLoggerMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, r, newError(ErrNotFound, "item not found"))
+	})))
+	req := httptest.NewRequest("GET", "/items/1", nil)
+	req.Header.Set(requestIDHeader, "req-1")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var entry map[string]any
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("failed to decode log line %q: %v", line, err)
+		}
+		lines = append(lines, entry)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("expected the rejection and the access log, got %v", lines)
+	}
+	// the lines logged by handlers are correlated with the request too
+	for _, line := range lines {
+		if line["request_id"] != "req-1" {
+			t.Errorf("expected the request ID in %v", line)
+		}
+	}
+	// the access log comes last, once the response is written
+	access := lines[1]
+	if access["msg"] != "request completed" || access["status"] != float64(http.StatusNotFound) ||
+		access["bytes"] != float64(rr.Body.Len()) || access["method"] != "GET" || access["path"] != "/items/1" {
+		t.Errorf("unexpected access log: %v", access)
+	}
+	if _, ok := access["duration"]; !ok {
+		t.Errorf("expected the duration in the access log: %v", access)
+	}
+}
+
+// TestCORSPreflightMethods checks that browsers may send every method the routes of the server use.
+func TestCORSPreflightMethods(t *testing.T) {
+	t.Parallel()
+
+	h := simpleCORSMiddleware(http.NotFoundHandler(), []string{"http://localhost:3000"}, corsMethods)
+	for _, method := range []string{"GET", "POST", "PUT", "PATCH", "DELETE"} {
+		req := httptest.NewRequest("OPTIONS", "/items/1/images/order", nil)
+		req.Header.Set("Origin", "http://localhost:3000")
+		req.Header.Set("Access-Control-Request-Method", method)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("expected the preflight for %s to succeed, got %d", method, rr.Code)
+		}
+		allowed := strings.Split(rr.Header().Get("Access-Control-Allow-Methods"), ",")
+		if !slices.Contains(allowed, method) {
+			t.Errorf("expected %s to be allowed, got %v", method, allowed)
+		}
+	}
+}
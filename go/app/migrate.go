@@ -0,0 +1,281 @@
+package app
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"mercari-build-training/db"
+)
+
+// Migration is a numbered schema change with the SQL to apply and to revert it.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+	// Requires lists the SQLite compile options the migration needs, e.g. ENABLE_FTS5.
+	// They are declared in the up file with lines of the form "-- requires: ENABLE_FTS5".
+	Requires []string
+}
+
+// MigrationStatus tells whether a migration has been applied to a database.
+type MigrationStatus struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// sqliteBuildTags are the build tags of go-sqlite3 that enable the compile options migrations require.
+var sqliteBuildTags = map[string]string{
+	"ENABLE_FTS5": "sqlite_fts5",
+}
+
+var (
+	migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+	migrationRequires = regexp.MustCompile(`(?m)^-- requires: (\w+)\s*$`)
+)
+
+// LoadMigrations reads the migrations in the root of fsys, sorted by version.
+// Every version must have both an up and a down file.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		m := migrationFileName.FindStringSubmatch(path.Base(f))
+		if m == nil {
+			return nil, fmt.Errorf("invalid migration file name: %s", f)
+		}
+		version, _ := strconv.Atoi(m[1])
+
+		b, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(b)
+			for _, r := range migrationRequires.FindAllStringSubmatch(mig.Up, -1) {
+				mig.Requires = append(mig.Requires, r[1])
+			}
+		} else {
+			mig.Down = string(b)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" || mig.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s must have both up and down files", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies and reverts migrations, recording applied versions in schema_migrations.
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator creates a Migrator for the migrations embedded in the db package.
+func NewMigrator(sqlDB *sql.DB) (*Migrator, error) {
+	fsys, err := fs.Sub(db.Migrations, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := LoadMigrations(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: sqlDB, migrations: migrations}, nil
+}
+
+func (m *Migrator) ensureTable(ctx context.Context) error {
+	_, err := m.db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL
+		)`)
+	return err
+}
+
+// applied returns the time each applied version was applied at.
+func (m *Migrator) applied(ctx context.Context) (map[int]time.Time, error) {
+	if err := m.ensureTable(ctx); err != nil {
+		return nil, err
+	}
+
+	rows, err := m.db.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version], _ = time.Parse(time.RFC3339, appliedAt)
+	}
+	return applied, rows.Err()
+}
+
+// Status returns every known migration along with whether it has been applied.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		at, ok := applied[mig.Version]
+		statuses = append(statuses, MigrationStatus{Migration: mig, Applied: ok, AppliedAt: at})
+	}
+	return statuses, nil
+}
+
+// Pending returns the migrations Up would apply: those not applied yet whose requirements the linked SQLite meets.
+func (m *Migrator) Pending(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		missing, err := m.missingRequirement(ctx, mig)
+		if err != nil {
+			return nil, err
+		}
+		if missing == "" {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in version order and returns the ones it applied.
+// Each migration runs in its own transaction, so a failure leaves earlier ones applied.
+// Migrations whose requirements the linked SQLite lacks are skipped and stay pending,
+// so they are applied once the server is built with them.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, mig := range m.migrations {
+		if _, ok := applied[mig.Version]; ok {
+			continue
+		}
+		missing, err := m.missingRequirement(ctx, mig)
+		if err != nil {
+			return done, err
+		}
+		if missing != "" {
+			// the server still starts, but what the migration backs is degraded, e.g. search scans the items without FTS5
+			slog.Error("skipped migration: sqlite3 is built without a required option",
+				"version", mig.Version, "name", mig.Name, "option", missing, "build_tag", sqliteBuildTags[missing])
+			continue
+		}
+		err = m.run(ctx, mig.Up, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)",
+				mig.Version, mig.Name, time.Now().UTC().Format(time.RFC3339))
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("failed to apply migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		slog.Info("applied migration", "version", mig.Version, "name", mig.Name)
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// Down reverts up to steps applied migrations, newest first, and returns the ones it reverted.
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		mig := m.migrations[i]
+		if _, ok := applied[mig.Version]; !ok {
+			continue
+		}
+		err := m.run(ctx, mig.Down, func(tx *sql.Tx) error {
+			_, err := tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", mig.Version)
+			return err
+		})
+		if err != nil {
+			return done, fmt.Errorf("failed to revert migration %d_%s: %w", mig.Version, mig.Name, err)
+		}
+		slog.Info("reverted migration", "version", mig.Version, "name", mig.Name)
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// missingRequirement returns the first compile option mig requires that the linked SQLite lacks, or "".
+func (m *Migrator) missingRequirement(ctx context.Context, mig Migration) (string, error) {
+	for _, opt := range mig.Requires {
+		var used bool
+		if err := m.db.QueryRowContext(ctx, "SELECT sqlite_compileoption_used(?)", opt).Scan(&used); err != nil {
+			return "", err
+		}
+		if !used {
+			return opt, nil
+		}
+	}
+	return "", nil
+}
+
+// run executes script and record in a single transaction.
+func (m *Migrator) run(ctx context.Context, script string, record func(tx *sql.Tx) error) error {
+	tx, err := m.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		return err
+	}
+	if err := record(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
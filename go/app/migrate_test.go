@@ -0,0 +1,192 @@
+package app
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		files    fstest.MapFS
+		versions []int
+		requires [][]string
+		err      bool
+	}{
+		"ok: sorted by version": {
+			files: fstest.MapFS{
+				"0002_b.up.sql":   {Data: []byte("b up")},
+				"0002_b.down.sql": {Data: []byte("b down")},
+				"0001_a.up.sql":   {Data: []byte("a up")},
+				"0001_a.down.sql": {Data: []byte("a down")},
+			},
+			versions: []int{1, 2},
+			requires: [][]string{nil, nil},
+		},
+		"ok: requirements are read from the up file": {
+			files: fstest.MapFS{
+				"0001_a.up.sql":   {Data: []byte("-- requires: ENABLE_FTS5\n-- requires: ENABLE_RTREE\na up")},
+				"0001_a.down.sql": {Data: []byte("-- requires: IGNORED\na down")},
+			},
+			versions: []int{1},
+			requires: [][]string{{"ENABLE_FTS5", "ENABLE_RTREE"}},
+		},
+		"ng: missing down file": {
+			files: fstest.MapFS{
+				"0001_a.up.sql": {Data: []byte("a up")},
+			},
+			err: true,
+		},
+		"ng: invalid file name": {
+			files: fstest.MapFS{
+				"create_items.sql": {Data: []byte("a up")},
+			},
+			err: true,
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := LoadMigrations(tt.files)
+			if err != nil {
+				if !tt.err {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.err {
+				t.Fatal("expected an error, got nil")
+			}
+			if len(got) != len(tt.versions) {
+				t.Fatalf("expected %d migrations, got %d", len(tt.versions), len(got))
+			}
+			for i, v := range tt.versions {
+				if got[i].Version != v {
+					t.Errorf("expected migration %d at index %d, got %d", v, i, got[i].Version)
+				}
+				if diff := cmp.Diff(tt.requires[i], got[i].Requires); diff != "" {
+					t.Errorf("unexpected requirements of migration %d (-want +got):\n%s", v, diff)
+				}
+			}
+		})
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	f, err := os.CreateTemp(t.TempDir(), "*.sqlite3")
+	if err != nil {
+		t.Fatalf("failed to create database file: %v", err)
+	}
+	f.Close()
+
+	db, err := sql.Open("sqlite3", f.Name())
+	if err != nil {
+		t.Fatalf("failed to open database: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	ctx := t.Context()
+
+	// a database created by the former db/items.sql, before migrations were tracked
+	_, err = db.Exec(`
+		CREATE TABLE categories (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL UNIQUE);
+		CREATE TABLE items (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT NOT NULL, category_id INTEGER NOT NULL, image_name TEXT NOT NULL, FOREIGN KEY (category_id) REFERENCES categories(id));
+		INSERT INTO categories (name) VALUES ('phone');
+		INSERT INTO items (name, category_id, image_name) VALUES ('used iPhone 16e', 1, 'test.jpg');
+	`)
+	if err != nil {
+		t.Fatalf("failed to create legacy schema: %v", err)
+	}
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatalf("failed to create migrator: %v", err)
+	}
+
+	// migrations needing options this build of SQLite lacks are skipped
+	supported := map[int]bool{}
+	for _, mig := range migrator.migrations {
+		missing, err := migrator.missingRequirement(ctx, mig)
+		if err != nil {
+			t.Fatalf("failed to check requirements: %v", err)
+		}
+		supported[mig.Version] = missing == ""
+	}
+	var want int
+	for _, ok := range supported {
+		if ok {
+			want++
+		}
+	}
+
+	pending, err := migrator.Pending(ctx)
+	if err != nil {
+		t.Fatalf("failed to get pending migrations: %v", err)
+	}
+	if len(pending) != want {
+		t.Errorf("expected %d migrations to be pending, got %d", want, len(pending))
+	}
+
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("failed to migrate up: %v", err)
+	}
+	if len(applied) != want {
+		t.Errorf("expected %d migrations to be applied, got %d", want, len(applied))
+	}
+
+	item, err := NewItemRepository(db, nil).GetItemById(ctx, "1")
+	if err != nil {
+		t.Fatalf("legacy item did not survive the migration: %v", err)
+	}
+	if item.Name != "used iPhone 16e" || item.Category != "phone" {
+		t.Errorf("unexpected legacy item after migration: %+v", item)
+	}
+
+	applied, err = migrator.Up(ctx)
+	if err != nil {
+		t.Fatalf("failed to migrate up twice: %v", err)
+	}
+	if len(applied) != 0 {
+		t.Errorf("expected no migration on second up, got %d", len(applied))
+	}
+	// the skipped migrations are not pending, as Up cannot apply them
+	if pending, err := migrator.Pending(ctx); err != nil || len(pending) != 0 {
+		t.Errorf("expected no pending migration after up, got %v, %v", pending, err)
+	}
+
+	statuses, err := migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.Applied != supported[s.Version] || s.Applied == s.AppliedAt.IsZero() {
+			t.Errorf("expected migration %d to be applied: %v, got %+v", s.Version, supported[s.Version], s)
+		}
+	}
+
+	reverted, err := migrator.Down(ctx, len(migrator.migrations))
+	if err != nil {
+		t.Fatalf("failed to migrate down: %v", err)
+	}
+	if len(reverted) != want {
+		t.Errorf("expected %d migrations to be reverted, got %d", want, len(reverted))
+	}
+
+	statuses, err = migrator.Status(ctx)
+	if err != nil {
+		t.Fatalf("failed to get status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Errorf("expected migration %d to be pending, got %+v", s.Version, s)
+		}
+	}
+}
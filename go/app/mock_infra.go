@@ -40,19 +40,107 @@ func (m *MockItemRepository) EXPECT() *MockItemRepositoryMockRecorder {
 	return m.recorder
 }
 
-// GetAllItem mocks base method.
-func (m *MockItemRepository) GetAllItem(ctx context.Context) ([]Item, error) {
+// AddImages mocks base method.
+func (m *MockItemRepository) AddImages(ctx context.Context, itemId string, names []string) ([]ItemImage, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetAllItem", ctx)
-	ret0, _ := ret[0].([]Item)
+	ret := m.ctrl.Call(m, "AddImages", ctx, itemId, names)
+	ret0, _ := ret[0].([]ItemImage)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// AddImages indicates an expected call of AddImages.
+func (mr *MockItemRepositoryMockRecorder) AddImages(ctx, itemId, names any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddImages", reflect.TypeOf((*MockItemRepository)(nil).AddImages), ctx, itemId, names)
+}
+
+// CancelTrade mocks base method.
+func (m *MockItemRepository) CancelTrade(ctx context.Context, order Order) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CancelTrade", ctx, order)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CancelTrade indicates an expected call of CancelTrade.
+func (mr *MockItemRepositoryMockRecorder) CancelTrade(ctx, order any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CancelTrade", reflect.TypeOf((*MockItemRepository)(nil).CancelTrade), ctx, order)
+}
+
+// CompleteTrade mocks base method.
+func (m *MockItemRepository) CompleteTrade(ctx context.Context, order Order) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CompleteTrade", ctx, order)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CompleteTrade indicates an expected call of CompleteTrade.
+func (mr *MockItemRepositoryMockRecorder) CompleteTrade(ctx, order any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CompleteTrade", reflect.TypeOf((*MockItemRepository)(nil).CompleteTrade), ctx, order)
+}
+
+// CountItemFacets mocks base method.
+func (m *MockItemRepository) CountItemFacets(ctx context.Context, query SearchQuery, filter ItemFilter) (ItemFacets, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CountItemFacets", ctx, query, filter)
+	ret0, _ := ret[0].(ItemFacets)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CountItemFacets indicates an expected call of CountItemFacets.
+func (mr *MockItemRepositoryMockRecorder) CountItemFacets(ctx, query, filter any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CountItemFacets", reflect.TypeOf((*MockItemRepository)(nil).CountItemFacets), ctx, query, filter)
+}
+
+// Delete mocks base method.
+func (m *MockItemRepository) Delete(ctx context.Context, itemId string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Delete", ctx, itemId)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Delete indicates an expected call of Delete.
+func (mr *MockItemRepositoryMockRecorder) Delete(ctx, itemId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Delete", reflect.TypeOf((*MockItemRepository)(nil).Delete), ctx, itemId)
+}
+
+// DeleteImage mocks base method.
+func (m *MockItemRepository) DeleteImage(ctx context.Context, itemId string, imageID int) ([]ItemImage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteImage", ctx, itemId, imageID)
+	ret0, _ := ret[0].([]ItemImage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
+// DeleteImage indicates an expected call of DeleteImage.
+func (mr *MockItemRepositoryMockRecorder) DeleteImage(ctx, itemId, imageID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteImage", reflect.TypeOf((*MockItemRepository)(nil).DeleteImage), ctx, itemId, imageID)
+}
+
+// GetAllItem mocks base method.
+func (m *MockItemRepository) GetAllItem(ctx context.Context, opts ListItemsOptions) ([]Item, *ItemCursor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetAllItem", ctx, opts)
+	ret0, _ := ret[0].([]Item)
+	ret1, _ := ret[1].(*ItemCursor)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
 // GetAllItem indicates an expected call of GetAllItem.
-func (mr *MockItemRepositoryMockRecorder) GetAllItem(ctx any) *gomock.Call {
+func (mr *MockItemRepositoryMockRecorder) GetAllItem(ctx, opts any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllItem", reflect.TypeOf((*MockItemRepository)(nil).GetAllItem), ctx)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetAllItem", reflect.TypeOf((*MockItemRepository)(nil).GetAllItem), ctx, opts)
 }
 
 // GetItemById mocks base method.
@@ -70,6 +158,21 @@ func (mr *MockItemRepositoryMockRecorder) GetItemById(ctx, itemId any) *gomock.C
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetItemById", reflect.TypeOf((*MockItemRepository)(nil).GetItemById), ctx, itemId)
 }
 
+// GetTradeOrder mocks base method.
+func (m *MockItemRepository) GetTradeOrder(ctx context.Context, itemId string) (Order, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetTradeOrder", ctx, itemId)
+	ret0, _ := ret[0].(Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetTradeOrder indicates an expected call of GetTradeOrder.
+func (mr *MockItemRepositoryMockRecorder) GetTradeOrder(ctx, itemId any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetTradeOrder", reflect.TypeOf((*MockItemRepository)(nil).GetTradeOrder), ctx, itemId)
+}
+
 // Insert mocks base method.
 func (m *MockItemRepository) Insert(ctx context.Context, item *Item) error {
 	m.ctrl.T.Helper()
@@ -84,17 +187,63 @@ func (mr *MockItemRepositoryMockRecorder) Insert(ctx, item any) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Insert", reflect.TypeOf((*MockItemRepository)(nil).Insert), ctx, item)
 }
 
-// SearchItemsByKeyword mocks base method.
-func (m *MockItemRepository) SearchItemsByKeyword(ctx context.Context, keyword string) ([]Item, error) {
+// Purchase mocks base method.
+func (m *MockItemRepository) Purchase(ctx context.Context, itemId string, buyerID int) (Order, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "SearchItemsByKeyword", ctx, keyword)
-	ret0, _ := ret[0].([]Item)
+	ret := m.ctrl.Call(m, "Purchase", ctx, itemId, buyerID)
+	ret0, _ := ret[0].(Order)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Purchase indicates an expected call of Purchase.
+func (mr *MockItemRepositoryMockRecorder) Purchase(ctx, itemId, buyerID any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Purchase", reflect.TypeOf((*MockItemRepository)(nil).Purchase), ctx, itemId, buyerID)
+}
+
+// ReorderImages mocks base method.
+func (m *MockItemRepository) ReorderImages(ctx context.Context, itemId string, imageIDs []int) ([]ItemImage, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ReorderImages", ctx, itemId, imageIDs)
+	ret0, _ := ret[0].([]ItemImage)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
+// ReorderImages indicates an expected call of ReorderImages.
+func (mr *MockItemRepositoryMockRecorder) ReorderImages(ctx, itemId, imageIDs any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ReorderImages", reflect.TypeOf((*MockItemRepository)(nil).ReorderImages), ctx, itemId, imageIDs)
+}
+
+// SearchItemsByKeyword mocks base method.
+func (m *MockItemRepository) SearchItemsByKeyword(ctx context.Context, opts SearchItemsOptions) ([]ItemSearchHit, *SearchCursor, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SearchItemsByKeyword", ctx, opts)
+	ret0, _ := ret[0].([]ItemSearchHit)
+	ret1, _ := ret[1].(*SearchCursor)
+	ret2, _ := ret[2].(error)
+	return ret0, ret1, ret2
+}
+
 // SearchItemsByKeyword indicates an expected call of SearchItemsByKeyword.
-func (mr *MockItemRepositoryMockRecorder) SearchItemsByKeyword(ctx, keyword any) *gomock.Call {
+func (mr *MockItemRepositoryMockRecorder) SearchItemsByKeyword(ctx, opts any) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchItemsByKeyword", reflect.TypeOf((*MockItemRepository)(nil).SearchItemsByKeyword), ctx, opts)
+}
+
+// Update mocks base method.
+func (m *MockItemRepository) Update(ctx context.Context, itemId string, update ItemUpdate) (Item, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Update", ctx, itemId, update)
+	ret0, _ := ret[0].(Item)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Update indicates an expected call of Update.
+func (mr *MockItemRepositoryMockRecorder) Update(ctx, itemId, update any) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SearchItemsByKeyword", reflect.TypeOf((*MockItemRepository)(nil).SearchItemsByKeyword), ctx, keyword)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Update", reflect.TypeOf((*MockItemRepository)(nil).Update), ctx, itemId, update)
 }
@@ -0,0 +1,75 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// SearchQuery is a parsed search keyword.
+// Every clause must match, and a clause matches when any of its terms does.
+// A term matches items whose name, category or description contains it, ignoring case.
+type SearchQuery [][]string
+
+// maxSearchTerms bounds the number of terms in a query so that a single search stays cheap.
+const maxSearchTerms = 16
+
+// parseSearchQuery parses the keyword of GET /search.
+//
+// Terms separated by spaces must all match, and "OR" between two terms makes either of them match.
+// Double quotes make a phrase of several words. Since terms match anywhere in a word,
+// a trailing "*" is accepted for prefix searches but not needed.
+func parseSearchQuery(s string) (SearchQuery, error) {
+	var q SearchQuery
+	var n int
+	or := false
+
+	for s = strings.TrimLeftFunc(s, unicode.IsSpace); s != ""; s = strings.TrimLeftFunc(s, unicode.IsSpace) {
+		var term string
+		if s[0] == '"' {
+			// an unterminated phrase runs to the end of the keyword
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				term, s = s[1:], ""
+			} else {
+				term, s = s[1:end+1], s[end+2:]
+			}
+			term = strings.TrimSpace(term)
+		} else {
+			end := strings.IndexFunc(s, unicode.IsSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			term, s = s[:end], s[end:]
+
+			switch term {
+			case "OR":
+				or = len(q) > 0
+				continue
+			case "AND":
+				continue
+			}
+		}
+		term = strings.TrimRight(term, "*")
+		s = strings.TrimLeft(s, "*")
+		if term == "" {
+			continue
+		}
+
+		n++
+		if n > maxSearchTerms {
+			return nil, fieldError(ErrValidation, "keyword", fmt.Sprintf("keyword must have at most %d terms", maxSearchTerms))
+		}
+		if or {
+			q[len(q)-1] = append(q[len(q)-1], term)
+		} else {
+			q = append(q, []string{term})
+		}
+		or = false
+	}
+
+	if len(q) == 0 {
+		return nil, fieldError(ErrInvalidRequest, "keyword", "keyword must have at least one search term")
+	}
+	return q, nil
+}
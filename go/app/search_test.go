@@ -0,0 +1,83 @@
+package app
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		query SearchQuery
+		kind  error
+	}
+	cases := map[string]struct {
+		keyword string
+		wants
+	}{
+		"ok: single term": {
+			keyword: "iPhone",
+			wants:   wants{query: SearchQuery{{"iPhone"}}},
+		},
+		"ok: terms are combined with AND": {
+			keyword: "iPhone  case AND used",
+			wants:   wants{query: SearchQuery{{"iPhone"}, {"case"}, {"used"}}},
+		},
+		"ok: OR joins adjacent terms": {
+			keyword: "iPhone OR Android case",
+			wants:   wants{query: SearchQuery{{"iPhone", "Android"}, {"case"}}},
+		},
+		"ok: phrase": {
+			keyword: `"used iPhone" case`,
+			wants:   wants{query: SearchQuery{{"used iPhone"}, {"case"}}},
+		},
+		"ok: unterminated phrase": {
+			keyword: `case "used iPhone`,
+			wants:   wants{query: SearchQuery{{"case"}, {"used iPhone"}}},
+		},
+		"ok: prefix": {
+			keyword: `iPho* "used iPh"*`,
+			wants:   wants{query: SearchQuery{{"iPho"}, {"used iPh"}}},
+		},
+		"ok: japanese with full-width space": {
+			keyword: "中古　スマホ",
+			wants:   wants{query: SearchQuery{{"中古"}, {"スマホ"}}},
+		},
+		"ok: dangling OR is ignored": {
+			keyword: "OR iPhone OR",
+			wants:   wants{query: SearchQuery{{"iPhone"}}},
+		},
+		"ng: no term": {
+			keyword: `* "" OR`,
+			wants:   wants{kind: ErrInvalidRequest},
+		},
+		"ng: too many terms": {
+			keyword: strings.Repeat("a ", maxSearchTerms+1),
+			wants:   wants{kind: ErrValidation},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := parseSearchQuery(tt.keyword)
+			if err != nil {
+				if tt.kind == nil || !errors.Is(err, tt.kind) {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if tt.kind != nil {
+				t.Fatalf("expected an error of kind %v, got nil", tt.kind)
+			}
+			if diff := cmp.Diff(tt.query, got); diff != "" {
+				t.Errorf("unexpected query (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
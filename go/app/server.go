@@ -1,83 +1,293 @@
 package app
 
 import (
-	"crypto/sha256"
+	"bytes"
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/crypto/bcrypt"
 )
 
 type Server struct {
-	// Port is the port number to listen on.
-	Port string
-	// ImageDirPath is the path to the directory storing images.
-	ImageDirPath string
-	DBPath       string
+	// Config is the configuration of the server, usually from LoadConfig.
+	Config *Config
 }
 
+// corsMethods are the methods browsers may call the API with: those of the routes, and OPTIONS for preflights.
+var corsMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
 // Run is a method to start the server.
-// This method returns 0 if the server started successfully, and 1 otherwise.
+// It serves until the process receives SIGINT or SIGTERM and then shuts the server down gracefully.
+// This method returns 0 if the server ran and shut down successfully, and 1 otherwise.
 func (s Server) Run() int {
+	cfg := s.config()
+	if err := cfg.Validate(); err != nil {
+		slog.Error("invalid configuration", "error", err)
+		return 1
+	}
+
 	// set up logger
-	logger := slog.New(slog.NewJSONHandler(os.Stderr, nil))
-	slog.SetDefault(logger)
-	// STEP 4-6: set the log level to DEBUG
-	slog.SetLogLoggerLevel(slog.LevelInfo)
+	// STEP 4-6: set the log level to DEBUG with -log-level debug
+	logOptions := &slog.HandlerOptions{Level: cfg.Log.Level}
+	var logHandler slog.Handler = slog.NewJSONHandler(os.Stderr, logOptions)
+	if cfg.Log.Format == "text" {
+		logHandler = slog.NewTextHandler(os.Stderr, logOptions)
+	}
+	slog.SetDefault(slog.New(newContextHandler(logHandler)))
+
+	// Docker stops containers with SIGTERM, and Ctrl+C sends SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	ln, err := net.Listen("tcp", ":"+cfg.Port)
+	if err != nil {
+		slog.Error("failed to start server: ", "error", err)
+		return 1
+	}
+	if err := s.Serve(ctx, ln); err != nil {
+		slog.Error("server failed", "error", err)
+		return 1
+	}
+	return 0
+}
 
-	// set up CORS settings
-	frontURL, found := os.LookupEnv("FRONT_URL")
-	if !found {
-		frontURL = "http://localhost:3000"
+// config returns the configuration of the server, or the default one when it has none.
+func (s Server) config() *Config {
+	if s.Config == nil {
+		return DefaultConfig()
+	}
+	return s.Config
+}
+
+// Serve serves the API on ln until ctx is done, and then shuts down in order: it stops accepting
+// connections and waits for the requests in flight for at most the shutdown timeout, stops the
+// background workers, and closes the database. It closes ln.
+//
+// Serve returns nil after a graceful shutdown, and an error when the server could not start,
+// failed while serving, or had to drop requests to shut down.
+func (s Server) Serve(ctx context.Context, ln net.Listener) (err error) {
+	defer ln.Close()
+	cfg := s.config()
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+
+	// set up the secret signing authentication tokens
+	authSecret := cfg.Auth.Secret
+	if authSecret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return fmt.Errorf("failed to generate auth secret: %w", err)
+		}
+		authSecret = string(b)
+		slog.Warn("AUTH_SECRET is not set; issued tokens become invalid when the server restarts")
 	}
 
 	// STEP 5-1: set up the database connection
-	db, err := sql.Open("sqlite3", s.DBPath)
+	db, err := sql.Open("sqlite3", cfg.DBPath)
 	if err != nil {
-		slog.Error("failed to connect to database", "error", err)
-		return 1
+		return fmt.Errorf("failed to connect to database: %w", err)
+	}
+	// the database is closed last, once nothing uses it any more
+	defer func() {
+		if closeErr := db.Close(); closeErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to close database: %w", closeErr))
+		}
+	}()
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if _, err := migrator.Up(ctx); err != nil {
+		return fmt.Errorf("failed to migrate database: %w", err)
 	}
-	defer db.Close()
 
-	if err := initDB(db); err != nil {
-		slog.Error("failed to initialize database", "error", err)
-		return 1
+	images, err := NewImageStore(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to set up image store: %w", err)
+	}
+
+	// background workers stop after the HTTP server and before the database is closed
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var wg sync.WaitGroup
+	defer func() {
+		stopWorkers()
+		wg.Wait()
+	}()
+	if cfg.Images.GCInterval > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runImageGC(workers, db, images, cfg.Images.GCInterval, ImageGCOptions{GracePeriod: cfg.Images.GCGracePeriod})
+		}()
 	}
 
+	tracerProvider, shutdownTracing, err := NewTracerProvider(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	// spans are flushed once the requests are drained
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(flushCtx); err != nil {
+			slog.Warn("failed to flush trace spans", "error", err)
+		}
+	}()
+
 	// set up handlers
-	itemRepo := NewItemRepository(db)
-	h := &Handlers{imgDirPath: s.ImageDirPath, itemRepo: itemRepo}
+	metrics := NewMetrics()
+	itemRepo := NewItemRepository(db, metrics)
+	userRepo := NewUserRepository(db)
+	categoryRepo := NewCategoryRepository(db)
+	auth := NewAuthenticator([]byte(authSecret), cfg.Auth.TokenTTL)
+	h := &Handlers{
+		images:           metrics.instrumentImageStore(images),
+		itemRepo:         itemRepo,
+		userRepo:         userRepo,
+		categoryRepo:     categoryRepo,
+		auth:             auth,
+		strictCategories: cfg.StrictCategories,
+		maxImageSize:     cfg.Images.MaxSize,
+		metrics:          metrics,
+	}
 
 	// set up routes
+	probes := &health{db: db, migrator: migrator, images: images}
 	mux := http.NewServeMux()
 	mux.HandleFunc("GET /", h.Hello)
+	mux.HandleFunc("GET /healthz", probes.Healthz)
+	mux.HandleFunc("GET /readyz", probes.Readyz)
+	mux.HandleFunc("GET /version", probes.Version)
+	mux.Handle("GET /metrics", metrics.Handler())
 	mux.HandleFunc("POST /items", h.AddItem)
 	mux.HandleFunc("GET /items", h.GetAllItem)
 	mux.HandleFunc("GET /items/{item_id}", h.GetItemById)
+	mux.HandleFunc("PATCH /items/{item_id}", h.UpdateItem)
+	mux.HandleFunc("DELETE /items/{item_id}", h.DeleteItem)
+	mux.HandleFunc("POST /items/{item_id}/purchase", h.PurchaseItem)
+	mux.HandleFunc("POST /items/{item_id}/complete", h.CompleteTrade)
+	mux.HandleFunc("POST /items/{item_id}/cancel", h.CancelTrade)
+	mux.HandleFunc("POST /items/{item_id}/images", h.AddItemImages)
+	mux.HandleFunc("DELETE /items/{item_id}/images/{image_id}", h.DeleteItemImage)
+	mux.HandleFunc("PUT /items/{item_id}/images/order", h.ReorderItemImages)
 	mux.HandleFunc("GET /images/{filename}", h.GetImage)
 	mux.HandleFunc("GET /search", h.SearchItemsByKeyword)
+	mux.HandleFunc("GET /categories", h.ListCategories)
+	mux.HandleFunc("POST /categories", h.AddCategory)
+	mux.HandleFunc("PATCH /categories/{category_id}", h.UpdateCategory)
+	mux.HandleFunc("DELETE /categories/{category_id}", h.DeleteCategory)
+	mux.HandleFunc("GET /categories/{category_id}/items", h.GetCategoryItems)
+	mux.HandleFunc("POST /categories/{category_id}/merge", h.MergeCategory)
+	mux.HandleFunc("POST /users", h.RegisterUser)
+	mux.HandleFunc("POST /login", h.Login)
 
 	// start the server
-	slog.Info("http server started on", "port", s.Port)
-	err = http.ListenAndServe(":"+s.Port, simpleCORSMiddleware(simpleLoggerMiddleware(mux), frontURL, []string{"GET", "HEAD", "POST", "OPTIONS"}))
-	if err != nil {
-		slog.Error("failed to start server: ", "error", err)
-		return 1
+	// the middlewares are listed from the innermost; the outer ones see every request, preflights included
+	var handler http.Handler = authMiddleware(mux, auth)
+	handler = simpleLoggerMiddleware(handler)
+	handler = simpleCORSMiddleware(handler, cfg.CORS.Origins, corsMethods)
+	handler = requestIDMiddleware(handler)
+	handler = tracingMiddleware(handler, mux, tracerProvider)
+	handler = metricsMiddleware(handler, mux, metrics)
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: cfg.Timeouts.ReadHeader,
+		ReadTimeout:       cfg.Timeouts.Read,
+		WriteTimeout:      cfg.Timeouts.Write,
+		IdleTimeout:       cfg.Timeouts.Idle,
 	}
+	served := make(chan error, 1)
+	go func() {
+		served <- srv.Serve(ln)
+	}()
+	slog.Info("http server started on", "addr", ln.Addr().String())
 
-	return 0
+	select {
+	case err := <-served:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down the http server", "delay", cfg.Timeouts.ShutdownDelay, "timeout", cfg.Timeouts.Shutdown)
+	probes.shuttingDown.Store(true)
+	if cfg.Timeouts.ShutdownDelay > 0 {
+		// keep serving until the load balancers have seen /readyz fail and stopped sending requests
+		delay := time.NewTimer(cfg.Timeouts.ShutdownDelay)
+		defer delay.Stop()
+		select {
+		case err := <-served:
+			return fmt.Errorf("failed to serve: %w", err)
+		case <-delay.C:
+		}
+	}
+	shutdownCtx := context.Background()
+	if cfg.Timeouts.Shutdown > 0 {
+		var cancel context.CancelFunc
+		shutdownCtx, cancel = context.WithTimeout(shutdownCtx, cfg.Timeouts.Shutdown)
+		defer cancel()
+	}
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// drop the requests still in flight, so that the database is not closed under them
+		srv.Close()
+		return fmt.Errorf("failed to drain connections: %w", err)
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve: %w", err)
+	}
+	slog.Info("http server stopped")
+	return nil
 }
 
 type Handlers struct {
-	// imgDirPath is the path to the directory storing images.
-	imgDirPath string
-	itemRepo   ItemRepository
+	// images stores the item images.
+	images       ImageStore
+	itemRepo     ItemRepository
+	userRepo     UserRepository
+	categoryRepo CategoryRepository
+	auth         *Authenticator
+	// strictCategories rejects items in categories that were not created through POST /categories.
+	strictCategories bool
+	// maxImageSize is the size limit of an uploaded image in bytes, or 0 for defaultMaxImageSize.
+	maxImageSize int64
+	// metrics records the sizes of the uploaded images, nil to not record them.
+	metrics *Metrics
+}
+
+// imageSizeLimit returns the size limit of an uploaded image in bytes.
+func (s *Handlers) imageSizeLimit() int64 {
+	if s.maxImageSize > 0 {
+		return s.maxImageSize
+	}
+	return defaultMaxImageSize
+}
+
+// limitUploadBody caps the body of a request uploading images, so that no upload can fill the disk.
+func (s *Handlers) limitUploadBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, uploadBodyLimit(s.imageSizeLimit()))
 }
 
 type HelloResponse struct {
@@ -87,18 +297,20 @@ type HelloResponse struct {
 // Hello is a handler to return a Hello, world! message for GET / .
 func (s *Handlers) Hello(w http.ResponseWriter, r *http.Request) {
 	resp := HelloResponse{Message: "Hello, world!"}
-	err := json.NewEncoder(w).Encode(resp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	writeJSON(w, r, http.StatusOK, resp)
 }
 
 type AddItemRequest struct {
 	Name string `form:"name"`
 	// Category string `form:"category"` // STEP 4-2: add a category field
 	Category string `form:"category"`
-	Image    []byte `form:"image"` // STEP 4-4: add an image field
+	// Images holds one image per image part, in the order they were sent.
+	Images []*imageUpload `form:"image"` // STEP 4-4: add an image field
+	// Price is in the minor unit of Currency.
+	Price       int64     `form:"price"`
+	Currency    string    `form:"currency"`
+	Description string    `form:"description"`
+	Condition   Condition `form:"condition"`
 }
 
 type AddItemResponse struct {
@@ -106,61 +318,134 @@ type AddItemResponse struct {
 }
 
 // parseAddItemRequest parses and validates the request to add an item.
-func parseAddItemRequest(r *http.Request) (*AddItemRequest, error) {
-	err := r.ParseMultipartForm(10 << 20)
-	if err != nil {
-		return nil, errors.New("failed to parse multipart form")
-	}
-
-	imageFile, _, err := r.FormFile("image")
-	if err != nil {
-		return nil, err
-	}
-	defer imageFile.Close()
+// Images larger than maxImageSize bytes are rejected. On success the caller closes the images.
+func parseAddItemRequest(r *http.Request, maxImageSize int64) (req *AddItemRequest, err error) {
+	_, span := startSpan(r.Context(), "parseAddItemRequest")
+	defer func() { endSpan(span, err) }()
 
-	imageData, err := io.ReadAll(imageFile)
+	images, err := parseUploadForm(r, maxImageSize)
 	if err != nil {
 		return nil, err
 	}
+	defer func() {
+		if err != nil {
+			closeUploads(images)
+		}
+	}()
 
-	req := &AddItemRequest{
+	req = &AddItemRequest{
 		Name: r.FormValue("name"),
 		// STEP 4-2: add a category field
-		Category: r.FormValue("category"),
-		Image:    imageData,
+		Category:    r.FormValue("category"),
+		Images:      images,
+		Currency:    defaultCurrency,
+		Description: r.FormValue("description"),
+		Condition:   Condition(r.FormValue("condition")),
 	}
 
 	// validate the request
 	if req.Name == "" {
-		return nil, errors.New("name is required")
+		return nil, fieldError(ErrInvalidRequest, "name", "name is required")
 	}
 
 	// STEP 4-2: validate the category field
 	if req.Category == "" {
-		return nil, errors.New("category is required")
+		return nil, fieldError(ErrInvalidRequest, "category", "category is required")
 	}
 	// STEP 4-4: validate the image field
-	if len(req.Image) == 0 {
-		return nil, errors.New("image is required")
+	if len(req.Images) == 0 {
+		return nil, fieldError(ErrInvalidRequest, "image", "image is required")
+	}
+
+	price := r.FormValue("price")
+	if price == "" {
+		return nil, fieldError(ErrInvalidRequest, "price", "price is required")
+	}
+	if req.Price, err = parsePrice("price", price); err != nil {
+		return nil, err
+	}
+	if currency := r.FormValue("currency"); currency != "" {
+		if err := validateCurrency(currency); err != nil {
+			return nil, err
+		}
+		req.Currency = currency
+	}
+	if err := validateDescription(req.Description); err != nil {
+		return nil, err
+	}
+	if req.Condition == "" {
+		return nil, fieldError(ErrInvalidRequest, "condition", "condition is required")
+	}
+	if !req.Condition.Valid() {
+		return nil, fieldError(ErrValidation, "condition", "condition must be one of new, like-new, good, fair or poor")
 	}
 	return req, nil
 }
 
+// defaultCurrency is the currency of prices given without one.
+const defaultCurrency = "JPY"
+
+// maxDescriptionLength is the maximum number of characters in an item description.
+const maxDescriptionLength = 1000
+
+// parsePrice parses a price in minor units given as the named field.
+func parsePrice(field, s string) (int64, error) {
+	price, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fieldError(ErrInvalidRequest, field, field+" must be an integer")
+	}
+	if price < 0 {
+		return 0, fieldError(ErrValidation, field, field+" must not be negative")
+	}
+	return price, nil
+}
+
+// validateCurrency checks that s looks like an ISO 4217 currency code.
+func validateCurrency(s string) error {
+	if len(s) != 3 || strings.IndexFunc(s, func(r rune) bool { return r < 'A' || r > 'Z' }) >= 0 {
+		return fieldError(ErrValidation, "currency", "currency must be a three-letter uppercase ISO 4217 code")
+	}
+	return nil
+}
+
+// validateDescription checks the length of an item description.
+func validateDescription(s string) error {
+	if utf8.RuneCountInString(s) > maxDescriptionLength {
+		return fieldError(ErrValidation, "description", fmt.Sprintf("description must be at most %d characters", maxDescriptionLength))
+	}
+	return nil
+}
+
 // AddItem is a handler to add a new item for POST /items .
 func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	req, err := parseAddItemRequest(r)
+	seller, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeCreateItem(seller); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.limitUploadBody(w, r)
+	req, err := parseAddItemRequest(r, s.imageSizeLimit())
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
+		return
+	}
+	defer closeUploads(req.Images)
+	if err := s.checkCategory(ctx, req.Category); err != nil {
+		writeError(w, r, err)
 		return
 	}
 
 	// STEP 4-4: uncomment on adding an implementation to store an image
-	fileName, err := s.storeImage(req.Image)
+	images, err := s.storeImages(ctx, req.Images)
 	if err != nil {
-		slog.Error("failed to store image: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
@@ -169,51 +454,128 @@ func (s *Handlers) AddItem(w http.ResponseWriter, r *http.Request) {
 		// STEP 4-2: add a category field
 		Category: req.Category,
 		// STEP 4-4: add an image field
-		Image: fileName,
+		Image:       images[0].Name,
+		Images:      images,
+		SellerID:    seller.ID,
+		Price:       req.Price,
+		Currency:    req.Currency,
+		Description: req.Description,
+		Condition:   req.Condition,
 	}
 	message := fmt.Sprintf("item received: name: %s,category: %s", item.Name, item.Category)
-	slog.Info(message)
+	slog.InfoContext(r.Context(), message)
 
 	// STEP 4-2: add an implementation to store an item
 	err = s.itemRepo.Insert(ctx, item)
 	if err != nil {
-		slog.Error("failed to store item: ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
 	resp := AddItemResponse{Message: message}
-	err = json.NewEncoder(w).Encode(resp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// checkCategory checks that items may be put in the named category.
+// Any name is accepted unless strictCategories is set, in which case the category must already exist.
+func (s *Handlers) checkCategory(ctx context.Context, name string) error {
+	if !s.strictCategories {
+		return nil
 	}
+	_, err := s.categoryRepo.GetByName(ctx, name)
+	if errors.Is(err, errCategoryNotFound) {
+		return fieldError(ErrValidation, "category", "category does not exist")
+	}
+	return err
 }
 
-// storeImage stores an image and returns the file path and an error if any.
-// this method calculates the hash sum of the image as a file name to avoid the duplication of a same file
-// and stores it in the image directory.
-func (s *Handlers) storeImage(image []byte) (filePath string, err error) {
+// storeImage stores an image and returns its file name and an error if any.
+// this method uses the hash sum of the image computed while it was uploaded as a file name to avoid the duplication
+// of a same file and stores it in the image store, with the extension of the format detected from its content,
+// along with its thumbnails. It fails with errUnsupportedImage when image is not an image in an accepted format.
+func (s *Handlers) storeImage(ctx context.Context, image *imageUpload) (fileName string, err error) {
 	// STEP 4-4: add an implementation to store an image
+	s.metrics.observeUpload(image.size)
+	ctx, span := startSpan(ctx, "storeImage", trace.WithAttributes(attribute.Int64("image.size", image.size)))
+	defer func() { endSpan(span, err) }()
+
+	if _, err := image.file.Seek(0, io.SeekStart); err != nil {
+		return "", internalError("failed to read image", err)
+	}
+	format, err := sniffImage(image.file)
+	if err != nil {
+		return "", err
+	}
 
-	hash := sha256.Sum256(image)
-	fileName := fmt.Sprintf("%x.jpg", hash)
+	fileName = fmt.Sprintf("%x%s", image.sum, format.ext)
 
-	filePath = filepath.Join(s.imgDirPath, fileName)
-	if _, err := os.Stat(filePath); err == nil {
+	// reusing a stored image refreshes its modification time, so that CollectImages does not take it
+	// for an old orphan before the item referring to it is written
+	err = s.images.Touch(ctx, fileName)
+	if err == nil {
 		return fileName, nil
 	}
+	if !errors.Is(err, errImageNotFound) {
+		return "", internalError("failed to store image", err)
+	}
 
-	err = StoreImage(filePath, image)
+	if _, err := image.file.Seek(0, io.SeekStart); err != nil {
+		return "", internalError("failed to read image", err)
+	}
+	_, processSpan := startSpan(ctx, "processImage")
+	processed, err := processImage(image.file, format)
+	endSpan(processSpan, err)
 	if err != nil {
 		return "", err
 	}
 
+	// the thumbnails are stored before the original, so that an image whose original exists has all its thumbnails
+	for width, thumbnail := range processed.thumbnails {
+		if err := s.images.Put(ctx, thumbnailKey(fileName, width), bytes.NewReader(thumbnail)); err != nil {
+			return "", internalError("failed to store thumbnail", err)
+		}
+	}
+	err = s.images.Put(ctx, fileName, processed.original)
+	if err != nil {
+		return "", internalError("failed to store image", err)
+	}
+
 	return fileName, nil
 }
 
+// storeImages stores each image with storeImage and returns them as the images of an item, in the same order.
+func (s *Handlers) storeImages(ctx context.Context, images []*imageUpload) ([]ItemImage, error) {
+	stored := make([]ItemImage, len(images))
+	for i, image := range images {
+		fileName, err := s.storeImage(ctx, image)
+		if err != nil {
+			return nil, err
+		}
+		stored[i] = ItemImage{Name: fileName}
+	}
+	return stored, nil
+}
+
+// setImageURLs sets the URLs of the images of item.
+func (s *Handlers) setImageURLs(item *Item) {
+	item.ImageURL = s.images.URL(item.Image)
+	s.setItemImageURLs(item.Images)
+}
+
+// setItemImageURLs sets the URL of each image.
+func (s *Handlers) setItemImageURLs(images []ItemImage) {
+	for i := range images {
+		images[i].URL = s.images.URL(images[i].Name)
+	}
+}
+
+// defaultImage is returned by GET /images/{filename} for images that do not exist.
+const defaultImage = "default.jpg"
+
 type GetImageRequest struct {
 	FileName string // path value
+	// Width asks for the smallest thumbnail at least as wide, or the original when none is. It is 0 for the original.
+	Width int `query:"w"`
 }
 
 // parseGetImageRequest parses and validates the request to get an image.
@@ -224,83 +586,279 @@ func parseGetImageRequest(r *http.Request) (*GetImageRequest, error) {
 
 	// validate the request
 	if req.FileName == "" {
-		return nil, errors.New("filename is required")
+		return nil, fieldError(ErrInvalidRequest, "filename", "filename is required")
+	}
+	// to prevent directory traversal attacks
+	if err := validateImageKey(req.FileName); err != nil {
+		return nil, err
+	}
+	// validate the image suffix
+	if _, ok := imageContentType(req.FileName); !ok {
+		return nil, newError(ErrInvalidRequest, "image path does not end with .jpg, .jpeg, .png, .gif or .webp")
+	}
+
+	if v := r.URL.Query().Get("w"); v != "" {
+		width, err := strconv.Atoi(v)
+		if err != nil || width < 1 {
+			return nil, fieldError(ErrInvalidRequest, "w", "w must be a positive integer")
+		}
+		req.Width = width
 	}
 
 	return req, nil
 }
 
 // GetImage is a handler to return an image for GET /images/{filename} .
-// If the specified image is not found, it returns the default image.
+// With ?w=, it returns a thumbnail at least that wide when there is one.
+// If the specified image is not found, it returns the default image, which clients cache only briefly
+// unlike the stored images themselves.
 func (s *Handlers) GetImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	req, err := parseGetImageRequest(r)
 	if err != nil {
-		slog.Warn("failed to parse get image request: ", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
-	imgPath, err := s.buildImagePath(req.FileName)
-	if err != nil {
-		if !errors.Is(err, errImageNotFound) {
-			slog.Warn("failed to build image path: ", "error", err)
-			http.Error(w, err.Error(), http.StatusBadRequest)
-			return
-		}
-
+	name := req.FileName
+	if width, ok := thumbnailWidth(req.Width); req.Width > 0 && ok {
+		name = thumbnailKey(req.FileName, width)
+	}
+	body, info, err := s.images.Get(ctx, name)
+	if errors.Is(err, errImageNotFound) && name != req.FileName {
+		// images uploaded before thumbnails were generated only have the original
+		name = req.FileName
+		body, info, err = s.images.Get(ctx, name)
+	}
+	if errors.Is(err, errImageNotFound) {
 		// when the image is not found, it returns the default image without an error.
-		slog.Debug("image not found", "filename", imgPath)
-		imgPath = filepath.Join(s.imgDirPath, "default.jpg")
+		slog.DebugContext(r.Context(), "image not found", "filename", name)
+		name = defaultImage
+		body, info, err = s.images.Get(ctx, name)
+	}
+	if err != nil {
+		writeError(w, r, err)
+		return
 	}
+	defer body.Close()
 
-	slog.Info("returned image", "path", imgPath)
-	http.ServeFile(w, r, imgPath)
+	slog.InfoContext(r.Context(), "returned image", "filename", name)
+	serveImage(w, r, name, body, info)
 }
 
-// buildImagePath builds the image path and validates it.
-func (s *Handlers) buildImagePath(imageFileName string) (string, error) {
-	imgPath := filepath.Join(s.imgDirPath, filepath.Clean(imageFileName))
+const (
+	// imageCacheControl lets clients and CDNs keep content-addressed images for a year without revalidating them,
+	// since a different image always gets a different file name.
+	imageCacheControl = "public, max-age=31536000, immutable"
+	// mutableImageCacheControl is for images that may change under the same URL, above all the default image
+	// returned for a missing one, which must not hide the image for long once it exists.
+	mutableImageCacheControl = "public, max-age=300"
+)
 
-	// to prevent directory traversal attacks
-	rel, err := filepath.Rel(s.imgDirPath, imgPath)
-	if err != nil || strings.HasPrefix(rel, "..") {
-		return "", fmt.Errorf("invalid image path: %s", imgPath)
+// serveImage writes an image read from an ImageStore along with its cache headers.
+// Content-addressed images get a strong ETag derived from their hash; others are revalidated by modification time.
+// Seekable images, such as the files of a local store, also get range requests served.
+func serveImage(w http.ResponseWriter, r *http.Request, name string, body io.Reader, info ImageInfo) {
+	header := w.Header()
+	etag := ""
+	if contentAddressed(name) {
+		etag = `"` + strings.TrimSuffix(name, path.Ext(name)) + `"`
+		header.Set("ETag", etag)
+		header.Set("Cache-Control", imageCacheControl)
+	} else {
+		header.Set("Cache-Control", mutableImageCacheControl)
+	}
+	if !info.ModTime.IsZero() {
+		header.Set("Last-Modified", info.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if notModified(r, etag, info.ModTime) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
 
-	// validate the image suffix
-	if !strings.HasSuffix(imgPath, ".jpg") && !strings.HasSuffix(imgPath, ".jpeg") {
-		return "", fmt.Errorf("image path does not end with .jpg or .jpeg: %s", imgPath)
+	// the content type comes from the extension, which storeImage derived from the content
+	if contentType, ok := imageContentType(name); ok {
+		header.Set("Content-Type", contentType)
+	}
+	if rs, ok := body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, info.ModTime, rs)
+		return
 	}
 
-	// check if the image exists
-	_, err = os.Stat(imgPath)
-	if err != nil {
-		return imgPath, errImageNotFound
+	if info.Size >= 0 {
+		header.Set("Content-Length", strconv.FormatInt(info.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		if _, err := io.Copy(w, body); err != nil {
+			slog.WarnContext(r.Context(), "failed to write image", "filename", name, "error", err)
+		}
+	}
+}
+
+// notModified reports whether the conditional headers of a GET or HEAD request allow answering
+// 304 Not Modified for an image with the given ETag, which is empty when it has none, and modification time.
+// As RFC 9110 requires, If-Modified-Since is ignored when If-None-Match is present.
+func notModified(r *http.Request, etag string, modTime time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" {
+				return true
+			}
+			// If-None-Match compares weakly
+			if candidate != "" && etag != "" && strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modTime.IsZero() {
+		return false
 	}
+	return !modTime.Truncate(time.Second).After(since)
+}
+
+const (
+	// defaultItemsLimit is the page size of GET /items when limit is not given.
+	defaultItemsLimit = 50
+	// maxItemsLimit is the largest page size a client may ask for.
+	maxItemsLimit = 100
+)
 
-	return imgPath, nil
+type GetAllItemRequest struct {
+	SellerID int         `query:"seller_id"`
+	Limit    int         `query:"limit"`
+	Cursor   *ItemCursor `query:"cursor"`
+	Sort     ItemSortKey `query:"sort"`
+	Order    SortOrder   `query:"order"`
 }
 
 type GetAllItemResponse struct {
 	Items []Item `json:"items"`
+	// NextCursor is passed as cursor to fetch the next page. It is omitted on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// parseGetAllItemRequest parses and validates the request to list items.
+// When a cursor is given, sort and order default to the ones the cursor was issued for.
+func parseGetAllItemRequest(r *http.Request) (*GetAllItemRequest, error) {
+	q := r.URL.Query()
+
+	req := &GetAllItemRequest{
+		Limit: defaultItemsLimit,
+		Sort:  ItemSortKey(q.Get("sort")),
+		Order: SortOrder(q.Get("order")),
+	}
+
+	if v := q.Get("seller_id"); v != "" {
+		sellerID, err := strconv.Atoi(v)
+		if err != nil || sellerID < 1 {
+			return nil, fieldError(ErrInvalidRequest, "seller_id", "seller_id must be a positive integer")
+		}
+		req.SellerID = sellerID
+	}
+
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxItemsLimit {
+			return nil, fieldError(ErrInvalidRequest, "limit", fmt.Sprintf("limit must be an integer between 1 and %d", maxItemsLimit))
+		}
+		req.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor := &ItemCursor{}
+		if err := decodeCursor(v, cursor); err != nil {
+			return nil, err
+		}
+		if req.Sort == "" {
+			req.Sort = cursor.Sort
+		}
+		if req.Order == "" {
+			req.Order = cursor.Order
+		}
+		if cursor.Sort != req.Sort || cursor.Order != req.Order {
+			return nil, fieldError(ErrInvalidRequest, "cursor", "cursor was issued for a different sort or order")
+		}
+		req.Cursor = cursor
+	}
+
+	if req.Sort == "" {
+		req.Sort = ItemSortCreatedAt
+	}
+	if _, ok := itemSortColumns[req.Sort]; !ok {
+		return nil, fieldError(ErrInvalidRequest, "sort", "sort must be one of created_at, name or id")
+	}
+
+	if req.Order == "" {
+		req.Order = SortAsc
+	}
+	if req.Order != SortAsc && req.Order != SortDesc {
+		return nil, fieldError(ErrInvalidRequest, "order", "order must be asc or desc")
+	}
+
+	return req, nil
+}
+
+// encodeCursor turns a cursor into the opaque string handed to clients.
+func encodeCursor(c any) (string, error) {
+	b, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor parses a cursor produced by encodeCursor into c.
+func decodeCursor(s string, c any) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return fieldError(ErrInvalidRequest, "cursor", "invalid cursor")
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return fieldError(ErrInvalidRequest, "cursor", "invalid cursor")
+	}
+	return nil
 }
 
+// GetAllItem is a handler to return a page of items for GET /items .
 func (s *Handlers) GetAllItem(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 
-	items, err := s.itemRepo.GetAllItem(ctx)
+	req, err := parseGetAllItemRequest(r)
 	if err != nil {
-		slog.Error("failed to get all item : ", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
-	resp := GetAllItemResponse{Items: items}
-	err = json.NewEncoder(w).Encode(resp)
+	items, next, err := s.itemRepo.GetAllItem(ctx, ListItemsOptions{
+		SellerID: req.SellerID,
+		Limit:    req.Limit,
+		Sort:     req.Sort,
+		Order:    req.Order,
+		After:    req.Cursor,
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
+
+	for i := range items {
+		s.setImageURLs(&items[i])
+	}
+	resp := GetAllItemResponse{Items: items}
+	if next != nil {
+		resp.NextCursor, err = encodeCursor(next)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, r, http.StatusOK, resp)
 }
 
 type GetItemByIdRequest struct {
@@ -312,13 +870,24 @@ func parseGetItemByIdRequest(r *http.Request) (*GetItemByIdRequest, error) {
 		ItemId: r.PathValue("item_id"),
 	}
 
-	if req.ItemId == "" {
-		return nil, errors.New("itemId is required")
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
 	}
 
 	return req, nil
 }
 
+// validateItemId checks that an item_id path value is a positive integer.
+func validateItemId(itemId string) error {
+	if itemId == "" {
+		return fieldError(ErrInvalidRequest, "item_id", "item_id is required")
+	}
+	if id, err := strconv.Atoi(itemId); err != nil || id < 1 {
+		return fieldError(ErrInvalidRequest, "item_id", "item_id must be a positive integer")
+	}
+	return nil
+}
+
 type GetItemByIdResponse struct {
 	Items []Item `json:"items"`
 }
@@ -328,48 +897,1289 @@ func (s *Handlers) GetItemById(w http.ResponseWriter, r *http.Request) {
 
 	req, err := parseGetItemByIdRequest(r)
 	if err != nil {
-		slog.Warn("failed to parse get item by id request: ", "error", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, err)
 		return
 	}
 
 	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
 	if err != nil {
-		slog.Error("failed to get item by id:", "error", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, r, err)
 		return
 	}
 
+	s.setImageURLs(&item)
 	resp := GetItemByIdResponse{Items: []Item{item}}
-	err = json.NewEncoder(w).Encode(resp)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type SearchItemsByKeywordRequest struct {
+	Keyword string      `query:"keyword"`
+	Query   SearchQuery // parsed from Keyword, nil when no keyword is given
+	// Filter is parsed from the category, condition, status, seller_id, min_price, max_price,
+	// created_from and created_to parameters. category, condition and status may be repeated.
+	Filter ItemFilter
+	Limit  int           `query:"limit"`
+	Cursor *SearchCursor `query:"cursor"`
 }
 
 type SearchItemsByKeywordResponse struct {
-	Items []Item `json:"items"`
+	Items []ItemSearchHit `json:"items"`
+	// NextCursor is passed as cursor to fetch the next page. It is omitted on the last page.
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Facets counts every matching item, not only those on this page.
+	Facets ItemFacets `json:"facets"`
 }
 
-func (s *Handlers) SearchItemsByKeyword(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
+// parseSearchItemsByKeywordRequest parses and validates the request to search items.
+func parseSearchItemsByKeywordRequest(r *http.Request) (*SearchItemsByKeywordRequest, error) {
+	q := r.URL.Query()
 
-	keyword := r.URL.Query().Get("keyword")
-	if keyword == "" {
-		http.Error(w, "keyword is required", http.StatusBadRequest)
-		return
+	req := &SearchItemsByKeywordRequest{
+		Keyword: q.Get("keyword"),
+		Limit:   defaultItemsLimit,
+	}
+
+	if req.Keyword != "" {
+		query, err := parseSearchQuery(req.Keyword)
+		if err != nil {
+			return nil, err
+		}
+		req.Query = query
 	}
 
-	items, err := s.itemRepo.SearchItemsByKeyword(ctx, keyword)
+	filter, err := parseItemFilter(q)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
+	req.Filter = filter
 
-	resp := SearchItemsByKeywordResponse{Items: items}
-	if err := json.NewEncoder(w).Encode(resp); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+	if v := q.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit < 1 || limit > maxItemsLimit {
+			return nil, fieldError(ErrInvalidRequest, "limit", fmt.Sprintf("limit must be an integer between 1 and %d", maxItemsLimit))
+		}
+		req.Limit = limit
+	}
+
+	if v := q.Get("cursor"); v != "" {
+		cursor := &SearchCursor{}
+		if err := decodeCursor(v, cursor); err != nil {
+			return nil, err
+		}
+		if cursor.Offset < 0 {
+			return nil, fieldError(ErrInvalidRequest, "cursor", "invalid cursor")
+		}
+		req.Cursor = cursor
+	}
+
+	return req, nil
+}
+
+// parseItemFilter parses the filters of GET /search.
+func parseItemFilter(q url.Values) (ItemFilter, error) {
+	var f ItemFilter
+
+	for _, v := range q["category"] {
+		if v == "" {
+			return ItemFilter{}, fieldError(ErrInvalidRequest, "category", "category must not be empty")
+		}
+		f.Categories = append(f.Categories, v)
+	}
+	for _, v := range q["condition"] {
+		c := Condition(v)
+		if !c.Valid() {
+			return ItemFilter{}, fieldError(ErrValidation, "condition", "condition must be one of new, like-new, good, fair or poor")
+		}
+		f.Conditions = append(f.Conditions, c)
+	}
+	for _, v := range q["status"] {
+		s := ItemStatus(v)
+		if !s.Valid() {
+			return ItemFilter{}, fieldError(ErrValidation, "status", "status must be one of on_sale, trading or sold_out")
+		}
+		f.Statuses = append(f.Statuses, s)
+	}
+
+	if v := q.Get("seller_id"); v != "" {
+		sellerID, err := strconv.Atoi(v)
+		if err != nil || sellerID < 1 {
+			return ItemFilter{}, fieldError(ErrInvalidRequest, "seller_id", "seller_id must be a positive integer")
+		}
+		f.SellerID = sellerID
+	}
+
+	if v := q.Get("min_price"); v != "" {
+		price, err := parsePrice("min_price", v)
+		if err != nil {
+			return ItemFilter{}, err
+		}
+		f.MinPrice = &price
+	}
+	if v := q.Get("max_price"); v != "" {
+		price, err := parsePrice("max_price", v)
+		if err != nil {
+			return ItemFilter{}, err
+		}
+		f.MaxPrice = &price
+	}
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice {
+		return ItemFilter{}, fieldError(ErrValidation, "max_price", "max_price must not be less than min_price")
+	}
+
+	if v := q.Get("created_from"); v != "" {
+		t, err := parseTimeBound("created_from", v)
+		if err != nil {
+			return ItemFilter{}, err
+		}
+		f.CreatedFrom = t
+	}
+	if v := q.Get("created_to"); v != "" {
+		t, err := parseTimeBound("created_to", v)
+		if err != nil {
+			return ItemFilter{}, err
+		}
+		f.CreatedTo = t
+	}
+	if !f.CreatedFrom.IsZero() && !f.CreatedTo.IsZero() && !f.CreatedFrom.Before(f.CreatedTo) {
+		return ItemFilter{}, fieldError(ErrValidation, "created_to", "created_to must be after created_from")
+	}
+
+	return f, nil
+}
+
+// parseTimeBound parses the named bound of a date range, given either as an RFC 3339 time
+// or as a date, which stands for the start of the day in UTC.
+func parseTimeBound(field, s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
 	}
+	if t, err := time.Parse(time.DateOnly, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fieldError(ErrInvalidRequest, field, field+" must be an RFC 3339 time or a date like 2006-01-02")
+}
+
+// SearchItemsByKeyword is a handler to return a page of items matching a keyword and filters for GET /search .
+// Every item matches when neither is given.
+func (s *Handlers) SearchItemsByKeyword(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, err := parseSearchItemsByKeywordRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	opts := SearchItemsOptions{Query: req.Query, Filter: req.Filter, Limit: req.Limit}
+	if req.Cursor != nil {
+		opts.Offset = req.Cursor.Offset
+	}
+	hits, next, err := s.itemRepo.SearchItemsByKeyword(ctx, opts)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	facets, err := s.itemRepo.CountItemFacets(ctx, req.Query, req.Filter)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	for i := range hits {
+		s.setImageURLs(&hits[i].Item)
+	}
+	resp := SearchItemsByKeywordResponse{Items: hits, Facets: facets}
+	if next != nil {
+		resp.NextCursor, err = encodeCursor(next)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type UpdateItemRequest struct {
+	ItemId string // path value
+	// Name, Category, Images and the rest are nil when they are not part of the request.
+	// Images replace all the images of the item.
+	Name        *string        `form:"name"`
+	Category    *string        `form:"category"`
+	Images      []*imageUpload `form:"image"`
+	Price       *int64         `form:"price"`
+	Currency    *string        `form:"currency"`
+	Description *string        `form:"description"`
+	Condition   *Condition     `form:"condition"`
+}
+
+type UpdateItemResponse struct {
+	Message string `json:"message"`
+}
+
+// parseUpdateItemRequest parses and validates the request to partially update an item.
+// Only the fields present in the multipart form are updated.
+// Images larger than maxImageSize bytes are rejected. On success the caller closes the images.
+func parseUpdateItemRequest(r *http.Request, maxImageSize int64) (req *UpdateItemRequest, err error) {
+	req = &UpdateItemRequest{
+		ItemId: r.PathValue("item_id"),
+	}
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
+	}
+
+	images, err := parseUploadForm(r, maxImageSize)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			closeUploads(images)
+		}
+	}()
+	req.Images = images
+
+	if values, ok := r.MultipartForm.Value["name"]; ok {
+		if values[0] == "" {
+			return nil, fieldError(ErrValidation, "name", "name must not be empty")
+		}
+		req.Name = &values[0]
+	}
+
+	if values, ok := r.MultipartForm.Value["category"]; ok {
+		if values[0] == "" {
+			return nil, fieldError(ErrValidation, "category", "category must not be empty")
+		}
+		req.Category = &values[0]
+	}
+
+	if values, ok := r.MultipartForm.Value["price"]; ok {
+		price, err := parsePrice("price", values[0])
+		if err != nil {
+			return nil, err
+		}
+		req.Price = &price
+	}
+
+	if values, ok := r.MultipartForm.Value["currency"]; ok {
+		if err := validateCurrency(values[0]); err != nil {
+			return nil, err
+		}
+		req.Currency = &values[0]
+	}
+
+	// an empty description clears it
+	if values, ok := r.MultipartForm.Value["description"]; ok {
+		if err := validateDescription(values[0]); err != nil {
+			return nil, err
+		}
+		req.Description = &values[0]
+	}
+
+	if values, ok := r.MultipartForm.Value["condition"]; ok {
+		condition := Condition(values[0])
+		if !condition.Valid() {
+			return nil, fieldError(ErrValidation, "condition", "condition must be one of new, like-new, good, fair or poor")
+		}
+		req.Condition = &condition
+	}
+
+	if req.Name == nil && req.Category == nil && req.Images == nil &&
+		req.Price == nil && req.Currency == nil && req.Description == nil && req.Condition == nil {
+		return nil, newError(ErrInvalidRequest, "at least one of name, category, image, price, currency, description or condition is required")
+	}
+	return req, nil
+}
+
+// UpdateItem is a handler to partially update an item for PATCH /items/{item_id} .
+func (s *Handlers) UpdateItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.limitUploadBody(w, r)
+	req, err := parseUpdateItemRequest(r, s.imageSizeLimit())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer closeUploads(req.Images)
+
+	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeModifyItem(user, item); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// only the fields of the request are written, so as not to undo the updates made since the item was read
+	update := ItemUpdate{
+		Name:        req.Name,
+		Category:    req.Category,
+		Price:       req.Price,
+		Currency:    req.Currency,
+		Description: req.Description,
+		Condition:   req.Condition,
+	}
+	if req.Category != nil && *req.Category != item.Category {
+		if err := s.checkCategory(ctx, *req.Category); err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	if req.Images != nil {
+		update.Images, err = s.storeImages(ctx, req.Images)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+
+	item, err = s.itemRepo.Update(ctx, req.ItemId, update)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	message := fmt.Sprintf("item updated: name: %s,category: %s", item.Name, item.Category)
+	slog.InfoContext(r.Context(), message)
+
+	resp := UpdateItemResponse{Message: message}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type DeleteItemRequest struct {
+	ItemId string // path value
+}
+
+type DeleteItemResponse struct {
+	Message string `json:"message"`
+}
+
+// parseDeleteItemRequest parses and validates the request to delete an item.
+func parseDeleteItemRequest(r *http.Request) (*DeleteItemRequest, error) {
+	req := &DeleteItemRequest{
+		ItemId: r.PathValue("item_id"),
+	}
+
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// DeleteItem is a handler to delete an item for DELETE /items/{item_id} .
+func (s *Handlers) DeleteItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	req, err := parseDeleteItemRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeModifyItem(user, item); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	err = s.itemRepo.Delete(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	message := fmt.Sprintf("item deleted: id: %s", req.ItemId)
+	slog.InfoContext(r.Context(), message)
+
+	resp := DeleteItemResponse{Message: message}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type PurchaseItemRequest struct {
+	ItemId string // path value
+}
+
+type PurchaseItemResponse struct {
+	Order Order `json:"order"`
+}
+
+// parsePurchaseItemRequest parses and validates the request to purchase an item.
+func parsePurchaseItemRequest(r *http.Request) (*PurchaseItemRequest, error) {
+	req := &PurchaseItemRequest{
+		ItemId: r.PathValue("item_id"),
+	}
+
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// PurchaseItem is a handler to purchase an item for POST /items/{item_id}/purchase .
+func (s *Handlers) PurchaseItem(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	buyer, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	req, err := parsePurchaseItemRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizePurchaseItem(buyer, item); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// the repository checks the status again inside its transaction, so a concurrent purchase still gets a conflict
+	order, err := s.itemRepo.Purchase(ctx, req.ItemId, buyer.ID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "item purchased", "item_id", order.ItemID, "order_id", order.ID, "buyer_id", order.BuyerID)
+
+	resp := PurchaseItemResponse{Order: order}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// TradeRequest is a request to complete or cancel the trade of an item.
+type TradeRequest struct {
+	ItemId string // path value
+}
+
+type TradeResponse struct {
+	Order  Order      `json:"order"`
+	Status ItemStatus `json:"status"`
+}
+
+// parseTradeRequest parses and validates the request to complete or cancel a trade.
+func parseTradeRequest(r *http.Request) (*TradeRequest, error) {
+	req := &TradeRequest{
+		ItemId: r.PathValue("item_id"),
+	}
+
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
+	}
+
+	return req, nil
+}
+
+// CompleteTrade is a handler for the buyer to complete the trade of an item for POST /items/{item_id}/complete .
+func (s *Handlers) CompleteTrade(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	req, err := parseTradeRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	order, err := s.itemRepo.GetTradeOrder(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeCompleteTrade(user, order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// the repository checks the trade again inside its transaction, so a concurrent cancel still gets a conflict
+	if err := s.itemRepo.CompleteTrade(ctx, order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "trade completed", "item_id", order.ItemID, "order_id", order.ID, "user_id", user.ID)
+
+	resp := TradeResponse{Order: order, Status: ItemStatusSoldOut}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// CancelTrade is a handler to cancel the trade of an item and put it back on sale for POST /items/{item_id}/cancel .
+func (s *Handlers) CancelTrade(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	req, err := parseTradeRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	order, err := s.itemRepo.GetTradeOrder(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeCancelTrade(user, item, order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.itemRepo.CancelTrade(ctx, order); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "trade cancelled", "item_id", order.ItemID, "order_id", order.ID, "user_id", user.ID)
+
+	resp := TradeResponse{Order: order, Status: ItemStatusOnSale}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type ItemImagesResponse struct {
+	Images []ItemImage `json:"images"`
+}
+
+type AddItemImagesRequest struct {
+	ItemId string // path value
+	// Images are appended to the images of the item in the order they were sent.
+	Images []*imageUpload `form:"image"`
+}
+
+// parseAddItemImagesRequest parses and validates the request to add images to an item.
+// Images larger than maxImageSize bytes are rejected. On success the caller closes the images.
+func parseAddItemImagesRequest(r *http.Request, maxImageSize int64) (*AddItemImagesRequest, error) {
+	req := &AddItemImagesRequest{
+		ItemId: r.PathValue("item_id"),
+	}
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if req.Images, err = parseUploadForm(r, maxImageSize); err != nil {
+		return nil, err
+	}
+	if len(req.Images) == 0 {
+		return nil, fieldError(ErrInvalidRequest, "image", "image is required")
+	}
+	return req, nil
+}
+
+// AddItemImages is a handler to add images to an item for POST /items/{item_id}/images .
+func (s *Handlers) AddItemImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	s.limitUploadBody(w, r)
+	req, err := parseAddItemImagesRequest(r, s.imageSizeLimit())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	defer closeUploads(req.Images)
+
+	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeModifyItem(user, item); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	stored, err := s.storeImages(ctx, req.Images)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	names := make([]string, len(stored))
+	for i, image := range stored {
+		names[i] = image.Name
+	}
+
+	// the repository counts the images again inside its transaction, so concurrent uploads cannot exceed the limit
+	images, err := s.itemRepo.AddImages(ctx, req.ItemId, names)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "item images added", "item_id", req.ItemId, "count", len(names))
+
+	s.setItemImageURLs(images)
+	resp := ItemImagesResponse{Images: images}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type DeleteItemImageRequest struct {
+	ItemId  string // path value
+	ImageID int    // path value
+}
+
+// parseDeleteItemImageRequest parses and validates the request to remove an image from an item.
+func parseDeleteItemImageRequest(r *http.Request) (*DeleteItemImageRequest, error) {
+	req := &DeleteItemImageRequest{
+		ItemId: r.PathValue("item_id"),
+	}
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
+	}
+
+	id, err := strconv.Atoi(r.PathValue("image_id"))
+	if err != nil || id < 1 {
+		return nil, fieldError(ErrInvalidRequest, "image_id", "image_id must be a positive integer")
+	}
+	req.ImageID = id
+	return req, nil
+}
+
+// DeleteItemImage is a handler to remove an image from an item for DELETE /items/{item_id}/images/{image_id} .
+// The stored image file is kept, since other items may use the same image.
+func (s *Handlers) DeleteItemImage(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	req, err := parseDeleteItemImageRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeModifyItem(user, item); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	images, err := s.itemRepo.DeleteImage(ctx, req.ItemId, req.ImageID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "item image deleted", "item_id", req.ItemId, "image_id", req.ImageID)
+
+	s.setItemImageURLs(images)
+	resp := ItemImagesResponse{Images: images}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type ReorderItemImagesRequest struct {
+	ItemId string // path value
+	// ImageIDs lists the ids of every image of the item in the new order, e.g. image_ids=3,1,2.
+	ImageIDs []int `form:"image_ids"`
+}
+
+// parseReorderItemImagesRequest parses and validates the request to reorder the images of an item.
+// The caller caps the body with limitFormBody.
+func parseReorderItemImagesRequest(r *http.Request) (*ReorderItemImagesRequest, error) {
+	req := &ReorderItemImagesRequest{
+		ItemId: r.PathValue("item_id"),
+	}
+	if err := validateItemId(req.ItemId); err != nil {
+		return nil, err
+	}
+
+	if err := parseFieldsForm(r); err != nil {
+		return nil, err
+	}
+
+	value := r.FormValue("image_ids")
+	if value == "" {
+		return nil, fieldError(ErrInvalidRequest, "image_ids", "image_ids is required")
+	}
+	seen := map[int]bool{}
+	for _, s := range strings.Split(value, ",") {
+		id, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || id < 1 {
+			return nil, fieldError(ErrInvalidRequest, "image_ids", "image_ids must be a comma-separated list of positive integers")
+		}
+		if seen[id] {
+			return nil, errItemImageOrder
+		}
+		seen[id] = true
+		req.ImageIDs = append(req.ImageIDs, id)
+	}
+	return req, nil
+}
+
+// ReorderItemImages is a handler to reorder the images of an item for PUT /items/{item_id}/images/order .
+// The first image becomes the main image of the item.
+func (s *Handlers) ReorderItemImages(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	limitFormBody(w, r)
+	req, err := parseReorderItemImagesRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	item, err := s.itemRepo.GetItemById(ctx, req.ItemId)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeModifyItem(user, item); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	images, err := s.itemRepo.ReorderImages(ctx, req.ItemId, req.ImageIDs)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "item images reordered", "item_id", req.ItemId)
+
+	s.setItemImageURLs(images)
+	resp := ItemImagesResponse{Images: images}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type ListCategoriesResponse struct {
+	Categories []Category `json:"categories"`
+}
+
+// ListCategories is a handler to return every category for GET /categories .
+func (s *Handlers) ListCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := s.categoryRepo.List(r.Context())
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	resp := ListCategoriesResponse{Categories: categories}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type AddCategoryRequest struct {
+	Name string `form:"name"`
+	// ParentID is 0 for a top-level category.
+	ParentID int `form:"parent_id"`
+}
+
+type CategoryResponse struct {
+	Category Category `json:"category"`
+}
+
+// parseAddCategoryRequest parses and validates the request to add a category.
+// The caller caps the body with limitFormBody.
+func parseAddCategoryRequest(r *http.Request) (*AddCategoryRequest, error) {
+	if err := parseFieldsForm(r); err != nil {
+		return nil, err
+	}
+	req := &AddCategoryRequest{
+		Name: strings.TrimSpace(r.FormValue("name")),
+	}
+
+	if req.Name == "" {
+		return nil, fieldError(ErrInvalidRequest, "name", "name is required")
+	}
+	if v := r.FormValue("parent_id"); v != "" {
+		parentID, err := parseCategoryId("parent_id", v)
+		if err != nil {
+			return nil, err
+		}
+		req.ParentID = parentID
+	}
+
+	return req, nil
+}
+
+// parseCategoryId parses a category id given as the named field.
+func parseCategoryId(field, s string) (int, error) {
+	if s == "" {
+		return 0, fieldError(ErrInvalidRequest, field, field+" is required")
+	}
+	id, err := strconv.Atoi(s)
+	if err != nil || id < 1 {
+		return 0, fieldError(ErrInvalidRequest, field, field+" must be a positive integer")
+	}
+	return id, nil
+}
+
+// AddCategory is a handler to create a category for POST /categories .
+func (s *Handlers) AddCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeManageCategories(user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	limitFormBody(w, r)
+	req, err := parseAddCategoryRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	category := &Category{Name: req.Name, ParentID: req.ParentID}
+	if err := s.categoryRepo.Insert(ctx, category); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "category added", "category_id", category.ID, "name", category.Name)
+
+	resp := CategoryResponse{Category: *category}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type UpdateCategoryRequest struct {
+	CategoryID int     // path value
+	Name       *string `form:"name"`
+	// ParentID points to 0 to make the category top-level.
+	ParentID *int `form:"parent_id"`
+}
+
+// parseUpdateCategoryRequest parses and validates the request to rename or move a category.
+// Only the fields present in the form are updated, and an empty parent_id makes the category top-level.
+// The caller caps the body with limitFormBody.
+func parseUpdateCategoryRequest(r *http.Request) (*UpdateCategoryRequest, error) {
+	categoryID, err := parseCategoryId("category_id", r.PathValue("category_id"))
+	if err != nil {
+		return nil, err
+	}
+	req := &UpdateCategoryRequest{CategoryID: categoryID}
+
+	// both URL-encoded and multipart forms are accepted
+	if err := parseFieldsForm(r); err != nil {
+		return nil, err
+	}
+
+	if values, ok := r.PostForm["name"]; ok {
+		name := strings.TrimSpace(values[0])
+		if name == "" {
+			return nil, fieldError(ErrValidation, "name", "name must not be empty")
+		}
+		req.Name = &name
+	}
+
+	if values, ok := r.PostForm["parent_id"]; ok {
+		var parentID int
+		if values[0] != "" {
+			parentID, err = parseCategoryId("parent_id", values[0])
+			if err != nil {
+				return nil, err
+			}
+		}
+		req.ParentID = &parentID
+	}
+
+	if req.Name == nil && req.ParentID == nil {
+		return nil, newError(ErrInvalidRequest, "at least one of name or parent_id is required")
+	}
+	return req, nil
+}
+
+// UpdateCategory is a handler to rename or move a category for PATCH /categories/{category_id} .
+func (s *Handlers) UpdateCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeManageCategories(user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	limitFormBody(w, r)
+	req, err := parseUpdateCategoryRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	category, err := s.categoryRepo.Get(ctx, req.CategoryID)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if req.Name != nil {
+		category.Name = *req.Name
+	}
+	if req.ParentID != nil {
+		category.ParentID = *req.ParentID
+	}
+
+	if err := s.categoryRepo.Update(ctx, &category); err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "category updated", "category_id", category.ID, "name", category.Name)
+
+	resp := CategoryResponse{Category: category}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type DeleteCategoryResponse struct {
+	Message string `json:"message"`
+}
+
+// DeleteCategory is a handler to delete an unused category for DELETE /categories/{category_id} .
+// Categories that still have items or subcategories must be merged into another one instead.
+func (s *Handlers) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeManageCategories(user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	categoryID, err := parseCategoryId("category_id", r.PathValue("category_id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.categoryRepo.Delete(ctx, categoryID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	message := fmt.Sprintf("category deleted: id: %d", categoryID)
+	slog.InfoContext(r.Context(), message)
+
+	resp := DeleteCategoryResponse{Message: message}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+// GetCategoryItems is a handler to return a page of the items in a category and its subcategories
+// for GET /categories/{category_id}/items . It takes the same query parameters as GET /items.
+func (s *Handlers) GetCategoryItems(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	categoryID, err := parseCategoryId("category_id", r.PathValue("category_id"))
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	req, err := parseGetAllItemRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	// an unknown category is a 404 rather than an empty page
+	if _, err := s.categoryRepo.Get(ctx, categoryID); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	items, next, err := s.itemRepo.GetAllItem(ctx, ListItemsOptions{
+		SellerID:   req.SellerID,
+		CategoryID: categoryID,
+		Limit:      req.Limit,
+		Sort:       req.Sort,
+		Order:      req.Order,
+		After:      req.Cursor,
+	})
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	for i := range items {
+		s.setImageURLs(&items[i])
+	}
+	resp := GetAllItemResponse{Items: items}
+	if next != nil {
+		resp.NextCursor, err = encodeCursor(next)
+		if err != nil {
+			writeError(w, r, err)
+			return
+		}
+	}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type MergeCategoryRequest struct {
+	CategoryID int // path value
+	// Into is the category receiving the items and subcategories.
+	Into int `form:"into"`
+}
+
+type MergeCategoryResponse struct {
+	Message string `json:"message"`
+}
+
+// parseMergeCategoryRequest parses and validates the request to merge a category into another one.
+// The caller caps the body with limitFormBody.
+func parseMergeCategoryRequest(r *http.Request) (*MergeCategoryRequest, error) {
+	categoryID, err := parseCategoryId("category_id", r.PathValue("category_id"))
+	if err != nil {
+		return nil, err
+	}
+	if err := parseFieldsForm(r); err != nil {
+		return nil, err
+	}
+	into, err := parseCategoryId("into", r.FormValue("into"))
+	if err != nil {
+		return nil, err
+	}
+
+	return &MergeCategoryRequest{CategoryID: categoryID, Into: into}, nil
+}
+
+// MergeCategory is a handler to move every item and subcategory of a category into another one
+// and delete it for POST /categories/{category_id}/merge .
+func (s *Handlers) MergeCategory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	user, err := authUserFromContext(ctx)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	if err := authorizeManageCategories(user); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	limitFormBody(w, r)
+	req, err := parseMergeCategoryRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	if err := s.categoryRepo.Merge(ctx, req.CategoryID, req.Into); err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	message := fmt.Sprintf("category merged: id: %d, into: %d", req.CategoryID, req.Into)
+	slog.InfoContext(r.Context(), message)
+
+	resp := MergeCategoryResponse{Message: message}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+const (
+	// minPasswordLength is the shortest password accepted on registration.
+	minPasswordLength = 8
+	// maxPasswordLength is the longest password bcrypt can hash.
+	maxPasswordLength = 72
+)
+
+type RegisterUserRequest struct {
+	Name     string `form:"name"`
+	Email    string `form:"email"`
+	Password string `form:"password"`
+	// Role is either buyer or seller. Admins cannot be registered through the API.
+	Role Role `form:"role"`
+}
+
+type RegisterUserResponse struct {
+	User User `json:"user"`
+}
+
+// parseRegisterUserRequest parses and validates the request to register a user.
+func parseRegisterUserRequest(r *http.Request) (*RegisterUserRequest, error) {
+	req := &RegisterUserRequest{
+		Name:     r.FormValue("name"),
+		Email:    strings.ToLower(strings.TrimSpace(r.FormValue("email"))),
+		Password: r.FormValue("password"),
+		Role:     Role(r.FormValue("role")),
+	}
+
+	if req.Role == "" {
+		req.Role = RoleSeller
+	}
+
+	if req.Name == "" {
+		return nil, fieldError(ErrInvalidRequest, "name", "name is required")
+	}
+	if req.Email == "" {
+		return nil, fieldError(ErrInvalidRequest, "email", "email is required")
+	}
+	if req.Password == "" {
+		return nil, fieldError(ErrInvalidRequest, "password", "password is required")
+	}
+
+	if _, err := mail.ParseAddress(req.Email); err != nil {
+		return nil, fieldError(ErrValidation, "email", "email is not a valid address")
+	}
+	if len(req.Password) < minPasswordLength || len(req.Password) > maxPasswordLength {
+		return nil, fieldError(ErrValidation, "password", fmt.Sprintf("password must be between %d and %d bytes", minPasswordLength, maxPasswordLength))
+	}
+	if req.Role != RoleBuyer && req.Role != RoleSeller {
+		return nil, fieldError(ErrValidation, "role", "role must be buyer or seller")
+	}
+
+	return req, nil
+}
+
+// RegisterUser is a handler to create a user account for POST /users .
+func (s *Handlers) RegisterUser(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, err := parseRegisterUserRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
+	if err != nil {
+		writeError(w, r, internalError("failed to hash password", err))
+		return
+	}
+
+	user := &User{Name: req.Name, Email: req.Email, PasswordHash: string(hash), Role: req.Role}
+	err = s.userRepo.Insert(ctx, user)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+	slog.InfoContext(r.Context(), "user registered", "user_id", user.ID)
+
+	resp := RegisterUserResponse{User: *user}
+	writeJSON(w, r, http.StatusOK, resp)
+}
+
+type LoginRequest struct {
+	Email    string `form:"email"`
+	Password string `form:"password"`
+}
+
+type LoginResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// parseLoginRequest parses and validates the request to log in.
+func parseLoginRequest(r *http.Request) (*LoginRequest, error) {
+	req := &LoginRequest{
+		Email:    strings.ToLower(strings.TrimSpace(r.FormValue("email"))),
+		Password: r.FormValue("password"),
+	}
+
+	if req.Email == "" {
+		return nil, fieldError(ErrInvalidRequest, "email", "email is required")
+	}
+	if req.Password == "" {
+		return nil, fieldError(ErrInvalidRequest, "password", "password is required")
+	}
+
+	return req, nil
+}
+
+// dummyPasswordHash returns the hash compared against when the email is unknown,
+// so that the response time does not reveal which emails are registered.
+// It is computed on the first failed login rather than when every binary importing this package starts.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy password"), bcrypt.DefaultCost)
+	return hash
+})
+
+// Login is a handler to issue an authentication token for POST /login .
+func (s *Handlers) Login(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	req, err := parseLoginRequest(r)
+	if err != nil {
+		writeError(w, r, err)
+		return
+	}
+
+	errBadCredentials := newError(ErrUnauthorized, "invalid email or password")
+
+	user, err := s.userRepo.GetUserByEmail(ctx, req.Email)
+	if err != nil {
+		if !errors.Is(err, errUserNotFound) {
+			writeError(w, r, err)
+			return
+		}
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(req.Password))
+		writeError(w, r, errBadCredentials)
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password)); err != nil {
+		writeError(w, r, errBadCredentials)
+		return
+	}
+
+	token, expiresAt, err := s.auth.IssueToken(user)
+	if err != nil {
+		writeError(w, r, internalError("failed to issue token", err))
+		return
+	}
+	slog.InfoContext(r.Context(), "user logged in", "user_id", user.ID)
+
+	resp := LoginResponse{Token: token, ExpiresAt: expiresAt}
+	writeJSON(w, r, http.StatusOK, resp)
 }
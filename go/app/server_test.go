@@ -1,54 +1,145 @@
 package app
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
 	"mime/multipart"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	"go.uber.org/mock/gomock"
+	"golang.org/x/crypto/bcrypt"
 )
 
-const (
-	testImageData = "test.jpg"
+var (
+	// testImageData is a 1x1 JPEG image.
+	testImageData = encodeTestImage(1)
+	// testImageName is the file name testImageData is stored as.
+	testImageName = fmt.Sprintf("%x.jpg", sha256.Sum256([]byte(testImageData)))
 )
 
+// encodeTestImage encodes a JPEG image width pixels wide and one pixel high.
+func encodeTestImage(width int) string {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, 1)), nil); err != nil {
+		panic(err)
+	}
+	return buf.String()
+}
+
 func TestParseAddItemRequest(t *testing.T) {
 	t.Parallel()
 
 	type wants struct {
-		req *AddItemRequest
-		err bool
+		// req is the request without its images, which are compared with images.
+		req    *AddItemRequest
+		images []string
+		err    bool
+		// kind is the kind of the error, checked when set.
+		kind error
+	}
+
+	validArgs := func(overrides map[string]string) map[string]string {
+		args := map[string]string{
+			"name":      "Test Item",
+			"category":  "Test Category",
+			"price":     "1200",
+			"condition": "good",
+		}
+		for k, v := range overrides {
+			args[k] = v
+		}
+		return args
 	}
 
 	// STEP 6-1: define test cases
 	cases := map[string]struct {
 		args      map[string]string
 		imageData []byte
+		// maxImageSize defaults to defaultMaxImageSize.
+		maxImageSize int64
 		wants
 	}{
 		"ok: valid request": {
-			args: map[string]string{
-				"name":     "Test Item",
-				"category": "Test Category",
+			args:      validArgs(nil),
+			imageData: []byte(testImageData),
+			wants: wants{
+				req: &AddItemRequest{
+					Name:      "Test Item",
+					Category:  "Test Category",
+					Price:     1200,
+					Currency:  "JPY",
+					Condition: ConditionGood,
+				},
+				images: []string{testImageData},
+				err:    false,
 			},
+		},
+		"ok: with currency and description": {
+			args:      validArgs(map[string]string{"currency": "USD", "description": "barely used"}),
 			imageData: []byte(testImageData),
 			wants: wants{
 				req: &AddItemRequest{
-					Name:     "Test Item",
-					Category: "Test Category",
-					Image:    []byte(testImageData),
+					Name:        "Test Item",
+					Category:    "Test Category",
+					Price:       1200,
+					Currency:    "USD",
+					Description: "barely used",
+					Condition:   ConditionGood,
 				},
-				err: false,
+				images: []string{testImageData},
 			},
 		},
+		"ng: missing price": {
+			args:      validArgs(map[string]string{"price": ""}),
+			imageData: []byte(testImageData),
+			wants:     wants{err: true, kind: ErrInvalidRequest},
+		},
+		"ng: negative price": {
+			args:      validArgs(map[string]string{"price": "-1"}),
+			imageData: []byte(testImageData),
+			wants:     wants{err: true, kind: ErrValidation},
+		},
+		"ng: unknown condition": {
+			args:      validArgs(map[string]string{"condition": "broken"}),
+			imageData: []byte(testImageData),
+			wants:     wants{err: true, kind: ErrValidation},
+		},
+		"ng: invalid currency": {
+			args:      validArgs(map[string]string{"currency": "yen"}),
+			imageData: []byte(testImageData),
+			wants:     wants{err: true, kind: ErrValidation},
+		},
+		"ng: too long description": {
+			args:      validArgs(map[string]string{"description": strings.Repeat("あ", maxDescriptionLength+1)}),
+			imageData: []byte(testImageData),
+			wants:     wants{err: true, kind: ErrValidation},
+		},
+		"ng: too large image": {
+			args:         validArgs(nil),
+			imageData:    []byte(testImageData),
+			maxImageSize: int64(len(testImageData)) - 1,
+			wants:        wants{err: true, kind: ErrTooLarge},
+		},
 		"ng: empty request": {
 			args:      map[string]string{},
 			imageData: nil,
@@ -95,18 +186,30 @@ func TestParseAddItemRequest(t *testing.T) {
 			req.Header.Set("Content-Type", writer.FormDataContentType())
 
 			// execute test target
-			got, err := parseAddItemRequest(req)
+			maxImageSize := tt.maxImageSize
+			if maxImageSize == 0 {
+				maxImageSize = defaultMaxImageSize
+			}
+			got, err := parseAddItemRequest(req, maxImageSize)
 
 			// confirm the result
 			if err != nil {
 				if !tt.err {
 					t.Errorf("unexpected error: %v", err)
 				}
+				if tt.kind != nil && !errors.Is(err, tt.kind) {
+					t.Errorf("expected error of kind %v, got %v", tt.kind, err)
+				}
 				return
 			}
+			images := readUploads(t, got.Images)
+			got.Images = nil
 			if diff := cmp.Diff(tt.wants.req, got); diff != "" {
 				t.Errorf("unexpected request (-want +got):\n%s", diff)
 			}
+			if diff := cmp.Diff(tt.images, images); diff != "" {
+				t.Errorf("unexpected images (-want +got):\n%s", diff)
+			}
 		})
 	}
 }
@@ -156,30 +259,132 @@ func TestAddItem(t *testing.T) {
 	cases := map[string]struct {
 		args      map[string]string
 		imageData []byte
-		injector  func(m *MockItemRepository)
+		anonymous bool
+		role      Role
+		strict    bool
+		// maxImageSize limits the size of the image when set.
+		maxImageSize int64
+		injector     func(m *MockItemRepository)
+		// categories sets up the category repository, which only strict mode consults.
+		categories func(m *MockCategoryRepository)
 		wants
 	}{
 		"ok: correctly inserted": {
 			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
 			},
 			imageData: []byte(testImageData),
 			injector: func(m *MockItemRepository) {
 				m.EXPECT().
-					Insert(gomock.Any(), gomock.Any()).
+					Insert(gomock.Any(), gomock.Cond(func(item *Item) bool { return item.SellerID == 1 })).
 					Return(nil).Times(1)
 			},
 			wants: wants{
 				code: http.StatusOK,
 			},
 		},
+		"ok: existing category in strict mode": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
+			},
+			imageData: []byte(testImageData),
+			strict:    true,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(nil).Times(1)
+			},
+			categories: func(m *MockCategoryRepository) {
+				m.EXPECT().GetByName(gomock.Any(), "phone").Return(Category{ID: 1, Name: "phone"}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: unknown category in strict mode": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phon",
+				"price":     "58000",
+				"condition": "like-new",
+			},
+			imageData: []byte(testImageData),
+			strict:    true,
+			injector:  func(m *MockItemRepository) {},
+			categories: func(m *MockCategoryRepository) {
+				m.EXPECT().GetByName(gomock.Any(), "phon").Return(Category{}, errCategoryNotFound)
+			},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: not an image": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
+			},
+			imageData: []byte("not an image"),
+			injector:  func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnsupportedMediaType,
+			},
+		},
+		"ng: image larger than the limit": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
+			},
+			imageData:    []byte(testImageData),
+			maxImageSize: int64(len(testImageData)) - 1,
+			injector:     func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusRequestEntityTooLarge,
+			},
+		},
+		"ng: buyer cannot list items": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
+			},
+			imageData: []byte(testImageData),
+			role:      RoleBuyer,
+			injector:  func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: anonymous request": {
+			args: map[string]string{
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
+			},
+			imageData: []byte(testImageData),
+			anonymous: true,
+			injector:  func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
 		"ng: failed to insert": {
 			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
 			},
-			imageData: nil,
+			imageData: []byte(testImageData),
 			injector: func(m *MockItemRepository) {
 				m.EXPECT().
 					Insert(gomock.Any(), gomock.Any()).
@@ -199,6 +404,10 @@ func TestAddItem(t *testing.T) {
 
 			mockIR := NewMockItemRepository(ctrl)
 			tt.injector(mockIR)
+			mockCR := NewMockCategoryRepository(ctrl)
+			if tt.categories != nil {
+				tt.categories(mockCR)
+			}
 
 			body := &bytes.Buffer{}
 			writer := multipart.NewWriter(body)
@@ -214,7 +423,7 @@ func TestAddItem(t *testing.T) {
 				t.Fatalf("failed to create file part: %v", err)
 			}
 
-			if _, err := part.Write([]byte(testImageData)); err != nil {
+			if _, err := part.Write(tt.imageData); err != nil {
 				t.Fatalf("failed to write image data: %v", err)
 			}
 
@@ -222,9 +431,17 @@ func TestAddItem(t *testing.T) {
 
 			req := httptest.NewRequest("POST", "/items", body)
 			req.Header.Set("Content-Type", writer.FormDataContentType())
+			if !tt.anonymous {
+				role := tt.role
+				if role == "" {
+					role = RoleSeller
+				}
+				req = req.WithContext(withAuthUser(req.Context(), AuthUser{ID: 1, Role: role}))
+			}
 
 			rr := httptest.NewRecorder()
-			h := &Handlers{itemRepo: mockIR}
+			images := NewFileImageStore(t.TempDir())
+			h := &Handlers{images: images, itemRepo: mockIR, categoryRepo: mockCR, strictCategories: tt.strict, maxImageSize: tt.maxImageSize}
 			h.AddItem(rr, req)
 
 			if tt.wants.code != rr.Code {
@@ -234,9 +451,15 @@ func TestAddItem(t *testing.T) {
 				return
 			}
 
-			for _, v := range tt.args {
-				if !strings.Contains(rr.Body.String(), v) {
-					t.Errorf("response body does not contain %s, got: %s", v, rr.Body.String())
+			for _, key := range []string{testImageName, thumbnailKey(testImageName, 150), thumbnailKey(testImageName, 600)} {
+				if exists, err := images.Exists(t.Context(), key); err != nil || !exists {
+					t.Errorf("expected %s to be stored, got %v, %v", key, exists, err)
+				}
+			}
+
+			for _, k := range []string{"name", "category"} {
+				if !strings.Contains(rr.Body.String(), tt.args[k]) {
+					t.Errorf("response body does not contain %s, got: %s", tt.args[k], rr.Body.String())
 				}
 			}
 		})
@@ -260,10 +483,10 @@ func TestAddItemE2e(t *testing.T) {
 	})
 
 	type wants struct {
-		code      int
-		name      string
-		category  string
-		imageData []byte
+		code     int
+		name     string
+		category string
+		images   []*imageUpload
 	}
 	cases := map[string]struct {
 		args      map[string]string
@@ -272,8 +495,10 @@ func TestAddItemE2e(t *testing.T) {
 	}{
 		"ok: correctly inserted": {
 			args: map[string]string{
-				"name":     "used iPhone 16e",
-				"category": "phone",
+				"name":      "used iPhone 16e",
+				"category":  "phone",
+				"price":     "58000",
+				"condition": "like-new",
 			},
 			imageData: []byte(testImageData),
 			wants: wants{
@@ -294,7 +519,7 @@ func TestAddItemE2e(t *testing.T) {
 
 	for name, tt := range cases {
 		t.Run(name, func(t *testing.T) {
-			h := &Handlers{itemRepo: &itemRepository{db: db}}
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: &itemRepository{db: db}}
 
 			body := &bytes.Buffer{}
 			writer := multipart.NewWriter(body)
@@ -317,6 +542,7 @@ func TestAddItemE2e(t *testing.T) {
 
 			req := httptest.NewRequest("POST", "/items", body)
 			req.Header.Set("Content-Type", writer.FormDataContentType())
+			req = req.WithContext(withAuthUser(req.Context(), AuthUser{ID: 1, Role: RoleSeller}))
 
 			rr := httptest.NewRecorder()
 			h.AddItem(rr, req)
@@ -327,9 +553,9 @@ func TestAddItemE2e(t *testing.T) {
 			if tt.wants.code >= 400 {
 				return
 			}
-			for _, v := range tt.args {
-				if !strings.Contains(rr.Body.String(), v) {
-					t.Errorf("response body does not contain %s, got: %s", v, rr.Body.String())
+			for _, k := range []string{"name", "category"} {
+				if !strings.Contains(rr.Body.String(), tt.args[k]) {
+					t.Errorf("response body does not contain %s, got: %s", tt.args[k], rr.Body.String())
 				}
 			}
 			// STEP 6-4: check inserted data
@@ -338,51 +564,2391 @@ func TestAddItemE2e(t *testing.T) {
 				if err := json.NewDecoder(rr.Body).Decode(&item); err != nil {
 					t.Errorf("failed to decode response body: %v", err)
 				}
-				if item.Name != tt.wants.name || item.Category != tt.wants.category || !bytes.Equal(item.Image, tt.wants.imageData) {
-					t.Errorf("expected (name, category,imageData) = (%s, %s,%v), but got (%s, %s%v)", tt.wants.name, tt.wants.category, tt.wants.imageData, item.Name, item.Category, item.Image)
+				if item.Name != tt.wants.name || item.Category != tt.wants.category || !cmp.Equal(item.Images, tt.wants.images) {
+					t.Errorf("expected (name, category,images) = (%s, %s,%v), but got (%s, %s%v)", tt.wants.name, tt.wants.category, tt.wants.images, item.Name, item.Category, item.Images)
 				}
 			}
 		})
 	}
 }
 
-func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
-	t.Helper()
+func TestGetImage(t *testing.T) {
+	t.Parallel()
 
-	defer func() {
-		if e != nil {
-			for _, c := range closers {
-				c()
-			}
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte("hashed image")))
+	missing := fmt.Sprintf("%x.jpg", sha256.Sum256([]byte("missing image")))
+
+	store := NewFileImageStore(t.TempDir())
+	for key, data := range map[string]string{
+		"item.jpg":                     "item image",
+		"item_w150.jpg":                "150px thumbnail",
+		"item_w600.jpg":                "600px thumbnail",
+		"item.webp":                    "webp image",
+		"legacy.png":                   "legacy image",
+		hash + ".jpg":                  "hashed image",
+		thumbnailKey(hash+".jpg", 150): "hashed thumbnail",
+		defaultImage:                   "default image",
+	} {
+		if err := store.Put(t.Context(), key, strings.NewReader(data)); err != nil {
+			t.Fatalf("failed to put image: %v", err)
 		}
-	}()
+	}
 
-	f, err := os.CreateTemp(".", "*.sqlite3")
-	if err != nil {
-		return nil, nil, err
+	type wants struct {
+		code         int
+		body         string
+		contentType  string
+		cacheControl string
+		etag         string
+	}
+	cases := map[string]struct {
+		filename string
+		query    string
+		header   map[string]string
+		wants
+	}{
+		"ok: stored image": {
+			filename: "item.jpg",
+			wants:    wants{code: http.StatusOK, body: "item image", contentType: "image/jpeg"},
+		},
+		"ok: content type follows the extension": {
+			filename: "item.webp",
+			wants:    wants{code: http.StatusOK, body: "webp image", contentType: "image/webp"},
+		},
+		"ok: default image for a missing one": {
+			filename: "missing.png",
+			wants:    wants{code: http.StatusOK, body: "default image", contentType: "image/jpeg"},
+		},
+		"ok: smallest thumbnail at least as wide": {
+			filename: "item.jpg",
+			query:    "?w=200",
+			wants:    wants{code: http.StatusOK, body: "600px thumbnail", contentType: "image/jpeg"},
+		},
+		"ok: original when wider than every thumbnail": {
+			filename: "item.jpg",
+			query:    "?w=1200",
+			wants:    wants{code: http.StatusOK, body: "item image", contentType: "image/jpeg"},
+		},
+		"ok: original of an image without thumbnails": {
+			filename: "legacy.png",
+			query:    "?w=150",
+			wants:    wants{code: http.StatusOK, body: "legacy image", contentType: "image/png"},
+		},
+		"ok: content-addressed image is immutable": {
+			filename: hash + ".jpg",
+			wants:    wants{code: http.StatusOK, body: "hashed image", cacheControl: imageCacheControl, etag: `"` + hash + `"`},
+		},
+		"ok: thumbnail has its own etag": {
+			filename: hash + ".jpg",
+			query:    "?w=150",
+			wants:    wants{code: http.StatusOK, body: "hashed thumbnail", cacheControl: imageCacheControl, etag: `"` + hash + `_w150"`},
+		},
+		"ok: matching etag is not modified": {
+			filename: hash + ".jpg",
+			header:   map[string]string{"If-None-Match": `"` + hash + `"`},
+			wants:    wants{code: http.StatusNotModified, cacheControl: imageCacheControl, etag: `"` + hash + `"`},
+		},
+		"ok: weak etag in a list is not modified": {
+			filename: hash + ".jpg",
+			header:   map[string]string{"If-None-Match": `W/"other", W/"` + hash + `"`},
+			wants:    wants{code: http.StatusNotModified},
+		},
+		"ok: etag of the thumbnail does not match the original": {
+			filename: hash + ".jpg",
+			header:   map[string]string{"If-None-Match": `"` + hash + `_w150"`},
+			wants:    wants{code: http.StatusOK, body: "hashed image"},
+		},
+		"ok: etag takes precedence over modification time": {
+			filename: hash + ".jpg",
+			header:   map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+			wants:    wants{code: http.StatusOK, body: "hashed image"},
+		},
+		"ok: default image is cached briefly": {
+			filename: missing,
+			wants:    wants{code: http.StatusOK, body: "default image", cacheControl: mutableImageCacheControl},
+		},
+		"ok: default image is revalidated by modification time": {
+			filename: missing,
+			header:   map[string]string{"If-Modified-Since": time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)},
+			wants:    wants{code: http.StatusNotModified, cacheControl: mutableImageCacheControl},
+		},
+		"ng: invalid width": {
+			filename: "item.jpg",
+			query:    "?w=abc",
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: directory traversal": {
+			filename: "../item.jpg",
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: not an image extension": {
+			filename: "item.txt",
+			wants:    wants{code: http.StatusBadRequest},
+		},
 	}
-	closers = append(closers, func() {
-		f.Close()
-		os.Remove(f.Name())
-	})
 
-	db, err = sql.Open("sqlite3", f.Name())
-	if err != nil {
-		return nil, nil, err
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/images/image"+tt.query, nil)
+			req.SetPathValue("filename", tt.filename)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: store}
+			h.GetImage(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+			if tt.wants.body != "" && rr.Body.String() != tt.wants.body {
+				t.Errorf("expected body %q, got %q", tt.wants.body, rr.Body.String())
+			}
+			if got := rr.Header().Get("Content-Type"); tt.wants.contentType != "" && got != tt.wants.contentType {
+				t.Errorf("expected content type %q, got %q", tt.wants.contentType, got)
+			}
+			if got := rr.Header().Get("Cache-Control"); tt.wants.cacheControl != "" && got != tt.wants.cacheControl {
+				t.Errorf("expected cache control %q, got %q", tt.wants.cacheControl, got)
+			}
+			// the etag is checked along with the cache control, since images without one have no etag
+			if got := rr.Header().Get("ETag"); tt.wants.cacheControl != "" && got != tt.wants.etag {
+				t.Errorf("expected etag %q, got %q", tt.wants.etag, got)
+			}
+			if tt.wants.code == http.StatusNotModified && rr.Body.Len() > 0 {
+				t.Errorf("expected no body for 304, got %q", rr.Body.String())
+			}
+		})
 	}
-	closers = append(closers, func() {
-		db.Close()
-	})
+}
 
-	sqlFile, err := os.ReadFile("../db/items.sql")
-	if err != nil {
-		t.Errorf("failed to read SQL file: %v", err)
-		return nil, nil, err
+// TestServeImageNotSeekable covers images streamed from stores such as S3, which http.ServeContent cannot serve.
+func TestServeImageNotSeekable(t *testing.T) {
+	t.Parallel()
+
+	hash := fmt.Sprintf("%x", sha256.Sum256([]byte("hashed image")))
+	modTime := time.Date(2025, 4, 1, 12, 0, 0, 0, time.UTC)
+
+	cases := map[string]struct {
+		name   string
+		header map[string]string
+		code   int
+		body   string
+	}{
+		"ok: served with its length": {
+			name: hash + ".png",
+			code: http.StatusOK,
+			body: "streamed",
+		},
+		"ok: matching etag is not modified": {
+			name:   hash + ".png",
+			header: map[string]string{"If-None-Match": `"` + hash + `"`},
+			code:   http.StatusNotModified,
+		},
+		"ok: any etag is not modified": {
+			name:   hash + ".png",
+			header: map[string]string{"If-None-Match": "*"},
+			code:   http.StatusNotModified,
+		},
+		"ok: unchanged since is not modified": {
+			name:   defaultImage,
+			header: map[string]string{"If-Modified-Since": modTime.Format(http.TimeFormat)},
+			code:   http.StatusNotModified,
+		},
+		"ok: changed since is served": {
+			name:   defaultImage,
+			header: map[string]string{"If-Modified-Since": modTime.Add(-time.Second).Format(http.TimeFormat)},
+			code:   http.StatusOK,
+			body:   "streamed",
+		},
 	}
 
-	_, err = db.Exec(string(sqlFile))
-	if err != nil {
-		t.Errorf("failed to execute SQL schema: %v", err)
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest("GET", "/images/"+tt.name, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			serveImage(rr, req, tt.name, io.NopCloser(strings.NewReader("streamed")), ImageInfo{Size: 8, ModTime: modTime})
+
+			if rr.Code != tt.code {
+				t.Fatalf("expected status code %d, got %d", tt.code, rr.Code)
+			}
+			if rr.Body.String() != tt.body {
+				t.Errorf("expected body %q, got %q", tt.body, rr.Body.String())
+			}
+			if tt.code == http.StatusOK && rr.Header().Get("Content-Length") != "8" {
+				t.Errorf("expected content length 8, got %q", rr.Header().Get("Content-Length"))
+			}
+		})
+	}
+}
+
+func TestGetItemById(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code      int
+		errorCode string
+		imageURL  string
+	}
+	cases := map[string]struct {
+		itemId   string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: item found": {
+			itemId: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(Item{ID: 1, Name: "used iPhone 16e", Image: "item.jpg"}, nil)
+			},
+			wants: wants{
+				code:     http.StatusOK,
+				imageURL: "/images/item.jpg",
+			},
+		},
+		"ng: item not found": {
+			itemId: "999",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "999").Return(Item{}, errItemNotFound)
+			},
+			wants: wants{
+				code:      http.StatusNotFound,
+				errorCode: "not_found",
+			},
+		},
+		"ng: invalid item id": {
+			itemId:   "abc",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code:      http.StatusBadRequest,
+				errorCode: "invalid_request",
+			},
+		},
+		"ng: database error": {
+			itemId: "1",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(Item{}, errors.New("database is locked"))
+			},
+			wants: wants{
+				code:      http.StatusInternalServerError,
+				errorCode: "internal",
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("GET", "/items/"+tt.itemId, nil)
+			req.SetPathValue("item_id", tt.itemId)
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+			h.GetItemById(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code < 400 {
+				var got GetItemByIdResponse
+				if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+					t.Fatalf("failed to decode response: %v", err)
+				}
+				if len(got.Items) != 1 || got.Items[0].ImageURL != tt.wants.imageURL {
+					t.Errorf("expected one item with image url %q, got %+v", tt.wants.imageURL, got.Items)
+				}
+				return
+			}
+
+			var got ErrorResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode error response: %v", err)
+			}
+			if got.Error.Code != tt.wants.errorCode {
+				t.Errorf("expected error code %q, got %q", tt.wants.errorCode, got.Error.Code)
+			}
+			if strings.Contains(got.Error.Message, "database") {
+				t.Errorf("internal error text leaked to the client: %q", got.Error.Message)
+			}
+		})
+	}
+}
+
+func TestUpdateItem(t *testing.T) {
+	t.Parallel()
+
+	existing := Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "old.jpg", SellerID: 1}
+	seller := &AuthUser{ID: 1, Role: RoleSeller}
+	name, price, condition := "used iPhone 16", int64(45000), ConditionFair
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemId    string
+		args      map[string]string
+		imageData []byte
+		user      *AuthUser
+		injector  func(m *MockItemRepository)
+		wants
+	}{
+		"ok: name only is updated": {
+			itemId: "1",
+			args:   map[string]string{"name": "used iPhone 16"},
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().
+					Update(gomock.Any(), "1", ItemUpdate{Name: &name}).
+					Return(Item{ID: 1, Name: name, Category: "phone", Image: "old.jpg", SellerID: 1}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: image is replaced": {
+			itemId:    "1",
+			imageData: []byte(testImageData),
+			user:      seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().
+					Update(gomock.Any(), "1", ItemUpdate{Images: []ItemImage{{Name: testImageName}}}).
+					Return(Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: testImageName, SellerID: 1}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: price and condition are updated": {
+			itemId: "1",
+			args:   map[string]string{"price": "45000", "condition": "fair"},
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().
+					Update(gomock.Any(), "1", ItemUpdate{Price: &price, Condition: &condition}).
+					Return(Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "old.jpg", SellerID: 1, Price: price, Condition: condition}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: admin updates another seller's item": {
+			itemId: "1",
+			args:   map[string]string{"category": "smartphone"},
+			user:   &AuthUser{ID: 9, Role: RoleAdmin},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Update(gomock.Any(), "1", gomock.Any()).Return(existing, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: anonymous request": {
+			itemId:   "1",
+			args:     map[string]string{"name": "used iPhone 16"},
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: not the seller": {
+			itemId: "1",
+			args:   map[string]string{"name": "used iPhone 16"},
+			user:   &AuthUser{ID: 2, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+			},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: no field to update": {
+			itemId:   "1",
+			args:     map[string]string{},
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: empty name": {
+			itemId:   "1",
+			args:     map[string]string{"name": ""},
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: negative price": {
+			itemId:   "1",
+			args:     map[string]string{"price": "-100"},
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: unknown condition": {
+			itemId:   "1",
+			args:     map[string]string{"condition": "mint"},
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: invalid item id": {
+			itemId:   "abc",
+			args:     map[string]string{"name": "used iPhone 16"},
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: item not found": {
+			itemId: "999",
+			args:   map[string]string{"category": "smartphone"},
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "999").Return(Item{}, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: failed to update": {
+			itemId: "1",
+			args:   map[string]string{"category": "smartphone"},
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Update(gomock.Any(), "1", gomock.Any()).Return(Item{}, errors.New("database error"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := newMultipartRequest(t, "PATCH", "/items/"+tt.itemId, tt.args, tt.imageData)
+			req.SetPathValue("item_id", tt.itemId)
+			if tt.user != nil {
+				req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+			h.UpdateItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestDeleteItem(t *testing.T) {
+	t.Parallel()
+
+	existing := Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "old.jpg", SellerID: 1}
+	seller := &AuthUser{ID: 1, Role: RoleSeller}
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemId   string
+		user     *AuthUser
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: correctly deleted": {
+			itemId: "1",
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Delete(gomock.Any(), "1").Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: admin deletes another seller's item": {
+			itemId: "1",
+			user:   &AuthUser{ID: 9, Role: RoleAdmin},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Delete(gomock.Any(), "1").Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: anonymous request": {
+			itemId:   "1",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: not the seller": {
+			itemId: "1",
+			user:   &AuthUser{ID: 2, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+			},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: item not found": {
+			itemId: "999",
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "999").Return(Item{}, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: item is not on sale": {
+			itemId: "1",
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Delete(gomock.Any(), "1").Return(errItemNotOnSale)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: item has orders": {
+			itemId: "1",
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Delete(gomock.Any(), "1").Return(errItemHasOrders)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: failed to delete": {
+			itemId: "1",
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Delete(gomock.Any(), "1").Return(errors.New("database error"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("DELETE", "/items/"+tt.itemId, nil)
+			req.SetPathValue("item_id", tt.itemId)
+			if tt.user != nil {
+				req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{itemRepo: mockIR}
+			h.DeleteItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+		})
+	}
+}
+
+func TestUpdateAndDeleteItemE2e(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	repo := &itemRepository{db: db}
+	users := &userRepository{db: db}
+	h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: repo}
+	ctx := t.Context()
+
+	seller := &User{Name: "seller", Email: "seller@example.com", PasswordHash: "hash", Role: RoleSeller}
+	other := &User{Name: "other", Email: "other@example.com", PasswordHash: "hash", Role: RoleSeller}
+	for _, u := range []*User{seller, other} {
+		if err := users.Insert(ctx, u); err != nil {
+			t.Fatalf("failed to insert user: %v", err)
+		}
+	}
+	asSeller := withAuthUser(ctx, AuthUser{ID: seller.ID, Role: seller.Role})
+	asOther := withAuthUser(ctx, AuthUser{ID: other.ID, Role: other.Role})
+
+	if err := repo.Insert(ctx, &Item{Name: "fashon bag", Category: "fashon", Image: "bag.jpg", SellerID: seller.ID, Price: 3000, Currency: "JPY", Condition: ConditionGood}); err != nil {
+		t.Fatalf("failed to insert item: %v", err)
+	}
+
+	req := newMultipartRequest(t, "PATCH", "/items/1", map[string]string{"name": "stolen bag"}, nil).WithContext(asOther)
+	req.SetPathValue("item_id", "1")
+	rr := httptest.NewRecorder()
+	h.UpdateItem(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d for another seller, got %d: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	req = newMultipartRequest(t, "PATCH", "/items/1", map[string]string{"name": "fashion bag", "category": "fashion", "price": "2500", "description": "a strap is missing"}, nil).WithContext(asSeller)
+	req.SetPathValue("item_id", "1")
+	rr = httptest.NewRecorder()
+	h.UpdateItem(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	got, err := repo.GetItemById(ctx, "1")
+	if err != nil {
+		t.Fatalf("failed to get item: %v", err)
+	}
+	want := Item{ID: 1, Name: "fashion bag", Category: "fashion", Image: "bag.jpg", Images: []ItemImage{{ID: 1, Name: "bag.jpg"}}, SellerID: seller.ID,
+		Price: 2500, Currency: "JPY", Description: "a strap is missing", Condition: ConditionGood, Status: ItemStatusOnSale}
+	if diff := cmp.Diff(want, got, cmpopts.IgnoreFields(Item{}, "CreatedAt", "UpdatedAt")); diff != "" {
+		t.Errorf("unexpected item after update (-want +got):\n%s", diff)
+	}
+	if got.CreatedAt.IsZero() || got.UpdatedAt.Before(got.CreatedAt) {
+		t.Errorf("unexpected timestamps after update: created_at %v, updated_at %v", got.CreatedAt, got.UpdatedAt)
+	}
+
+	req = httptest.NewRequest("DELETE", "/items/1", nil).WithContext(asOther)
+	req.SetPathValue("item_id", "1")
+	rr = httptest.NewRecorder()
+	h.DeleteItem(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected status code %d for another seller, got %d: %s", http.StatusForbidden, rr.Code, rr.Body.String())
+	}
+
+	req = httptest.NewRequest("DELETE", "/items/1", nil).WithContext(asSeller)
+	req.SetPathValue("item_id", "1")
+	rr = httptest.NewRecorder()
+	h.DeleteItem(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status code %d, got %d: %s", http.StatusOK, rr.Code, rr.Body.String())
+	}
+
+	if _, err := repo.GetItemById(ctx, "1"); !errors.Is(err, errItemNotFound) {
+		t.Errorf("expected errItemNotFound after delete, got %v", err)
+	}
+
+	rr = httptest.NewRecorder()
+	h.DeleteItem(rr, req)
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status code %d on second delete, got %d", http.StatusNotFound, rr.Code)
+	}
+}
+
+func TestPurchaseItem(t *testing.T) {
+	t.Parallel()
+
+	existing := Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "old.jpg", SellerID: 1, Price: 58000, Currency: "JPY", Status: ItemStatusOnSale}
+	buyer := &AuthUser{ID: 2, Role: RoleBuyer}
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemId   string
+		user     *AuthUser
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: correctly purchased": {
+			itemId: "1",
+			user:   buyer,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Purchase(gomock.Any(), "1", 2).Return(Order{ID: 1, ItemID: 1, BuyerID: 2, Price: 58000, Currency: "JPY"}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: anonymous request": {
+			itemId:   "1",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: seller buys their own item": {
+			itemId: "1",
+			user:   &AuthUser{ID: 1, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+			},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: already purchased": {
+			itemId: "1",
+			user:   buyer,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().Purchase(gomock.Any(), "1", 2).Return(Order{}, errItemNotOnSale)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: item not found": {
+			itemId: "999",
+			user:   buyer,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "999").Return(Item{}, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: invalid item id": {
+			itemId:   "abc",
+			user:     buyer,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("POST", "/items/"+tt.itemId+"/purchase", nil)
+			req.SetPathValue("item_id", tt.itemId)
+			if tt.user != nil {
+				req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{itemRepo: mockIR}
+			h.PurchaseItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCompleteTrade(t *testing.T) {
+	t.Parallel()
+
+	order := Order{ID: 1, ItemID: 1, BuyerID: 2, Price: 58000, Currency: "JPY"}
+	buyer := &AuthUser{ID: 2, Role: RoleBuyer}
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemId   string
+		user     *AuthUser
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: buyer completes the trade": {
+			itemId: "1",
+			user:   buyer,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+				m.EXPECT().CompleteTrade(gomock.Any(), order).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: admin completes the trade": {
+			itemId: "1",
+			user:   &AuthUser{ID: 9, Role: RoleAdmin},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+				m.EXPECT().CompleteTrade(gomock.Any(), order).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: anonymous request": {
+			itemId:   "1",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: seller completes the trade": {
+			itemId: "1",
+			user:   &AuthUser{ID: 1, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+			},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: item not in a trade": {
+			itemId: "1",
+			user:   buyer,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(Order{}, errItemNotTraded)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: trade cancelled meanwhile": {
+			itemId: "1",
+			user:   buyer,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+				m.EXPECT().CompleteTrade(gomock.Any(), order).Return(errTradeChanged)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: item not found": {
+			itemId: "999",
+			user:   buyer,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetTradeOrder(gomock.Any(), "999").Return(Order{}, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: invalid item id": {
+			itemId:   "abc",
+			user:     buyer,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("POST", "/items/"+tt.itemId+"/complete", nil)
+			req.SetPathValue("item_id", tt.itemId)
+			if tt.user != nil {
+				req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{itemRepo: mockIR}
+			h.CompleteTrade(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestCancelTrade(t *testing.T) {
+	t.Parallel()
+
+	existing := Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "old.jpg", SellerID: 1, Price: 58000, Currency: "JPY", Status: ItemStatusTrading}
+	order := Order{ID: 1, ItemID: 1, BuyerID: 2, Price: 58000, Currency: "JPY"}
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		itemId   string
+		user     *AuthUser
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: seller cancels the trade": {
+			itemId: "1",
+			user:   &AuthUser{ID: 1, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+				m.EXPECT().CancelTrade(gomock.Any(), order).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: buyer cancels the trade": {
+			itemId: "1",
+			user:   &AuthUser{ID: 2, Role: RoleBuyer},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+				m.EXPECT().CancelTrade(gomock.Any(), order).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: anonymous request": {
+			itemId:   "1",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: someone else cancels the trade": {
+			itemId: "1",
+			user:   &AuthUser{ID: 3, Role: RoleBuyer},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+			},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: trade completed meanwhile": {
+			itemId: "1",
+			user:   &AuthUser{ID: 1, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().GetTradeOrder(gomock.Any(), "1").Return(order, nil)
+				m.EXPECT().CancelTrade(gomock.Any(), order).Return(newError(ErrConflict, "item is sold_out, not trading"))
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+		"ng: item not found": {
+			itemId: "999",
+			user:   &AuthUser{ID: 1, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "999").Return(Item{}, errItemNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("POST", "/items/"+tt.itemId+"/cancel", nil)
+			req.SetPathValue("item_id", tt.itemId)
+			if tt.user != nil {
+				req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{itemRepo: mockIR}
+			h.CancelTrade(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestAddCategory(t *testing.T) {
+	t.Parallel()
+
+	admin := &AuthUser{ID: 1, Role: RoleAdmin}
+
+	type wants struct {
+		code     int
+		category Category
+	}
+	cases := map[string]struct {
+		args     url.Values
+		user     *AuthUser
+		injector func(m *MockCategoryRepository)
+		wants
+	}{
+		"ok: top-level category": {
+			args: url.Values{"name": {"fashion"}},
+			user: admin,
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Insert(gomock.Any(), &Category{Name: "fashion"}).
+					DoAndReturn(func(_ context.Context, c *Category) error { c.ID = 1; return nil })
+			},
+			wants: wants{
+				code:     http.StatusOK,
+				category: Category{ID: 1, Name: "fashion"},
+			},
+		},
+		"ok: subcategory": {
+			args: url.Values{"name": {"shoes"}, "parent_id": {"1"}},
+			user: admin,
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Insert(gomock.Any(), &Category{Name: "shoes", ParentID: 1}).
+					DoAndReturn(func(_ context.Context, c *Category) error { c.ID = 2; return nil })
+			},
+			wants: wants{
+				code:     http.StatusOK,
+				category: Category{ID: 2, Name: "shoes", ParentID: 1},
+			},
+		},
+		"ng: seller cannot manage categories": {
+			args:     url.Values{"name": {"fashion"}},
+			user:     &AuthUser{ID: 2, Role: RoleSeller},
+			injector: func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: anonymous request": {
+			args:     url.Values{"name": {"fashion"}},
+			injector: func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: name is missing": {
+			args:     url.Values{"name": {" "}},
+			user:     admin,
+			injector: func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: invalid parent id": {
+			args:     url.Values{"name": {"shoes"}, "parent_id": {"0"}},
+			user:     admin,
+			injector: func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: duplicate name": {
+			args: url.Values{"name": {"fashion"}},
+			user: admin,
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(errCategoryDuplicate)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockCR := NewMockCategoryRepository(ctrl)
+			tt.injector(mockCR)
+
+			req := httptest.NewRequest("POST", "/categories", strings.NewReader(tt.args.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.user != nil {
+				req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{categoryRepo: mockCR}
+			h.AddCategory(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+
+			var resp CategoryResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.category, resp.Category); diff != "" {
+				t.Errorf("unexpected category (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestUpdateCategory(t *testing.T) {
+	t.Parallel()
+
+	admin := &AuthUser{ID: 1, Role: RoleAdmin}
+	existing := Category{ID: 3, Name: "sneakers", ParentID: 2}
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		categoryId string
+		args       url.Values
+		injector   func(m *MockCategoryRepository)
+		wants
+	}{
+		"ok: rename keeps the parent": {
+			categoryId: "3",
+			args:       url.Values{"name": {"trainers"}},
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Get(gomock.Any(), 3).Return(existing, nil)
+				m.EXPECT().Update(gomock.Any(), &Category{ID: 3, Name: "trainers", ParentID: 2}).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: empty parent_id makes it top-level": {
+			categoryId: "3",
+			args:       url.Values{"parent_id": {""}},
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Get(gomock.Any(), 3).Return(existing, nil)
+				m.EXPECT().Update(gomock.Any(), &Category{ID: 3, Name: "sneakers"}).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: nested under its own subcategory": {
+			categoryId: "3",
+			args:       url.Values{"parent_id": {"4"}},
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Get(gomock.Any(), 3).Return(existing, nil)
+				m.EXPECT().Update(gomock.Any(), gomock.Any()).Return(errCategoryCycle)
+			},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: category not found": {
+			categoryId: "999",
+			args:       url.Values{"name": {"trainers"}},
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Get(gomock.Any(), 999).Return(Category{}, errCategoryNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: no field to update": {
+			categoryId: "3",
+			args:       url.Values{},
+			injector:   func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: body too large": {
+			categoryId: "3",
+			args:       url.Values{"name": {strings.Repeat("x", maxFormFieldsSize)}},
+			injector:   func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusRequestEntityTooLarge,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockCR := NewMockCategoryRepository(ctrl)
+			tt.injector(mockCR)
+
+			req := httptest.NewRequest("PATCH", "/categories/"+tt.categoryId, strings.NewReader(tt.args.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetPathValue("category_id", tt.categoryId)
+			req = req.WithContext(withAuthUser(req.Context(), *admin))
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{categoryRepo: mockCR}
+			h.UpdateCategory(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestMergeCategory(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		categoryId string
+		into       string
+		role       Role
+		injector   func(m *MockCategoryRepository)
+		wants
+	}{
+		"ok: merged": {
+			categoryId: "5",
+			into:       "1",
+			role:       RoleAdmin,
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Merge(gomock.Any(), 5, 1).Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: seller cannot merge": {
+			categoryId: "5",
+			into:       "1",
+			role:       RoleSeller,
+			injector:   func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusForbidden,
+			},
+		},
+		"ng: into is missing": {
+			categoryId: "5",
+			role:       RoleAdmin,
+			injector:   func(m *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: merged into its subcategory": {
+			categoryId: "1",
+			into:       "5",
+			role:       RoleAdmin,
+			injector: func(m *MockCategoryRepository) {
+				m.EXPECT().Merge(gomock.Any(), 1, 5).Return(errMergeIntoSubtree)
+			},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockCR := NewMockCategoryRepository(ctrl)
+			tt.injector(mockCR)
+
+			args := url.Values{}
+			if tt.into != "" {
+				args.Set("into", tt.into)
+			}
+			req := httptest.NewRequest("POST", "/categories/"+tt.categoryId+"/merge", strings.NewReader(args.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			req.SetPathValue("category_id", tt.categoryId)
+			req = req.WithContext(withAuthUser(req.Context(), AuthUser{ID: 1, Role: tt.role}))
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{categoryRepo: mockCR}
+			h.MergeCategory(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestGetCategoryItems(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		categoryId string
+		injector   func(mi *MockItemRepository, mc *MockCategoryRepository)
+		wants
+	}{
+		"ok: items of the category and its subcategories": {
+			categoryId: "1",
+			injector: func(mi *MockItemRepository, mc *MockCategoryRepository) {
+				mc.EXPECT().Get(gomock.Any(), 1).Return(Category{ID: 1, Name: "fashion"}, nil)
+				mi.EXPECT().
+					GetAllItem(gomock.Any(), gomock.Cond(func(opts ListItemsOptions) bool { return opts.CategoryID == 1 })).
+					Return([]Item{{ID: 1, Name: "sneakers", Category: "shoes"}}, nil, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: category not found": {
+			categoryId: "999",
+			injector: func(mi *MockItemRepository, mc *MockCategoryRepository) {
+				mc.EXPECT().Get(gomock.Any(), 999).Return(Category{}, errCategoryNotFound)
+			},
+			wants: wants{
+				code: http.StatusNotFound,
+			},
+		},
+		"ng: invalid category id": {
+			categoryId: "abc",
+			injector:   func(mi *MockItemRepository, mc *MockCategoryRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			mockCR := NewMockCategoryRepository(ctrl)
+			tt.injector(mockIR, mockCR)
+
+			req := httptest.NewRequest("GET", "/categories/"+tt.categoryId+"/items", nil)
+			req.SetPathValue("category_id", tt.categoryId)
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR, categoryRepo: mockCR}
+			h.GetCategoryItems(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestSearchItemsByKeyword(t *testing.T) {
+	t.Parallel()
+
+	nextCursor, err := encodeCursor(&SearchCursor{Offset: 2})
+	if err != nil {
+		t.Fatalf("failed to encode cursor: %v", err)
+	}
+
+	type wants struct {
+		code       int
+		nextCursor string
+		facets     ItemFacets
+	}
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: first page": {
+			query: "keyword=iPhone+OR+Android&limit=2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					SearchItemsByKeyword(gomock.Any(), SearchItemsOptions{Query: SearchQuery{{"iPhone", "Android"}}, Limit: 2}).
+					Return([]ItemSearchHit{{Item: Item{ID: 1, Name: "iPhone"}}, {Item: Item{ID: 2, Name: "Android"}}}, &SearchCursor{Offset: 2}, nil)
+				m.EXPECT().
+					CountItemFacets(gomock.Any(), SearchQuery{{"iPhone", "Android"}}, ItemFilter{}).
+					Return(ItemFacets{Categories: []FacetCount{{Value: "phone", Count: 3}}}, nil)
+			},
+			wants: wants{
+				code:       http.StatusOK,
+				nextCursor: nextCursor,
+				facets:     ItemFacets{Categories: []FacetCount{{Value: "phone", Count: 3}}},
+			},
+		},
+		"ok: next page": {
+			query: "keyword=iPhone&cursor=" + nextCursor,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					SearchItemsByKeyword(gomock.Any(), SearchItemsOptions{Query: SearchQuery{{"iPhone"}}, Limit: defaultItemsLimit, Offset: 2}).
+					Return(nil, nil, nil)
+				m.EXPECT().CountItemFacets(gomock.Any(), gomock.Any(), gomock.Any()).Return(ItemFacets{}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: filters without keyword": {
+			query: "category=phone&category=tablet&condition=new&status=on_sale&seller_id=3&min_price=1000&max_price=5000&created_from=2026-01-01&created_to=2026-02-01T09:00:00%2B09:00",
+			injector: func(m *MockItemRepository) {
+				minPrice, maxPrice := int64(1000), int64(5000)
+				filter := ItemFilter{
+					Categories:  []string{"phone", "tablet"},
+					Conditions:  []Condition{ConditionNew},
+					Statuses:    []ItemStatus{ItemStatusOnSale},
+					SellerID:    3,
+					MinPrice:    &minPrice,
+					MaxPrice:    &maxPrice,
+					CreatedFrom: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC),
+					CreatedTo:   time.Date(2026, 2, 1, 0, 0, 0, 0, time.UTC),
+				}
+				sameFilter := func(f ItemFilter) bool {
+					return cmp.Equal(filter, f, cmp.Comparer(func(a, b time.Time) bool { return a.Equal(b) }))
+				}
+				m.EXPECT().
+					SearchItemsByKeyword(gomock.Any(), gomock.Cond(func(opts SearchItemsOptions) bool {
+						return opts.Query == nil && sameFilter(opts.Filter)
+					})).
+					Return(nil, nil, nil)
+				m.EXPECT().
+					CountItemFacets(gomock.Any(), SearchQuery(nil), gomock.Cond(sameFilter)).
+					Return(ItemFacets{}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: unknown condition": {
+			query:    "condition=broken",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: min_price above max_price": {
+			query:    "min_price=5000&max_price=1000",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: malformed date": {
+			query:    "created_from=yesterday",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: keyword without terms": {
+			query:    "keyword=OR",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: invalid cursor": {
+			query:    "keyword=iPhone&cursor=%21",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("GET", "/search?"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+			h.SearchItemsByKeyword(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+
+			var got SearchItemsByKeywordResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if got.NextCursor != tt.wants.nextCursor {
+				t.Errorf("expected next cursor %q, got %q", tt.wants.nextCursor, got.NextCursor)
+			}
+			if diff := cmp.Diff(tt.wants.facets, got.Facets, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("unexpected facets (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestAddItemImages(t *testing.T) {
+	t.Parallel()
+
+	existing := Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "old.jpg", SellerID: 1}
+	seller := &AuthUser{ID: 1, Role: RoleSeller}
+	otherImageData := encodeTestImage(2)
+	otherImageName := fmt.Sprintf("%x.jpg", sha256.Sum256([]byte(otherImageData)))
+
+	type wants struct {
+		code   int
+		images []ItemImage
+	}
+	cases := map[string]struct {
+		images   [][]byte
+		user     *AuthUser
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: images are appended in order": {
+			images: [][]byte{[]byte(testImageData), []byte(otherImageData)},
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().AddImages(gomock.Any(), "1", []string{testImageName, otherImageName}).
+					Return([]ItemImage{{ID: 1, Name: "old.jpg"}, {ID: 2, Name: testImageName}, {ID: 3, Name: otherImageName}}, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+				images: []ItemImage{
+					{ID: 1, Name: "old.jpg", URL: "/images/old.jpg"},
+					{ID: 2, Name: testImageName, URL: "/images/" + testImageName},
+					{ID: 3, Name: otherImageName, URL: "/images/" + otherImageName},
+				},
+			},
+		},
+		"ng: no image": {
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: more images than an item can have": {
+			images:   slices.Repeat([][]byte{[]byte(testImageData)}, maxItemImages+1),
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusUnprocessableEntity},
+		},
+		"ng: item would have too many images": {
+			images: [][]byte{[]byte(testImageData)},
+			user:   seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().AddImages(gomock.Any(), "1", []string{testImageName}).Return(nil, errTooManyItemImages)
+			},
+			wants: wants{code: http.StatusUnprocessableEntity},
+		},
+		"ng: another seller": {
+			images: [][]byte{[]byte(testImageData)},
+			user:   &AuthUser{ID: 2, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+			},
+			wants: wants{code: http.StatusForbidden},
+		},
+		"ng: not signed in": {
+			images:   [][]byte{[]byte(testImageData)},
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusUnauthorized},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := newMultipartImagesRequest(t, "POST", "/items/1/images", nil, tt.images)
+			req.SetPathValue("item_id", "1")
+			if tt.user != nil {
+				req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+			h.AddItemImages(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Fatalf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+			if tt.wants.code != http.StatusOK {
+				return
+			}
+			var resp ItemImagesResponse
+			if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if diff := cmp.Diff(tt.wants.images, resp.Images); diff != "" {
+				t.Errorf("unexpected images (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDeleteItemImage(t *testing.T) {
+	t.Parallel()
+
+	existing := Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "old.jpg", SellerID: 1}
+	seller := &AuthUser{ID: 1, Role: RoleSeller}
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		imageID  string
+		user     *AuthUser
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: image is removed": {
+			imageID: "2",
+			user:    seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().DeleteImage(gomock.Any(), "1", 2).Return([]ItemImage{{ID: 1, Name: "old.jpg"}}, nil)
+			},
+			wants: wants{code: http.StatusOK},
+		},
+		"ng: last image": {
+			imageID: "1",
+			user:    seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().DeleteImage(gomock.Any(), "1", 1).Return(nil, errLastItemImage)
+			},
+			wants: wants{code: http.StatusConflict},
+		},
+		"ng: image of another item": {
+			imageID: "9",
+			user:    seller,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().DeleteImage(gomock.Any(), "1", 9).Return(nil, errItemImageNotFound)
+			},
+			wants: wants{code: http.StatusNotFound},
+		},
+		"ng: invalid image id": {
+			imageID:  "first",
+			user:     seller,
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: another seller": {
+			imageID: "2",
+			user:    &AuthUser{ID: 2, Role: RoleSeller},
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+			},
+			wants: wants{code: http.StatusForbidden},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("DELETE", "/items/1/images/"+tt.imageID, nil)
+			req.SetPathValue("item_id", "1")
+			req.SetPathValue("image_id", tt.imageID)
+			req = req.WithContext(withAuthUser(req.Context(), *tt.user))
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+			h.DeleteItemImage(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestReorderItemImages(t *testing.T) {
+	t.Parallel()
+
+	existing := Item{ID: 1, Name: "used iPhone 16e", Category: "phone", Image: "a.jpg", SellerID: 1}
+	seller := &AuthUser{ID: 1, Role: RoleSeller}
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		imageIDs string
+		// imageData sends the form as multipart with an image part, which the request should not have.
+		imageData []byte
+		injector  func(m *MockItemRepository)
+		wants
+	}{
+		"ok: images are reordered": {
+			imageIDs: "3, 1,2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().ReorderImages(gomock.Any(), "1", []int{3, 1, 2}).
+					Return([]ItemImage{{ID: 3, Name: "c.jpg"}, {ID: 1, Name: "a.jpg"}, {ID: 2, Name: "b.jpg"}}, nil)
+			},
+			wants: wants{code: http.StatusOK},
+		},
+		"ng: missing image ids": {
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: not a list of ids": {
+			imageIDs: "1,b",
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusBadRequest},
+		},
+		"ng: id listed twice": {
+			imageIDs: "1,1",
+			injector: func(m *MockItemRepository) {},
+			wants:    wants{code: http.StatusUnprocessableEntity},
+		},
+		"ng: body too large": {
+			imageIDs:  "1,2",
+			imageData: bytes.Repeat([]byte("x"), maxFormFieldsSize+1),
+			injector:  func(m *MockItemRepository) {},
+			wants:     wants{code: http.StatusRequestEntityTooLarge},
+		},
+		"ng: images left out": {
+			imageIDs: "2",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().GetItemById(gomock.Any(), "1").Return(existing, nil)
+				m.EXPECT().ReorderImages(gomock.Any(), "1", []int{2}).Return(nil, errItemImageOrder)
+			},
+			wants: wants{code: http.StatusUnprocessableEntity},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			form := url.Values{}
+			if tt.imageIDs != "" {
+				form.Set("image_ids", tt.imageIDs)
+			}
+			req := httptest.NewRequest("PUT", "/items/1/images/order", strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			if tt.imageData != nil {
+				req = newMultipartRequest(t, "PUT", "/items/1/images/order", map[string]string{"image_ids": tt.imageIDs}, tt.imageData)
+			}
+			req.SetPathValue("item_id", "1")
+			req = req.WithContext(withAuthUser(req.Context(), *seller))
+
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+			h.ReorderItemImages(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d: %s", tt.wants.code, rr.Code, rr.Body.String())
+			}
+		})
+	}
+}
+
+// newMultipartRequest builds a multipart/form-data request with the given fields and an optional image part.
+func newMultipartRequest(t *testing.T, method, target string, args map[string]string, imageData []byte) *http.Request {
+	t.Helper()
+
+	var images [][]byte
+	if imageData != nil {
+		images = [][]byte{imageData}
+	}
+	return newMultipartImagesRequest(t, method, target, args, images)
+}
+
+// newMultipartImagesRequest builds a multipart/form-data request with the given fields and an image part per image.
+func newMultipartImagesRequest(t *testing.T, method, target string, args map[string]string, images [][]byte) *http.Request {
+	t.Helper()
+
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+
+	for k, v := range args {
+		if err := writer.WriteField(k, v); err != nil {
+			t.Fatalf("failed to write field %s: %v", k, err)
+		}
+	}
+
+	for _, imageData := range images {
+		part, err := writer.CreateFormFile("image", "test.jpg")
+		if err != nil {
+			t.Fatalf("failed to create file part: %v", err)
+		}
+		if _, err := part.Write(imageData); err != nil {
+			t.Fatalf("failed to write image data: %v", err)
+		}
+	}
+
+	writer.Close()
+
+	req := httptest.NewRequest(method, target, body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestGetAllItem(t *testing.T) {
+	t.Parallel()
+
+	nextCursor := &ItemCursor{Sort: ItemSortName, Order: SortDesc, Value: "b", ID: 2}
+	encodedNext, err := encodeCursor(nextCursor)
+	if err != nil {
+		t.Fatalf("failed to encode cursor: %v", err)
+	}
+
+	type wants struct {
+		code       int
+		nextCursor string
+	}
+	cases := map[string]struct {
+		query    string
+		injector func(m *MockItemRepository)
+		wants
+	}{
+		"ok: defaults are applied": {
+			query: "",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					GetAllItem(gomock.Any(), ListItemsOptions{Limit: defaultItemsLimit, Sort: ItemSortCreatedAt, Order: SortAsc}).
+					Return([]Item{{ID: 1, Name: "a"}}, nil, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: next cursor is returned": {
+			query: "?limit=2&sort=name&order=desc",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					GetAllItem(gomock.Any(), ListItemsOptions{Limit: 2, Sort: ItemSortName, Order: SortDesc}).
+					Return([]Item{{ID: 3, Name: "c"}, {ID: 2, Name: "b"}}, nextCursor, nil)
+			},
+			wants: wants{
+				code:       http.StatusOK,
+				nextCursor: encodedNext,
+			},
+		},
+		"ok: sort and order are taken from the cursor": {
+			query: "?limit=2&cursor=" + encodedNext,
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					GetAllItem(gomock.Any(), ListItemsOptions{Limit: 2, Sort: ItemSortName, Order: SortDesc, After: nextCursor}).
+					Return([]Item{{ID: 1, Name: "a"}}, nil, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ok: filtered by seller": {
+			query: "?seller_id=3",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					GetAllItem(gomock.Any(), ListItemsOptions{SellerID: 3, Limit: defaultItemsLimit, Sort: ItemSortCreatedAt, Order: SortAsc}).
+					Return([]Item{{ID: 1, Name: "a", SellerID: 3}}, nil, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: invalid seller id": {
+			query:    "?seller_id=abc",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: cursor issued for another sort": {
+			query:    "?sort=id&cursor=" + encodedNext,
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: broken cursor": {
+			query:    "?cursor=not-a-cursor",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: limit out of range": {
+			query:    "?limit=1000",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: unknown sort key": {
+			query:    "?sort=price",
+			injector: func(m *MockItemRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: failed to get items": {
+			query: "",
+			injector: func(m *MockItemRepository) {
+				m.EXPECT().
+					GetAllItem(gomock.Any(), gomock.Any()).
+					Return(nil, nil, errors.New("database error"))
+			},
+			wants: wants{
+				code: http.StatusInternalServerError,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockIR := NewMockItemRepository(ctrl)
+			tt.injector(mockIR)
+
+			req := httptest.NewRequest("GET", "/items"+tt.query, nil)
+			rr := httptest.NewRecorder()
+			h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: mockIR}
+			h.GetAllItem(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+
+			var got GetAllItemResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			if got.NextCursor != tt.wants.nextCursor {
+				t.Errorf("expected next_cursor %q, got %q", tt.wants.nextCursor, got.NextCursor)
+			}
+		})
+	}
+}
+
+func TestRegisterUser(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		args     map[string]string
+		injector func(m *MockUserRepository)
+		wants
+	}{
+		"ok: registered": {
+			args: map[string]string{"name": "mercari", "email": " Mercari@Example.com", "password": "password123"},
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().
+					Insert(gomock.Any(), gomock.Cond(func(u *User) bool {
+						return u.Email == "mercari@example.com" && bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte("password123")) == nil
+					})).
+					Return(nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: missing password": {
+			args:     map[string]string{"name": "mercari", "email": "mercari@example.com"},
+			injector: func(m *MockUserRepository) {},
+			wants: wants{
+				code: http.StatusBadRequest,
+			},
+		},
+		"ng: short password": {
+			args:     map[string]string{"name": "mercari", "email": "mercari@example.com", "password": "pass"},
+			injector: func(m *MockUserRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: invalid email": {
+			args:     map[string]string{"name": "mercari", "email": "mercari", "password": "password123"},
+			injector: func(m *MockUserRepository) {},
+			wants: wants{
+				code: http.StatusUnprocessableEntity,
+			},
+		},
+		"ng: email already registered": {
+			args: map[string]string{"name": "mercari", "email": "mercari@example.com", "password": "password123"},
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().Insert(gomock.Any(), gomock.Any()).Return(errEmailDuplicate)
+			},
+			wants: wants{
+				code: http.StatusConflict,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockUR := NewMockUserRepository(ctrl)
+			tt.injector(mockUR)
+
+			req := newMultipartRequest(t, "POST", "/users", tt.args, nil)
+			rr := httptest.NewRecorder()
+			h := &Handlers{userRepo: mockUR}
+			h.RegisterUser(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if strings.Contains(rr.Body.String(), "password") && rr.Code == http.StatusOK {
+				t.Errorf("response body leaks the password hash: %s", rr.Body.String())
+			}
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	t.Parallel()
+
+	hash, err := bcrypt.GenerateFromPassword([]byte("password123"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	user := User{ID: 3, Name: "mercari", Email: "mercari@example.com", PasswordHash: string(hash), Role: RoleSeller}
+	auth := NewAuthenticator([]byte("secret"), time.Hour)
+
+	type wants struct {
+		code int
+	}
+	cases := map[string]struct {
+		args     map[string]string
+		injector func(m *MockUserRepository)
+		wants
+	}{
+		"ok: logged in": {
+			args: map[string]string{"email": "mercari@example.com", "password": "password123"},
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().GetUserByEmail(gomock.Any(), "mercari@example.com").Return(user, nil)
+			},
+			wants: wants{
+				code: http.StatusOK,
+			},
+		},
+		"ng: wrong password": {
+			args: map[string]string{"email": "mercari@example.com", "password": "password456"},
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().GetUserByEmail(gomock.Any(), "mercari@example.com").Return(user, nil)
+			},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+		"ng: unknown email": {
+			args: map[string]string{"email": "nobody@example.com", "password": "password123"},
+			injector: func(m *MockUserRepository) {
+				m.EXPECT().GetUserByEmail(gomock.Any(), "nobody@example.com").Return(User{}, errUserNotFound)
+			},
+			wants: wants{
+				code: http.StatusUnauthorized,
+			},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			ctrl := gomock.NewController(t)
+
+			mockUR := NewMockUserRepository(ctrl)
+			tt.injector(mockUR)
+
+			req := newMultipartRequest(t, "POST", "/login", tt.args, nil)
+			rr := httptest.NewRecorder()
+			h := &Handlers{userRepo: mockUR, auth: auth}
+			h.Login(rr, req)
+
+			if tt.wants.code != rr.Code {
+				t.Errorf("expected status code %d, got %d", tt.wants.code, rr.Code)
+			}
+			if tt.wants.code >= 400 {
+				return
+			}
+
+			var got LoginResponse
+			if err := json.NewDecoder(rr.Body).Decode(&got); err != nil {
+				t.Fatalf("failed to decode response body: %v", err)
+			}
+			authUser, err := auth.ParseToken(got.Token)
+			if err != nil {
+				t.Fatalf("issued token is invalid: %v", err)
+			}
+			if authUser.ID != user.ID {
+				t.Errorf("expected token for user %d, got %d", user.ID, authUser.ID)
+			}
+		})
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	cfg := DefaultConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "mercari.sqlite3")
+	cfg.ImageDir = t.TempDir()
+	cfg.Images.GCInterval = time.Hour
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	base := "http://" + ln.Addr().String()
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- Server{Config: cfg}.Serve(ctx, ln)
+	}()
+
+	// wait for the server to be up
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		res, err := http.Get(base + "/")
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				t.Fatalf("expected status %d, got %d", http.StatusOK, res.StatusCode)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not start: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// a request in flight when the server is asked to stop is still answered
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+	body := url.Values{"email": {"gopher@example.com"}, "password": {"secret"}}.Encode()
+	if _, err := fmt.Fprintf(conn, "POST /login HTTP/1.1\r\nHost: %s\r\nContent-Type: application/x-www-form-urlencoded\r\nContent-Length: %d\r\n\r\n%s",
+		ln.Addr(), len(body), body[:10]); err != nil {
+		t.Fatalf("failed to start the request: %v", err)
+	}
+	// give the server time to read the request header
+	time.Sleep(100 * time.Millisecond)
+
+	cancel()
+	select {
+	case err := <-served:
+		t.Fatalf("server stopped before the request in flight finished: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := io.WriteString(conn, body[10:]); err != nil {
+		t.Fatalf("failed to finish the request: %v", err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatalf("failed to read the response: %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected status %d, got %d", http.StatusUnauthorized, res.StatusCode)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected a graceful shutdown, got %v", err)
+		}
+	case <-time.After(cfg.Timeouts.Shutdown):
+		t.Fatal("server did not shut down")
+	}
+
+	// the listener is closed, so new connections are refused
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("expected the listener to be closed")
+	}
+}
+
+// TestServeShutdownDelay checks that the server fails readiness but keeps serving for the shutdown delay.
+func TestServeShutdownDelay(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	cfg := DefaultConfig()
+	cfg.DBPath = filepath.Join(t.TempDir(), "mercari.sqlite3")
+	cfg.ImageDir = t.TempDir()
+	cfg.Timeouts.ShutdownDelay = 500 * time.Millisecond
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	base := "http://" + ln.Addr().String()
+	// a new connection per request, so that each one shows whether the listener still accepts them
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	readyz := func() (int, error) {
+		res, err := client.Get(base + "/readyz")
+		if err != nil {
+			return 0, err
+		}
+		res.Body.Close()
+		return res.StatusCode, nil
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	served := make(chan error, 1)
+	go func() {
+		served <- Server{Config: cfg}.Serve(ctx, ln)
+	}()
+
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		code, err := readyz()
+		if err == nil && code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("server did not become ready: %d, %v", code, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := time.Now()
+	cancel()
+	// give the server time to notice
+	time.Sleep(50 * time.Millisecond)
+	if code, err := readyz(); err != nil || code != http.StatusServiceUnavailable {
+		t.Errorf("expected status %d during the shutdown delay, got %d, %v", http.StatusServiceUnavailable, code, err)
+	}
+	res, err := client.Get(base + "/")
+	if err != nil {
+		t.Fatalf("expected requests to be served during the shutdown delay, got %v", err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("expected status %d during the shutdown delay, got %d", http.StatusOK, res.StatusCode)
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("expected a graceful shutdown, got %v", err)
+		}
+		if elapsed := time.Since(stopped); elapsed < cfg.Timeouts.ShutdownDelay {
+			t.Errorf("expected the server to stop after the delay of %s, it stopped after %s", cfg.Timeouts.ShutdownDelay, elapsed)
+		}
+	case <-time.After(cfg.Timeouts.ShutdownDelay + cfg.Timeouts.Shutdown):
+		t.Fatal("server did not shut down")
+	}
+}
+
+func setupDB(t *testing.T) (db *sql.DB, closers []func(), e error) {
+	t.Helper()
+
+	defer func() {
+		if e != nil {
+			for _, c := range closers {
+				c()
+			}
+		}
+	}()
+
+	f, err := os.CreateTemp(".", "*.sqlite3")
+	if err != nil {
+		return nil, nil, err
+	}
+	closers = append(closers, func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+
+	db, err = sql.Open("sqlite3", f.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+	closers = append(closers, func() {
+		db.Close()
+	})
+
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Errorf("failed to load migrations: %v", err)
+		return nil, nil, err
+	}
+
+	if _, err := migrator.Up(t.Context()); err != nil {
+		t.Errorf("failed to migrate database: %v", err)
 		return nil, nil, err
 	}
 
@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// tracerName names the instrumentation of this package in the spans it records.
+const tracerName = "mercari-build-training/app"
+
+// serviceName identifies the server among the services sending spans to the same collector.
+const serviceName = "mercari-build-training"
+
+// NewTracerProvider creates the tracer provider for the exporter of cfg: "none" records nothing, "stdout" writes
+// the spans to the standard output, and "otlp" sends them to a collector over OTLP/HTTP. The returned function
+// flushes the spans not exported yet and stops the provider.
+func NewTracerProvider(ctx context.Context, cfg *Config) (trace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch cfg.Tracing.Exporter {
+	case "none":
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case "stdout":
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create stdout exporter: %w", err)
+		}
+		exporter = e
+	case "otlp":
+		var opts []otlptracehttp.Option
+		// without an endpoint, the exporter reads the OTEL_EXPORTER_OTLP_* variables and defaults to localhost:4318
+		if cfg.Tracing.OTLPEndpoint != "" {
+			opts = append(opts, otlptracehttp.WithEndpointURL(cfg.Tracing.OTLPEndpoint))
+		}
+		e, err := otlptracehttp.New(ctx, opts...)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+		}
+		exporter = e
+	default:
+		return nil, nil, fmt.Errorf("unknown trace exporter %q: must be none, stdout or otlp", cfg.Tracing.Exporter)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(
+			attribute.String("service.name", serviceName),
+			attribute.String("service.version", buildVersion().Version),
+		)),
+	)
+	return tp, tp.Shutdown, nil
+}
+
+// startSpan starts a span named name as a child of the span of ctx. The tracer comes from the provider of that span,
+// so only tracingMiddleware needs to know the provider, and nothing is recorded outside a traced request.
+func startSpan(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// endSpan ends span, marking it as failed with err unless err is nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracingMiddleware records a span for each request, named after the pattern of mux that routes it like the metrics.
+// The span continues the trace of a W3C traceparent header, so that the server shows up in the traces of its clients.
+func tracingMiddleware(next http.Handler, mux *http.ServeMux, tp trace.TracerProvider) http.Handler {
+	propagator := propagation.TraceContext{}
+	tracer := tp.Tracer(tracerName)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, route := mux.Handler(r)
+		name := route
+		if route == "" {
+			route = unmatchedRoute
+			name = methodLabel(r.Method) + " " + unmatchedRoute
+		}
+
+		ctx := propagator.Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+			),
+		)
+		defer span.End()
+
+		rec := &responseRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		status := rec.statusCode()
+		span.SetAttributes(
+			attribute.Int("http.response.status_code", status),
+			attribute.Int64("http.response.body.size", rec.bytes),
+		)
+		// client errors are the client's; only server errors fail the span
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
@@ -0,0 +1,253 @@
+package app
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// newTestTracerProvider returns a tracer provider exporting the spans to an in-memory exporter as soon as they end.
+func newTestTracerProvider(t *testing.T) (*sdktrace.TracerProvider, *tracetest.InMemoryExporter) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(func() { tp.Shutdown(t.Context()) })
+	return tp, exporter
+}
+
+// spanNames returns the names of spans keyed by the names of their parents, "" for the roots.
+func spanNames(spans tracetest.SpanStubs) map[string][]string {
+	names := map[trace.SpanID]string{}
+	for _, span := range spans {
+		names[span.SpanContext.SpanID()] = span.Name
+	}
+	children := map[string][]string{}
+	for _, span := range spans {
+		parent := names[span.Parent.SpanID()]
+		children[parent] = append(children[parent], span.Name)
+	}
+	return children
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	t.Parallel()
+
+	const (
+		traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+		// traceparent is a W3C trace context header a client would send
+		traceparent = "00-" + traceID + "-00f067aa0ba902b7-01"
+	)
+
+	type wants struct {
+		name   string
+		status int
+		failed bool
+	}
+	cases := map[string]struct {
+		method, path string
+		traceparent  string
+		wants
+	}{
+		"ok: route pattern": {
+			method: "GET", path: "/items/1",
+			wants: wants{name: "GET /items/{item_id}", status: http.StatusOK},
+		},
+		"ok: client trace continued": {
+			method: "GET", path: "/items/2", traceparent: traceparent,
+			wants: wants{name: "GET /items/{item_id}", status: http.StatusOK},
+		},
+		"ok: client error": {
+			method: "POST", path: "/items",
+			wants: wants{name: "POST /items", status: http.StatusBadRequest},
+		},
+		"ng: server error": {
+			method: "DELETE", path: "/items/1",
+			wants: wants{name: "DELETE /items/{item_id}", status: http.StatusInternalServerError, failed: true},
+		},
+		"ok: unmatched": {
+			method: "GET", path: "/no/such/path",
+			wants: wants{name: "GET unmatched", status: http.StatusNotFound},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tp, exporter := newTestTracerProvider(t)
+			mux := http.NewServeMux()
+			mux.HandleFunc("GET /items/{item_id}", func(w http.ResponseWriter, r *http.Request) {
+				_, span := startSpan(r.Context(), "child")
+				span.End()
+			})
+			mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, newError(ErrInvalidRequest, "name is required"))
+			})
+			mux.HandleFunc("DELETE /items/{item_id}", func(w http.ResponseWriter, r *http.Request) {
+				writeError(w, r, internalError("failed to delete item", io.ErrUnexpectedEOF))
+			})
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.traceparent != "" {
+				req.Header.Set("traceparent", tt.traceparent)
+			}
+			rr := httptest.NewRecorder()
+			tracingMiddleware(mux, mux, tp).ServeHTTP(rr, req)
+
+			var server tracetest.SpanStub
+			for _, span := range exporter.GetSpans() {
+				if span.SpanKind == trace.SpanKindServer {
+					server = span
+				}
+			}
+			if server.Name != tt.wants.name {
+				t.Fatalf("expected a server span named %q, got %v", tt.wants.name, exporter.GetSpans())
+			}
+			attrs := map[string]any{}
+			for _, attr := range server.Attributes {
+				attrs[string(attr.Key)] = attr.Value.AsInterface()
+			}
+			if got := attrs["http.response.status_code"]; got != int64(tt.wants.status) {
+				t.Errorf("expected the status code %d, got %v", tt.wants.status, got)
+			}
+			if failed := server.Status.Code.String() == "Error"; failed != tt.wants.failed {
+				t.Errorf("expected the span to have failed: %v, got %v", tt.wants.failed, server.Status)
+			}
+			if tt.wants.failed && len(server.Events) == 0 {
+				t.Error("expected the error to be recorded on the span")
+			}
+			if tt.traceparent != "" {
+				if got := server.SpanContext.TraceID().String(); got != traceID {
+					t.Errorf("expected the trace %s of the client to be continued, got %s", traceID, got)
+				}
+				if !server.Parent.IsRemote() {
+					t.Error("expected the server span to have a remote parent")
+				}
+			}
+			// spans started by handlers are children of the server span
+			for _, span := range exporter.GetSpans() {
+				if span.Name == "child" && span.Parent.SpanID() != server.SpanContext.SpanID() {
+					t.Errorf("expected the span of the handler to be a child of the server span, got %v", span.Parent)
+				}
+			}
+		})
+	}
+}
+
+func TestAddItemTracing(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping e2e test")
+	}
+
+	db, closers, err := setupDB(t)
+	if err != nil {
+		t.Fatalf("failed to set up database: %v", err)
+	}
+	t.Cleanup(func() {
+		for _, c := range closers {
+			c()
+		}
+	})
+
+	tp, exporter := newTestTracerProvider(t)
+	h := &Handlers{images: NewFileImageStore(t.TempDir()), itemRepo: NewItemRepository(db, nil)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /items", h.AddItem)
+
+	req := newMultipartRequest(t, "POST", "/items", map[string]string{"name": "camera", "category": "camera", "price": "1000", "condition": "new"}, []byte(testImageData))
+	req = req.WithContext(withAuthUser(req.Context(), AuthUser{ID: 1, Role: RoleSeller}))
+	rr := httptest.NewRecorder()
+	tracingMiddleware(mux, mux, tp).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status %d, got %d: %s", http.StatusOK, rr.Code, rr.Body)
+	}
+
+	want := map[string][]string{
+		"":            {"POST /items"},
+		"POST /items": {"parseAddItemRequest", "storeImage", "ItemRepository.Insert"},
+		"storeImage":  {"processImage"},
+	}
+	if diff := cmp.Diff(want, spanNames(exporter.GetSpans())); diff != "" {
+		t.Errorf("unexpected spans (-want +got):\n%s", diff)
+	}
+}
+
+// TestNewTracerProvider sends spans over OTLP to a collector running in the test.
+func TestNewTracerProvider(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	var received []string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" {
+			http.NotFound(w, r)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("failed to read the export request: %v", err)
+			return
+		}
+		var export coltracepb.ExportTraceServiceRequest
+		if err := proto.Unmarshal(body, &export); err != nil {
+			t.Errorf("failed to decode the export request: %v", err)
+			return
+		}
+		mu.Lock()
+		for _, rs := range export.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					received = append(received, span.Name)
+				}
+			}
+		}
+		mu.Unlock()
+
+		resp, _ := proto.Marshal(&coltracepb.ExportTraceServiceResponse{})
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		w.Write(resp)
+	}))
+	t.Cleanup(collector.Close)
+
+	cfg := DefaultConfig()
+	cfg.Tracing.Exporter = "otlp"
+	cfg.Tracing.OTLPEndpoint = collector.URL
+	tp, shutdown, err := NewTracerProvider(t.Context(), cfg)
+	if err != nil {
+		t.Fatalf("failed to create tracer provider: %v", err)
+	}
+	_, span := tp.Tracer(tracerName).Start(t.Context(), "GET /items")
+	span.End()
+	// shutting down flushes the spans
+	if err := shutdown(t.Context()); err != nil {
+		t.Fatalf("failed to shut down tracer provider: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if diff := cmp.Diff([]string{"GET /items"}, received); diff != "" {
+		t.Errorf("unexpected spans received by the collector (-want +got):\n%s", diff)
+	}
+
+	t.Run("ok: none records nothing", func(t *testing.T) {
+		tp, shutdown, err := NewTracerProvider(t.Context(), DefaultConfig())
+		if err != nil {
+			t.Fatalf("failed to create tracer provider: %v", err)
+		}
+		defer shutdown(t.Context())
+		_, span := tp.Tracer(tracerName).Start(t.Context(), "GET /items")
+		if span.IsRecording() {
+			t.Error("expected no span to be recorded")
+		}
+	})
+}
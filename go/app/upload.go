@@ -0,0 +1,181 @@
+package app
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"os"
+)
+
+const (
+	// defaultMaxImageSize is the size limit of an uploaded image when Handlers.maxImageSize is not set.
+	defaultMaxImageSize = 10 << 20
+	// maxFormFieldsSize bounds the fields of an upload form other than its images, along with the part headers.
+	maxFormFieldsSize = 1 << 20
+)
+
+var errRequestTooLarge error = newError(ErrTooLarge, "request body is too large")
+
+// imageUploadTooLarge returns the error for an uploaded image larger than maxImageSize bytes.
+func imageUploadTooLarge(maxImageSize int64) error {
+	return fieldError(ErrTooLarge, "image", fmt.Sprintf("image must be at most %d bytes", maxImageSize))
+}
+
+// imageUpload is an uploaded image staged in a temporary file.
+// Its size and SHA-256 are computed while it is written, so that it is never held in memory as a whole.
+type imageUpload struct {
+	file *os.File
+	size int64
+	sum  [sha256.Size]byte
+}
+
+// Close closes and removes the temporary file of the upload.
+func (u *imageUpload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// closeUploads closes every upload.
+func closeUploads(uploads []*imageUpload) {
+	for _, u := range uploads {
+		u.Close()
+	}
+}
+
+// uploadBodyLimit is the largest body an upload request may have:
+// maxItemImages images of maxImageSize bytes along with the other fields.
+func uploadBodyLimit(maxImageSize int64) int64 {
+	return maxItemImages*maxImageSize + maxFormFieldsSize
+}
+
+// limitFormBody caps the body of a request that sends form fields but no images at maxFormFieldsSize,
+// so that a multipart form cannot stage a large file on the disk.
+func limitFormBody(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormFieldsSize)
+}
+
+// parseFieldsForm parses the URL-encoded or multipart form of a request whose body limitFormBody capped.
+func parseFieldsForm(r *http.Request) error {
+	// ParseMultipartForm drops the error of ParseForm when the body is not multipart
+	err := r.ParseForm()
+	if err == nil {
+		err = r.ParseMultipartForm(maxFormFieldsSize)
+	}
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case err == nil, errors.Is(err, http.ErrNotMultipart):
+		return nil
+	case errors.As(err, &maxBytesErr):
+		return errRequestTooLarge
+	}
+	return newError(ErrInvalidRequest, "failed to parse form")
+}
+
+// parseUploadForm reads the multipart form of r part by part, staging the file of each image part in
+// a temporary file instead of memory. It fails as soon as an image exceeds maxImageSize bytes.
+// The other fields are stored in r.Form, r.PostForm and r.MultipartForm, where they are read
+// as after r.ParseMultipartForm. The caller closes the returned uploads.
+func parseUploadForm(r *http.Request, maxImageSize int64) (uploads []*imageUpload, err error) {
+	// the query is parsed first, since ParseForm leaves multipart bodies alone
+	if err := r.ParseForm(); err != nil {
+		return nil, newError(ErrInvalidRequest, "failed to parse form")
+	}
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, newError(ErrInvalidRequest, "failed to parse multipart form")
+	}
+
+	defer func() {
+		if err != nil {
+			closeUploads(uploads)
+			uploads = nil
+		}
+	}()
+
+	values := url.Values{}
+	fieldsSize := int64(0)
+	for {
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return uploads, uploadError(err)
+		}
+
+		name := part.FormName()
+		switch {
+		case part.FileName() == "":
+			value, err := io.ReadAll(io.LimitReader(part, maxFormFieldsSize-fieldsSize+1))
+			if err != nil {
+				return uploads, uploadError(err)
+			}
+			if fieldsSize += int64(len(value)); fieldsSize > maxFormFieldsSize {
+				return uploads, errRequestTooLarge
+			}
+			values.Add(name, string(value))
+		case name == "image":
+			if len(uploads) == maxItemImages {
+				return uploads, errTooManyItemImages
+			}
+			upload, err := stageImage(part, maxImageSize)
+			if err != nil {
+				return uploads, err
+			}
+			uploads = append(uploads, upload)
+		}
+		part.Close()
+	}
+
+	for name, vs := range values {
+		r.Form[name] = append(r.Form[name], vs...)
+	}
+	r.PostForm = values
+	r.MultipartForm = &multipart.Form{Value: values}
+	return uploads, nil
+}
+
+// stageImage writes an uploaded image to a temporary file, hashing it on the way.
+func stageImage(src io.Reader, maxImageSize int64) (*imageUpload, error) {
+	file, err := os.CreateTemp("", "upload-*")
+	if err != nil {
+		return nil, internalError("failed to stage image", err)
+	}
+	upload := &imageUpload{file: file}
+
+	h := sha256.New()
+	// one byte more than allowed tells a too large image from one of exactly the maximum size
+	upload.size, err = io.Copy(io.MultiWriter(file, h), io.LimitReader(src, maxImageSize+1))
+	switch {
+	case err != nil:
+		upload.Close()
+		return nil, uploadError(err)
+	case upload.size > maxImageSize:
+		upload.Close()
+		return nil, imageUploadTooLarge(maxImageSize)
+	case upload.size == 0:
+		upload.Close()
+		return nil, fieldError(ErrValidation, "image", "image must not be empty")
+	}
+	h.Sum(upload.sum[:0])
+	return upload, nil
+}
+
+// uploadError classifies an error met while reading an upload.
+// Writing the temporary file fails on the server side; anything else is a broken or too large request.
+func uploadError(err error) error {
+	var maxBytesErr *http.MaxBytesError
+	var pathErr *fs.PathError
+	switch {
+	case errors.As(err, &maxBytesErr):
+		return errRequestTooLarge
+	case errors.As(err, &pathErr):
+		return internalError("failed to stage image", err)
+	}
+	return &Error{Kind: ErrInvalidRequest, Message: "failed to read multipart form", Err: err}
+}
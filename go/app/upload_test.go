@@ -0,0 +1,167 @@
+package app
+
+import (
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+// readUploads returns the content of each upload and closes them.
+func readUploads(t *testing.T, uploads []*imageUpload) []string {
+	t.Helper()
+	defer closeUploads(uploads)
+
+	var contents []string
+	for _, upload := range uploads {
+		if _, err := upload.file.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("failed to rewind upload: %v", err)
+		}
+		data, err := io.ReadAll(upload.file)
+		if err != nil {
+			t.Fatalf("failed to read upload: %v", err)
+		}
+		contents = append(contents, string(data))
+	}
+	return contents
+}
+
+func TestParseUploadForm(t *testing.T) {
+	t.Parallel()
+
+	type wants struct {
+		images []string
+		form   map[string][]string
+		// kind is the kind of the error, nil when parsing succeeds.
+		kind error
+	}
+	cases := map[string]struct {
+		target string
+		args   map[string]string
+		images [][]byte
+		// bodyLimit caps the request body like Handlers.limitUploadBody when set.
+		bodyLimit int64
+		wants
+	}{
+		"ok: fields and images": {
+			target: "/items?source=app",
+			args:   map[string]string{"name": "camera"},
+			images: [][]byte{[]byte("front"), []byte("back")},
+			wants: wants{
+				images: []string{"front", "back"},
+				form:   map[string][]string{"name": {"camera"}, "source": {"app"}},
+			},
+		},
+		"ok: image of exactly the maximum size": {
+			target: "/items",
+			images: [][]byte{[]byte(strings.Repeat("x", 16))},
+			wants: wants{
+				images: []string{strings.Repeat("x", 16)},
+				form:   map[string][]string{},
+			},
+		},
+		"ng: too large image": {
+			target: "/items",
+			images: [][]byte{[]byte(strings.Repeat("x", 17))},
+			wants:  wants{kind: ErrTooLarge},
+		},
+		"ng: empty image": {
+			target: "/items",
+			images: [][]byte{{}},
+			wants:  wants{kind: ErrValidation},
+		},
+		"ng: too many images": {
+			target: "/items",
+			images: func() [][]byte {
+				images := make([][]byte, maxItemImages+1)
+				for i := range images {
+					images[i] = []byte("x")
+				}
+				return images
+			}(),
+			wants: wants{kind: ErrValidation},
+		},
+		"ng: too large fields": {
+			target: "/items",
+			args:   map[string]string{"description": strings.Repeat("x", maxFormFieldsSize+1)},
+			wants:  wants{kind: ErrTooLarge},
+		},
+		"ng: too large body": {
+			target:    "/items",
+			images:    [][]byte{[]byte(strings.Repeat("x", 16))},
+			bodyLimit: 64,
+			wants:     wants{kind: ErrTooLarge},
+		},
+	}
+
+	for name, tt := range cases {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			req := newMultipartImagesRequest(t, "POST", tt.target, tt.args, tt.images)
+			if tt.bodyLimit > 0 {
+				req.Body = http.MaxBytesReader(httptest.NewRecorder(), req.Body, tt.bodyLimit)
+			}
+
+			uploads, err := parseUploadForm(req, 16)
+			if tt.kind != nil {
+				if !errors.Is(err, tt.kind) {
+					t.Errorf("expected error of kind %v, got %v", tt.kind, err)
+				}
+				if uploads != nil {
+					closeUploads(uploads)
+					t.Error("expected no uploads with an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			for i, upload := range uploads {
+				if upload.size != int64(len(tt.images[i])) {
+					t.Errorf("expected image %d to be %d bytes, got %d", i, len(tt.images[i]), upload.size)
+				}
+				if upload.sum != sha256.Sum256(tt.images[i]) {
+					t.Errorf("unexpected hash of image %d: %x", i, upload.sum)
+				}
+			}
+			names := make([]string, len(uploads))
+			for i, upload := range uploads {
+				names[i] = upload.file.Name()
+			}
+			if diff := cmp.Diff(tt.wants.images, readUploads(t, uploads)); diff != "" {
+				t.Errorf("unexpected images (-want +got):\n%s", diff)
+			}
+			for _, name := range names {
+				if _, err := os.Stat(name); !errors.Is(err, os.ErrNotExist) {
+					t.Errorf("expected %s to be removed once closed, got %v", name, err)
+				}
+			}
+
+			if diff := cmp.Diff(tt.form, map[string][]string(req.Form)); diff != "" {
+				t.Errorf("unexpected form (-want +got):\n%s", diff)
+			}
+			// the query is part of the form but not of the post form
+			if _, ok := req.PostForm["source"]; ok {
+				t.Error("expected the post form not to hold the query")
+			}
+		})
+	}
+}
+
+func TestParseUploadFormNotMultipart(t *testing.T) {
+	t.Parallel()
+
+	req := httptest.NewRequest("POST", "/items", strings.NewReader("name=camera"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if _, err := parseUploadForm(req, 16); !errors.Is(err, ErrInvalidRequest) {
+		t.Errorf("expected an invalid request error, got %v", err)
+	}
+}
@@ -1,22 +1,24 @@
 package main
 
 import (
+	"errors"
+	"flag"
+	"fmt"
 	"mercari-build-training/app"
 	"os"
 )
 
-const (
-	port         = "9000"
-	imageDirPath = "images"
-	dbPath       = "db/mercari.sqlite3"
-)
-
 func main() {
 	// This is the entry point of the application.
-	// You don't need to modify this function.
-	os.Exit(app.Server{
-		Port:         port,
-		ImageDirPath: imageDirPath,
-		DBPath:       dbPath,
-	}.Run())
+	// The server is configured by flags, environment variables and an optional file; run with -help to list them.
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	cfg, err := app.LoadConfig(fs, os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "api:", err)
+		os.Exit(2)
+	}
+	os.Exit(app.Server{Config: cfg}.Run())
 }
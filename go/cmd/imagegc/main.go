@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"mercari-build-training/app"
+	"os"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const usage = `usage: imagegc [-config file] [-db path] [-images dir] [-image-gc-grace-period duration] [-dry-run]
+
+Deletes the stored images no item refers to, along with their thumbnails.
+It reads the configuration of the server, so the database and the image store are the ones the server uses.
+`
+
+func main() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "report the orphaned images without deleting them")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), usage)
+		fs.PrintDefaults()
+	}
+	cfg, err := app.LoadConfig(fs, os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "imagegc:", err)
+		os.Exit(2)
+	}
+
+	if err := run(context.Background(), cfg, *dryRun); err != nil {
+		fmt.Fprintln(os.Stderr, "imagegc:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, cfg *app.Config, dryRun bool) error {
+	db, err := sql.Open("sqlite3", cfg.DBPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	store, err := app.NewImageStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	report, err := app.CollectImages(ctx, db, store, app.ImageGCOptions{GracePeriod: cfg.Images.GCGracePeriod, DryRun: dryRun})
+	if report == nil {
+		return err
+	}
+
+	verb := "deleted     "
+	if report.DryRun {
+		verb = "would delete"
+	}
+	for _, orphan := range report.Deleted {
+		fmt.Printf("%s %s (%d bytes, modified %s)\n", verb, orphan.Key, orphan.Size, orphan.ModTime.Format(time.DateTime))
+	}
+	fmt.Printf("scanned %d images: %d referenced, %d recent, %d orphaned (%d bytes), %d failed\n",
+		report.Scanned, report.Referenced, report.Recent, len(report.Deleted), report.DeletedBytes(), report.Failed)
+	return err
+}
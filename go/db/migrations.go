@@ -0,0 +1,10 @@
+// Package db holds the database schema of the application.
+package db
+
+import "embed"
+
+// Migrations contains the numbered schema migrations,
+// named <version>_<name>.up.sql and <version>_<name>.down.sql.
+//
+//go:embed migrations/*.sql
+var Migrations embed.FS
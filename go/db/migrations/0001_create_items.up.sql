@@ -1,3 +1,4 @@
+-- IF NOT EXISTS lets databases created before schema_migrations existed adopt this version as is.
 CREATE TABLE IF NOT EXISTS categories (
     id INTEGER PRIMARY KEY AUTOINCREMENT,
     name TEXT NOT NULL UNIQUE
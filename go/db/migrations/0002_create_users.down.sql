@@ -0,0 +1,3 @@
+ALTER TABLE items DROP COLUMN seller_id;
+
+DROP TABLE users;
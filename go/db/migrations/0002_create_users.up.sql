@@ -0,0 +1,9 @@
+CREATE TABLE users (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    email TEXT NOT NULL UNIQUE,
+    password_hash TEXT NOT NULL
+);
+
+-- items listed before users existed have no seller.
+ALTER TABLE items ADD COLUMN seller_id INTEGER REFERENCES users(id);
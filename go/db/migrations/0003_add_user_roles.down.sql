@@ -0,0 +1,3 @@
+DROP INDEX idx_items_seller_id;
+
+ALTER TABLE users DROP COLUMN role;
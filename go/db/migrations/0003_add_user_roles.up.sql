@@ -0,0 +1,4 @@
+-- users registered before roles existed keep being able to list items.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'seller' CHECK (role IN ('buyer', 'seller', 'admin'));
+
+CREATE INDEX idx_items_seller_id ON items (seller_id);
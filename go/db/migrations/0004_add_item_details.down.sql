@@ -0,0 +1,8 @@
+DROP INDEX idx_items_created_at;
+
+ALTER TABLE items DROP COLUMN updated_at;
+ALTER TABLE items DROP COLUMN created_at;
+ALTER TABLE items DROP COLUMN condition;
+ALTER TABLE items DROP COLUMN description;
+ALTER TABLE items DROP COLUMN currency;
+ALTER TABLE items DROP COLUMN price;
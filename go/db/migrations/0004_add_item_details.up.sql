@@ -0,0 +1,15 @@
+ALTER TABLE items ADD COLUMN price INTEGER NOT NULL DEFAULT 0 CHECK (price >= 0);
+ALTER TABLE items ADD COLUMN currency TEXT NOT NULL DEFAULT 'JPY';
+ALTER TABLE items ADD COLUMN description TEXT NOT NULL DEFAULT '';
+-- items listed before conditions existed have an empty condition.
+ALTER TABLE items ADD COLUMN condition TEXT NOT NULL DEFAULT '' CHECK (condition IN ('', 'new', 'like-new', 'good', 'fair', 'poor'));
+-- timestamps are fixed-width UTC strings so that they sort in time order.
+ALTER TABLE items ADD COLUMN created_at TEXT NOT NULL DEFAULT '';
+ALTER TABLE items ADD COLUMN updated_at TEXT NOT NULL DEFAULT '';
+
+-- the listing time of existing items is unknown, so they are stamped with the migration time.
+UPDATE items SET
+    created_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now'),
+    updated_at = strftime('%Y-%m-%dT%H:%M:%fZ', 'now');
+
+CREATE INDEX idx_items_created_at ON items (created_at, id);
@@ -0,0 +1,5 @@
+DROP INDEX idx_orders_buyer_id;
+DROP INDEX idx_orders_item_id;
+DROP TABLE orders;
+
+ALTER TABLE items DROP COLUMN status;
@@ -0,0 +1,14 @@
+ALTER TABLE items ADD COLUMN status TEXT NOT NULL DEFAULT 'on_sale' CHECK (status IN ('on_sale', 'trading', 'sold_out'));
+
+CREATE TABLE orders (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL REFERENCES items(id),
+    buyer_id INTEGER NOT NULL REFERENCES users(id),
+    -- price and currency are copied from the item so that later edits of the item do not change the order.
+    price INTEGER NOT NULL CHECK (price >= 0),
+    currency TEXT NOT NULL,
+    created_at TEXT NOT NULL
+);
+
+CREATE INDEX idx_orders_item_id ON orders (item_id);
+CREATE INDEX idx_orders_buyer_id ON orders (buyer_id);
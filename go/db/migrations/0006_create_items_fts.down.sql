@@ -0,0 +1,5 @@
+DROP TRIGGER items_fts_category_update;
+DROP TRIGGER items_fts_delete;
+DROP TRIGGER items_fts_update;
+DROP TRIGGER items_fts_insert;
+DROP TABLE items_fts;
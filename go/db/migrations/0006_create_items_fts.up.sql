@@ -0,0 +1,30 @@
+-- requires: ENABLE_FTS5
+
+-- items_fts indexes the searchable text of items, with the item id as rowid.
+-- The trigram tokenizer matches any substring of three or more characters,
+-- which works for Japanese names that have no spaces between words.
+CREATE VIRTUAL TABLE items_fts USING fts5(name, category, description, tokenize = 'trigram');
+
+INSERT INTO items_fts (rowid, name, category, description)
+SELECT items.id, items.name, categories.name, items.description
+FROM items
+JOIN categories ON items.category_id = categories.id;
+
+CREATE TRIGGER items_fts_insert AFTER INSERT ON items BEGIN
+    INSERT INTO items_fts (rowid, name, category, description)
+    VALUES (new.id, new.name, (SELECT name FROM categories WHERE id = new.category_id), new.description);
+END;
+
+CREATE TRIGGER items_fts_update AFTER UPDATE OF name, category_id, description ON items BEGIN
+    UPDATE items_fts
+    SET name = new.name, category = (SELECT name FROM categories WHERE id = new.category_id), description = new.description
+    WHERE rowid = new.id;
+END;
+
+CREATE TRIGGER items_fts_delete AFTER DELETE ON items BEGIN
+    DELETE FROM items_fts WHERE rowid = old.id;
+END;
+
+CREATE TRIGGER items_fts_category_update AFTER UPDATE OF name ON categories BEGIN
+    UPDATE items_fts SET category = new.name WHERE rowid IN (SELECT id FROM items WHERE category_id = new.id);
+END;
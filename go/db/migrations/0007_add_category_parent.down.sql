@@ -0,0 +1,4 @@
+DROP INDEX idx_items_category_id;
+DROP INDEX idx_categories_parent_id;
+
+ALTER TABLE categories DROP COLUMN parent_id;
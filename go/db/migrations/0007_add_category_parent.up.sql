@@ -0,0 +1,6 @@
+-- parent_id nests a category under another one. Names stay unique across the whole tree
+-- because items refer to their category by name.
+ALTER TABLE categories ADD COLUMN parent_id INTEGER REFERENCES categories(id);
+
+CREATE INDEX idx_categories_parent_id ON categories (parent_id);
+CREATE INDEX idx_items_category_id ON items (category_id);
@@ -0,0 +1,2 @@
+DROP INDEX idx_item_images_item_id;
+DROP TABLE item_images;
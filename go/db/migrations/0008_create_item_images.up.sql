@@ -0,0 +1,14 @@
+-- item_images holds the photos of each item, shown in ascending position.
+-- items.image_name keeps the name of the first one for clients that only know a single image.
+CREATE TABLE item_images (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    item_id INTEGER NOT NULL REFERENCES items(id),
+    position INTEGER NOT NULL,
+    image_name TEXT NOT NULL,
+    UNIQUE (item_id, image_name)
+);
+
+CREATE INDEX idx_item_images_item_id ON item_images (item_id, position);
+
+INSERT INTO item_images (item_id, position, image_name)
+SELECT id, 0, image_name FROM items WHERE image_name != '';
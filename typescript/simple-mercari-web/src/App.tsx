@@ -1,11 +1,15 @@
 import { useState } from 'react';
 import './App.css';
+import { clearToken, getToken } from '~/api';
 import { ItemList } from '~/components/ItemList';
 import { Listing } from '~/components/Listing';
+import { Login } from '~/components/Login';
 
 function App() {
   // reload ItemList after Listing complete
   const [reload, setReload] = useState(true);
+  // listing items needs a logged in seller
+  const [loggedIn, setLoggedIn] = useState(getToken() !== null);
   return (
     <div>
       <header className="Title">
@@ -14,7 +18,20 @@ function App() {
         </p>
       </header>
       <div>
-        <Listing onListingCompleted={() => setReload(true)} />
+        {loggedIn ? (
+          <Listing
+            onListingCompleted={() => {
+              setReload(true);
+              setLoggedIn(getToken() !== null);
+            }}
+            onLogout={() => {
+              clearToken();
+              setLoggedIn(false);
+            }}
+          />
+        ) : (
+          <Login onLoggedIn={() => setLoggedIn(true)} />
+        )}
       </div>
       <div>
         <ItemList reload={reload} onLoadCompleted={() => setReload(false)} />
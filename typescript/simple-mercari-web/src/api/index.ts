@@ -9,6 +9,7 @@ export interface Item {
 
 export interface ItemListResponse {
   items: Item[];
+  next_cursor?: string;
 }
 
 export const fetchItems = async (): Promise<ItemListResponse> => {
@@ -27,9 +28,77 @@ export const fetchItems = async (): Promise<ItemListResponse> => {
   return response.json();
 };
 
+const TOKEN_KEY = 'token';
+
+// getToken returns the token of the logged in user, or null when nobody is logged in.
+export const getToken = (): string | null => localStorage.getItem(TOKEN_KEY);
+
+export const clearToken = () => localStorage.removeItem(TOKEN_KEY);
+
+export interface RegisterUserInput {
+  name: string;
+  email: string;
+  password: string;
+}
+
+// registerUser creates a seller account, so that the user can list items.
+export const registerUser = async (input: RegisterUserInput): Promise<void> => {
+  const data = new URLSearchParams({
+    name: input.name,
+    email: input.email,
+    password: input.password,
+    role: 'seller',
+  });
+  const response = await fetch(`${SERVER_URL}/users`, {
+    method: 'POST',
+    mode: 'cors',
+    body: data,
+  });
+
+  if (response.status >= 400) {
+    throw new Error('Failed to register the user');
+  }
+};
+
+export interface LoginInput {
+  email: string;
+  password: string;
+}
+
+export interface LoginResponse {
+  token: string;
+  expires_at: string;
+}
+
+// login logs the user in and keeps the token for the requests that need it.
+export const login = async (input: LoginInput): Promise<LoginResponse> => {
+  const data = new URLSearchParams({
+    email: input.email,
+    password: input.password,
+  });
+  const response = await fetch(`${SERVER_URL}/login`, {
+    method: 'POST',
+    mode: 'cors',
+    body: data,
+  });
+
+  if (response.status >= 400) {
+    throw new Error('Failed to log in');
+  }
+  const body: LoginResponse = await response.json();
+  localStorage.setItem(TOKEN_KEY, body.token);
+  return body;
+};
+
+// Condition is the condition of a listed item, from the best to the worst.
+export const CONDITIONS = ['new', 'like-new', 'good', 'fair', 'poor'] as const;
+export type Condition = (typeof CONDITIONS)[number];
+
 export interface CreateItemInput {
   name: string;
   category: string;
+  price: number;
+  condition: Condition;
   image: string | File;
 }
 
@@ -37,13 +106,22 @@ export const postItem = async (input: CreateItemInput): Promise<Response> => {
   const data = new FormData();
   data.append('name', input.name);
   data.append('category', input.category);
+  data.append('price', String(input.price));
+  data.append('condition', input.condition);
   data.append('image', input.image);
   const response = await fetch(`${SERVER_URL}/items`, {
     method: 'POST',
     mode: 'cors',
+    headers: {
+      Authorization: `Bearer ${getToken()}`,
+    },
     body: data,
   });
 
+  if (response.status === 401) {
+    // the token expired or the server was restarted with another secret
+    clearToken();
+  }
   if (response.status >= 400) {
     throw new Error('Failed to post item to the server');
   }
@@ -1,27 +1,35 @@
 import { useRef, useState } from 'react';
-import { postItem } from '~/api';
+import { CONDITIONS, type Condition, postItem } from '~/api';
 
 interface Prop {
   onListingCompleted: () => void;
+  onLogout: () => void;
 }
 
 type FormDataType = {
   name: string;
   category: string;
+  // price is kept as typed, and converted to a number on submit
+  price: string;
+  condition: Condition | '';
   image: string | File;
 };
 
-export const Listing = ({ onListingCompleted }: Prop) => {
-  const initialState = {
+export const Listing = ({ onListingCompleted, onLogout }: Prop) => {
+  const initialState: FormDataType = {
     name: '',
     category: '',
+    price: '',
+    condition: '',
     image: '',
   };
   const [values, setValues] = useState<FormDataType>(initialState);
 
   const uploadImageRef = useRef<HTMLInputElement>(null);
 
-  const onValueChange = (event: React.ChangeEvent<HTMLInputElement>) => {
+  const onValueChange = (
+    event: React.ChangeEvent<HTMLInputElement | HTMLSelectElement>,
+  ) => {
     setValues({
       ...values,
       [event.target.name]: event.target.value,
@@ -47,10 +55,17 @@ export const Listing = ({ onListingCompleted }: Prop) => {
       return;
     }
 
+    if (!values.condition) {
+      alert('Missing fields: condition');
+      return;
+    }
+
     // Submit the form
     postItem({
       name: values.name,
       category: values.category,
+      price: Number(values.price),
+      condition: values.condition,
       image: values.image,
     })
       .then(() => {
@@ -89,6 +104,33 @@ export const Listing = ({ onListingCompleted }: Prop) => {
             onChange={onValueChange}
             value={values.category}
           />
+          <input
+            type="number"
+            name="price"
+            id="price"
+            placeholder="price"
+            min="0"
+            step="1"
+            onChange={onValueChange}
+            required
+            value={values.price}
+          />
+          <select
+            name="condition"
+            id="condition"
+            onChange={onValueChange}
+            required
+            value={values.condition}
+          >
+            <option value="" disabled>
+              condition
+            </option>
+            {CONDITIONS.map((condition) => (
+              <option key={condition} value={condition}>
+                {condition}
+              </option>
+            ))}
+          </select>
           <input
             type="file"
             name="image"
@@ -98,6 +140,9 @@ export const Listing = ({ onListingCompleted }: Prop) => {
             ref={uploadImageRef}
           />
           <button type="submit">List this item</button>
+          <button type="button" onClick={onLogout}>
+            Log out
+          </button>
         </div>
       </form>
     </div>
@@ -0,0 +1,89 @@
+import { useState } from 'react';
+import { login, registerUser } from '~/api';
+
+interface Prop {
+  onLoggedIn: () => void;
+}
+
+type FormDataType = {
+  name: string;
+  email: string;
+  password: string;
+};
+
+export const Login = ({ onLoggedIn }: Prop) => {
+  const initialState = {
+    name: '',
+    email: '',
+    password: '',
+  };
+  const [values, setValues] = useState<FormDataType>(initialState);
+  // registering asks for a name too, and logs the new user in
+  const [registering, setRegistering] = useState(false);
+
+  const onValueChange = (event: React.ChangeEvent<HTMLInputElement>) => {
+    setValues({
+      ...values,
+      [event.target.name]: event.target.value,
+    });
+  };
+  const onSubmit = (event: React.FormEvent<HTMLFormElement>) => {
+    event.preventDefault();
+
+    const credentials = { email: values.email, password: values.password };
+    const registered = registering
+      ? registerUser({ name: values.name, ...credentials })
+      : Promise.resolve();
+    registered
+      .then(() => login(credentials))
+      .then(() => {
+        setValues(initialState);
+        onLoggedIn();
+      })
+      .catch((error) => {
+        console.error('POST error:', error);
+        alert(registering ? 'Failed to sign up' : 'Failed to log in');
+      });
+  };
+  return (
+    <div className="Listing">
+      <form onSubmit={onSubmit}>
+        <div>
+          {registering && (
+            <input
+              type="text"
+              name="name"
+              id="name"
+              placeholder="name"
+              onChange={onValueChange}
+              required
+              value={values.name}
+            />
+          )}
+          <input
+            type="email"
+            name="email"
+            id="email"
+            placeholder="email"
+            onChange={onValueChange}
+            required
+            value={values.email}
+          />
+          <input
+            type="password"
+            name="password"
+            id="password"
+            placeholder="password"
+            onChange={onValueChange}
+            required
+            value={values.password}
+          />
+          <button type="submit">{registering ? 'Sign up' : 'Log in'}</button>
+          <button type="button" onClick={() => setRegistering(!registering)}>
+            {registering ? 'I have an account' : 'Create an account'}
+          </button>
+        </div>
+      </form>
+    </div>
+  );
+};